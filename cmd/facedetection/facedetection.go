@@ -2,20 +2,31 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/bokan/facedetection/pkg/api"
+	"github.com/bokan/facedetection/pkg/download/coalescedownloader"
 	"github.com/bokan/facedetection/pkg/download/httpdownloader"
+	"github.com/bokan/facedetection/pkg/download/retrydownloader"
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/facedetect/ensemblefacedetect"
 	"github.com/bokan/facedetection/pkg/facedetect/pigofacedetect"
+	"github.com/bokan/facedetection/pkg/facedetect/pluginfacedetect"
+	"github.com/bokan/facedetection/pkg/facedetect/poolfacedetect"
+	"github.com/bokan/facedetection/pkg/facedetect/registry"
+	"github.com/bokan/facedetection/pkg/facedetect/reloadfacedetect"
 	"github.com/bokan/facedetection/pkg/httpcache"
 	"github.com/bokan/facedetection/pkg/httpcache/cachestore/memorycachestore"
 	"github.com/bokan/facedetection/pkg/requestlog"
@@ -26,59 +37,266 @@ import (
 const (
 	exitCodeError = 1
 	maxFileSize   = 1 << 21 // 2 MiB
+
+	// defaultDetector is the name of the detector using the cascades given with -c.
+	defaultDetector = "pigo"
 )
 
-func locateCascades(goPath string) string {
-	paths := strings.Split(goPath, ":")
-	for _, path := range paths {
-		tryPath := filepath.Join(path, "src/github.com/bokan/facedetection/pkg/facedetect/pigofacedetect/cascades")
-		if _, err := os.Stat(tryPath); err == nil {
-			return tryPath
-		}
+// detectorFlag is a pigo detector configured with a -d flag.
+type detectorFlag struct {
+	name string
+	dir  string
+}
+
+// detectorFlags collects the repeated -d flags, in the name=dir form.
+type detectorFlags []detectorFlag
+
+func (d *detectorFlags) String() string {
+	var s []string
+	for _, f := range *d {
+		s = append(s, f.name+"="+f.dir)
 	}
-	return ""
+	return strings.Join(s, ",")
 }
 
-func goPath(envGoPath string) (string, error) {
-	goPath := envGoPath
-	if goPath == "" {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return "", err
-		}
-		goPath = filepath.Join(home, "go")
+func (d *detectorFlags) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i < 1 || i == len(v)-1 {
+		return fmt.Errorf("detector must be given as name=cascades_dir")
 	}
-	return goPath, nil
+	*d = append(*d, detectorFlag{name: v[:i], dir: v[i+1:]})
+	return nil
 }
 
-func run(ctx context.Context, args []string, output io.Writer) error {
-	log := initLogger(output)
+// listFlags collects the values of a repeated flag.
+type listFlags []string
 
-	goPath, err := goPath(os.Getenv("GOPATH"))
-	if err != nil {
-		return err
+func (l *listFlags) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlags) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// pluginFlag is a plugin detector configured with a -x flag.
+type pluginFlag struct {
+	name    string
+	command []string
+}
+
+// pluginFlags collects the repeated -x flags, in the name=command form.
+type pluginFlags []pluginFlag
+
+func (x *pluginFlags) String() string {
+	var s []string
+	for _, f := range *x {
+		s = append(s, f.name+"="+strings.Join(f.command, " "))
 	}
+	return strings.Join(s, ",")
+}
+
+func (x *pluginFlags) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i < 1 || len(strings.Fields(v[i+1:])) == 0 {
+		return fmt.Errorf("plugin must be given as name=command")
+	}
+	*x = append(*x, pluginFlag{name: v[:i], command: strings.Fields(v[i+1:])})
+	return nil
+}
+
+// ensembleFlag is an ensemble of detectors configured with a -e flag.
+type ensembleFlag struct {
+	name     string
+	children []string
+}
+
+// ensembleFlags collects the repeated -e flags, in the name=detector1+detector2 form.
+type ensembleFlags []ensembleFlag
+
+func (e *ensembleFlags) String() string {
+	var s []string
+	for _, f := range *e {
+		s = append(s, f.name+"="+strings.Join(f.children, "+"))
+	}
+	return strings.Join(s, ",")
+}
+
+func (e *ensembleFlags) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i < 1 || i == len(v)-1 {
+		return fmt.Errorf("ensemble must be given as name=detector1+detector2")
+	}
+	*e = append(*e, ensembleFlag{name: v[:i], children: strings.Split(v[i+1:], "+")})
+	return nil
+}
+
+// run starts the service, checking every pigo detector with selfTest once its cascades are loaded.
+func run(ctx context.Context, args []string, output io.Writer, selfTest reloadfacedetect.Validator) error {
+	log := initLogger(output)
+
 	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
 	var (
-		port         = flags.Int("p", 8000, "configure listen port")
-		cascadesPath = flags.String("c", locateCascades(goPath), "configure cascades path")
+		port          = flags.Int("p", 8000, "configure listen port")
+		cascadesPath  = flags.String("c", "", "configure cascades path, overriding the embedded cascades")
+		adminAddr     = flags.String("a", "", "configure admin listen address, e.g. 127.0.0.1:8001, disabled if empty")
+		workers       = flags.Int("w", runtime.NumCPU(), "configure the number of concurrent face detections")
+		queueDepth    = flags.Int("q", 2*runtime.NumCPU(), "configure the number of face detections waiting for a worker")
+		queueWait     = flags.Duration("qt", 2*time.Second, "configure the maximum time a face detection waits for a worker")
+		pluginTimeout = flags.Duration("xt", 4*time.Second, "configure the maximum time a plugin detection runs for, 0 for no limit")
+		detectors     detectorFlags
+		plugins       pluginFlags
+		allowedCIDRs  listFlags
+		ensembles     ensembleFlags
 	)
+	flags.Var(&detectors, "d", "register one more pigo detector as name=cascades_dir, selected with the detector parameter, can be repeated")
+	flags.Var(&allowedCIDRs, "ac", "allow downloading images from a non-public network given in CIDR notation, e.g. 10.1.0.0/16, can be repeated")
+	flags.Var(&plugins, "x", "register a plugin detector as name=command, the command speaking the pluginfacedetect protocol, can be repeated")
+	flags.Var(&ensembles, "e", "register an ensemble merging the faces of detectors as name=detector1+detector2, can be repeated")
 	flags.SetOutput(output)
 	if err := flags.Parse(args[1:]); err != nil {
 		return err
 	}
+	if *workers < 1 || *queueDepth < 0 || *queueWait < 0 {
+		err := fmt.Errorf("workers must be positive, queue depth and wait must not be negative")
+		log.Errorw("Invalid detection pool configuration", "err", err)
+		return err
+	}
+
+	if *pluginTimeout < 0 {
+		err := fmt.Errorf("plugin timeout must not be negative")
+		log.Errorw("Invalid plugin configuration", "err", err)
+		return err
+	}
 
-	d := httpdownloader.NewHTTPDownloader(http.DefaultClient, time.Second*5, maxFileSize)
-	fd := pigofacedetect.NewPigoFaceDetector()
-	if err := fd.LoadCascades(*cascadesPath); err != nil {
-		log.Errorw("PigoFaceDetector was unable to load cascades, provide cascade dir with -c flag", "dir", *cascadesPath)
+	guard, err := httpdownloader.NewAddressGuard(allowedCIDRs...)
+	if err != nil {
+		log.Errorw("Invalid network given with -ac flag", "err", err)
+		return err
+	}
+	d := retrydownloader.NewRetryDownloader(
+		httpdownloader.NewHTTPDownloader(httpdownloader.NewClient(guard), time.Second*5, maxFileSize),
+		maxFileSize,
+		retrydownloader.DefaultOptions(),
+		func(kv map[string]interface{}) {
+			var args []interface{}
+			for k, v := range kv {
+				args = append(args, k, v)
+			}
+			log.Infow("Image download failed, retrying", args...)
+		},
+	)
+	publishRetryStats(d)
+	// Concurrent requests for the same image share a single download.
+	cd := coalescedownloader.NewCoalesceDownloader(d, maxFileSize)
+	fd, err := reloadfacedetect.NewReloadFaceDetector(ctx, cascadeLoader(*cascadesPath), selfTest)
+	if err != nil {
+		log.Errorw("PigoFaceDetector was unable to load cascades, check the cascade dir given with -c flag", "dir", *cascadesPath, "err", err)
 		return err
 	}
-	a := api.NewAPI(fmt.Sprintf(":%d", *port), d, fd)
+	pool := poolfacedetect.NewPoolFaceDetector(fd, *workers, *queueDepth, *queueWait)
+	publishPoolStats(pool)
+
+	// Every detector is reloaded together and shares the workers of the pool.
+	reg := registry.NewRegistry()
+	reloaders := map[string]*reloadfacedetect.ReloadFaceDetector{defaultDetector: fd}
+	dirs := map[string]string{defaultDetector: *cascadesPath}
+	_ = reg.Register(defaultDetector, pigofacedetect.Version, pool)
+	for _, df := range detectors {
+		rfd, err := reloadfacedetect.NewReloadFaceDetector(ctx, cascadeLoader(df.dir), selfTest)
+		if err != nil {
+			log.Errorw("PigoFaceDetector was unable to load cascades, check the cascade dir given with -d flag", "detector", df.name, "dir", df.dir, "err", err)
+			return err
+		}
+		if err := reg.Register(df.name, pigofacedetect.Version, pool.Wrap(rfd)); err != nil {
+			log.Errorw("Invalid detector name given with -d flag", "detector", df.name, "err", err)
+			return err
+		}
+		reloaders[df.name], dirs[df.name] = rfd, df.dir
+	}
+	// The detectors ensembles can be made of, running outside of the pool.
+	children := map[string]facedetect.FaceDetector{}
+	for name, rfd := range reloaders {
+		children[name] = rfd
+	}
+	// Request contexts don't end with the write timeout of the server, plugins are given a time
+	// limit of their own, or a hung one would hold its worker until the client goes away.
+	for _, xf := range plugins {
+		pfd, err := pluginfacedetect.NewPluginFaceDetector(pluginfacedetect.Config{
+			Path:        xf.command[0],
+			Args:        xf.command[1:],
+			Stderr:      output,
+			Processes:   *workers,
+			CallTimeout: *pluginTimeout,
+		})
+		if err != nil {
+			log.Errorw("Plugin given with -x flag can't be run", "detector", xf.name, "command", xf.command, "err", err)
+			return err
+		}
+		defer pfd.Close()
+		if err := reg.Register(xf.name, pluginfacedetect.Version, pool.Wrap(pfd)); err != nil {
+			log.Errorw("Invalid detector name given with -x flag", "detector", xf.name, "err", err)
+			return err
+		}
+		children[xf.name] = pfd
+	}
+	// Ensembles take a single worker, their children run concurrently within it.
+	for _, ef := range ensembles {
+		var members []ensemblefacedetect.Child
+		for _, name := range ef.children {
+			fd, ok := children[name]
+			if !ok {
+				err := fmt.Errorf("%w: %s", registry.ErrUnknownDetector, name)
+				log.Errorw("Unknown detector in ensemble given with -e flag", "ensemble", ef.name, "err", err)
+				return err
+			}
+			members = append(members, ensemblefacedetect.Child{Name: name, FaceDetector: fd})
+		}
+		efd := ensemblefacedetect.NewEnsembleFaceDetector(members, ensemblefacedetect.DefaultOptions())
+		if err := reg.Register(ef.name, ensemblefacedetect.Version, pool.Wrap(efd)); err != nil {
+			log.Errorw("Invalid ensemble name given with -e flag", "ensemble", ef.name, "err", err)
+			return err
+		}
+	}
+
+	reload := api.ReloaderFunc(func(ctx context.Context) error {
+		var failed error
+		for _, name := range reg.Names() {
+			rfd, ok := reloaders[name]
+			if !ok {
+				continue
+			}
+			if err := rfd.Reload(ctx); err != nil {
+				log.Errorw("Cascade reload failed, keeping the current cascades", "detector", name, "dir", dirs[name], "err", err)
+				failed = err
+				continue
+			}
+			// Responses cached with the previous cascades mustn't be served anymore.
+			reg.Reloaded()
+			log.Infow("Cascades reloaded", "detector", name, "dir", dirs[name])
+		}
+		return failed
+	})
+	reloadOnHangup(ctx, reload)
 
-	cache := httpcache.NewHTTPCache(memorycachestore.NewMemoryCacheStore()).Middleware()
+	a := api.NewAPI(fmt.Sprintf(":%d", *port), cd, reg)
+
+	hc := httpcache.NewHTTPCache(memorycachestore.NewMemoryCacheStore(), a.CacheKey)
+	hc.SetAlias(a.CacheAlias)
+	cache := hc.Middleware()
 	rl := requestLogger(log)
 
+	if *adminAddr != "" {
+		admin := api.NewAdmin(*adminAddr, reload)
+		log.Infow("Starting admin service", "addr", *adminAddr)
+		go func() {
+			if err := admin.Serve(ctx, rl(admin.Routes())); err != nil && err != http.ErrServerClosed {
+				log.Errorw("Admin Serve() error", "err", err)
+			}
+		}()
+	}
+
 	log.Infow("Starting service", "port", *port)
 	if err := a.Serve(ctx, rl(cache(a.Routes()))); err != nil {
 		if err == http.ErrServerClosed {
@@ -91,6 +309,68 @@ func run(ctx context.Context, args []string, output io.Writer) error {
 	return nil
 }
 
+// cascadeLoader returns a loader of PigoFaceDetector using the cascades in dir, or the embedded
+// cascades when dir is empty.
+func cascadeLoader(dir string) reloadfacedetect.Loader {
+	return func() (facedetect.FaceDetector, error) {
+		fd := pigofacedetect.NewPigoFaceDetector()
+		if dir == "" {
+			return fd, fd.LoadCascadesFS(pigofacedetect.DefaultCascades())
+		}
+		return fd, fd.LoadCascades(dir)
+	}
+}
+
+// poolStats is the expvar holding the current detection pool. The pool is replaced when run is
+// called again, as expvar variables can't be published twice.
+var poolStats struct {
+	sync.Once
+	pool atomic.Value
+}
+
+// publishPoolStats publishes the counters of pool as the "detection_pool" expvar.
+func publishPoolStats(pool *poolfacedetect.PoolFaceDetector) {
+	poolStats.pool.Store(pool)
+	poolStats.Do(func() {
+		expvar.Publish("detection_pool", expvar.Func(func() interface{} {
+			return poolStats.pool.Load().(*poolfacedetect.PoolFaceDetector).Stats()
+		}))
+	})
+}
+
+// retryStats is the expvar holding the current retrying downloader, replaced like poolStats.
+var retryStats struct {
+	sync.Once
+	d atomic.Value
+}
+
+// publishRetryStats publishes the counters of d as the "download_retries" expvar.
+func publishRetryStats(d *retrydownloader.RetryDownloader) {
+	retryStats.d.Store(d)
+	retryStats.Do(func() {
+		expvar.Publish("download_retries", expvar.Func(func() interface{} {
+			return retryStats.d.Load().(*retrydownloader.RetryDownloader).Stats()
+		}))
+	})
+}
+
+// reloadOnHangup calls rl.Reload every time the process receives SIGHUP, until ctx ends.
+func reloadOnHangup(ctx context.Context, rl api.Reloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				_ = rl.Reload(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
 func initLogger(sync io.Writer) *zap.SugaredLogger {
 	ce := zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
 	c := zapcore.NewCore(ce, zapcore.AddSync(sync), zap.DebugLevel)
@@ -125,7 +405,7 @@ func main() {
 		}
 	}()
 
-	if err := run(ctx, os.Args, os.Stdout); err != nil {
+	if err := run(ctx, os.Args, os.Stdout, pigofacedetect.SelfTest); err != nil {
 		os.Exit(exitCodeError)
 	}
 
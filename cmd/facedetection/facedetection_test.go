@@ -6,8 +6,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/bokan/facedetection/pkg/api"
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/facedetect/pigofacedetect"
 )
 
 func Test_run(t *testing.T) {
@@ -21,11 +26,91 @@ func Test_run(t *testing.T) {
 			args:    []string{"facedetection", "-c", "../../pkg/facedetect/pigofacedetect/cascades", "-p", "0"},
 			wantErr: false,
 		},
+		{
+			name:    "embedded cascades",
+			args:    []string{"facedetection", "-p", "0"},
+			wantErr: false,
+		},
+		{
+			name:    "admin service",
+			args:    []string{"facedetection", "-p", "0", "-a", "127.0.0.1:0"},
+			wantErr: false,
+		},
+		{
+			name:    "more detectors",
+			args:    []string{"facedetection", "-p", "0", "-d", "custom=../../pkg/facedetect/pigofacedetect/cascades"},
+			wantErr: false,
+		},
+		{
+			name:    "ensemble",
+			args:    []string{"facedetection", "-p", "0", "-d", "custom=../../pkg/facedetect/pigofacedetect/cascades", "-e", "both=pigo+custom"},
+			wantErr: false,
+		},
+		{
+			name:    "plugin",
+			args:    []string{"facedetection", "-p", "0", "-x", "cat=cat -u", "-e", "both=pigo+cat"},
+			wantErr: false,
+		},
+		{
+			name:    "make negative plugin timeout fail",
+			args:    []string{"facedetection", "-p", "0", "-x", "cat=cat -u", "-xt", "-1s"},
+			wantErr: true,
+		},
+		{
+			name:    "make plugin flag parse fail",
+			args:    []string{"facedetection", "-p", "0", "-x", "plugin= "},
+			wantErr: true,
+		},
+		{
+			name:    "make missing plugin fail",
+			args:    []string{"facedetection", "-p", "0", "-x", "plugin=/wrong/plugin"},
+			wantErr: true,
+		},
+		{
+			name:    "make ensemble flag parse fail",
+			args:    []string{"facedetection", "-p", "0", "-e", "both"},
+			wantErr: true,
+		},
+		{
+			name:    "make ensemble of unknown detector fail",
+			args:    []string{"facedetection", "-p", "0", "-e", "both=pigo+custom"},
+			wantErr: true,
+		},
+		{
+			name:    "make detector flag parse fail",
+			args:    []string{"facedetection", "-p", "0", "-d", "custom"},
+			wantErr: true,
+		},
+		{
+			name:    "make duplicate detector fail",
+			args:    []string{"facedetection", "-p", "0", "-d", "pigo=../../pkg/facedetect/pigofacedetect/cascades"},
+			wantErr: true,
+		},
+		{
+			name:    "make detector cascade load fail",
+			args:    []string{"facedetection", "-p", "0", "-d", "custom=/wrongdir"},
+			wantErr: true,
+		},
+		{
+			name:    "allowed networks",
+			args:    []string{"facedetection", "-p", "0", "-ac", "10.1.0.0/16", "-ac", "fd00::/64"},
+			wantErr: false,
+		},
+		{
+			name:    "make allowed network parse fail",
+			args:    []string{"facedetection", "-p", "0", "-ac", "10.1.0.0"},
+			wantErr: true,
+		},
 		{
 			name:    "make flag parse fail",
 			args:    []string{"facedetection", "-x"},
 			wantErr: true,
 		},
+		{
+			name:    "make pool configuration fail",
+			args:    []string{"facedetection", "-p", "0", "-w", "0"},
+			wantErr: true,
+		},
 		{
 			name:    "make pigo cascade load fail",
 			args:    []string{"facedetection", "-c", "/wrongdir", "-p", "0"},
@@ -42,7 +127,8 @@ func Test_run(t *testing.T) {
 			output := &bytes.Buffer{}
 			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
 			_ = cancel
-			err := run(ctx, tt.args, output)
+			// The self-test detection would take most of the time the server runs for.
+			err := run(ctx, tt.args, output, nil)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("run() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -51,6 +137,16 @@ func Test_run(t *testing.T) {
 	}
 }
 
+func Test_runSelfTestFailure(t *testing.T) {
+	failing := func(context.Context, facedetect.FaceDetector) error { return pigofacedetect.ErrSelfTestFailed }
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if err := run(ctx, []string{"facedetection", "-p", "0"}, &bytes.Buffer{}, failing); err == nil {
+		t.Error("run() should fail when the detector fails the self-test")
+	}
+}
+
 // TODO: Write better test
 func Test_requestLogger(t *testing.T) {
 	output := &bytes.Buffer{}
@@ -66,23 +162,36 @@ func Test_requestLogger(t *testing.T) {
 	rl(handler).ServeHTTP(rec, r)
 }
 
-func Test_locateCascades(t *testing.T) {
-	path, err := goPath(os.Getenv("GOPATH"))
-	if err != nil {
-		t.Errorf("unable to find GOPATH: %v", err)
-		return
+func Test_reloadOnHangup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloaded := make(chan struct{}, 1)
+	reloadOnHangup(ctx, api.ReloaderFunc(func(ctx context.Context) error {
+		reloaded <- struct{}{}
+		return nil
+	}))
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
 	}
-	if locateCascades(path) == "" {
-		t.Error("locateCascades() should return a path")
+	select {
+	case <-reloaded:
+	case <-time.After(time.Second):
+		t.Error("SIGHUP should trigger a reload")
 	}
 }
 
-func Test_goPath(t *testing.T) {
-	path, err := goPath("")
-	if err != nil {
-		t.Errorf("unable to find GOPATH: %v", err)
-		return
+func Test_cascadeLoader(t *testing.T) {
+	for _, dir := range []string{"", "../../pkg/facedetect/pigofacedetect/cascades"} {
+		fd, err := cascadeLoader(dir)()
+		if err != nil {
+			t.Fatalf("cascadeLoader(%q) error = %v", dir, err)
+		}
+		if err := pigofacedetect.SelfTest(context.Background(), fd); err != nil {
+			t.Errorf("cascadeLoader(%q) detector failed the self-test: %v", dir, err)
+		}
+	}
+	if _, err := cascadeLoader("/wrongdir")(); err == nil {
+		t.Error("cascadeLoader() should fail for a missing directory")
 	}
-	// TODO: Find a better way to test this.
-	_ = path
 }
@@ -3,7 +3,6 @@ package integration
 import (
 	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -15,6 +14,7 @@ import (
 	"github.com/bokan/facedetection/pkg/api"
 	"github.com/bokan/facedetection/pkg/download/httpdownloader"
 	"github.com/bokan/facedetection/pkg/facedetect/pigofacedetect"
+	"github.com/bokan/facedetection/pkg/facedetect/registry"
 )
 
 var (
@@ -29,7 +29,11 @@ func TestAPI(t *testing.T) {
 	if err := fd.LoadCascades("../pkg/facedetect/pigofacedetect/cascades"); err != nil {
 		t.Fatal(err)
 	}
-	a := api.NewAPI("", d, fd)
+	detectors := registry.NewRegistry()
+	if err := detectors.Register("pigo", pigofacedetect.Version, fd); err != nil {
+		t.Fatal(err)
+	}
+	a := api.NewAPI("", d, detectors)
 
 	apis := httptest.NewServer(a.Routes())
 	fn := "people001.jpg"
@@ -44,11 +48,9 @@ func TestAPI(t *testing.T) {
 		t.Error(err)
 	}
 
-	got := make([]byte, 4096)
-	if _, err := resp.Body.Read(got); err != nil {
-		if err != io.EOF {
-			t.Error(err)
-		}
+	got, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Error(err)
 	}
 
 	gf := path.Join("testdata", fn+".json")
@@ -0,0 +1,109 @@
+package align
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// ErrNoEyes is returned by Align when the face lacks one of the eyes, or both eyes are at the
+// same position.
+var ErrNoEyes = fmt.Errorf("face eyes not located")
+
+// Options control the layout of the face chips.
+type Options struct {
+	// Size is the width and height of the chips, in pixels.
+	Size int
+	// EyeY is the vertical position of the eyes, as a fraction of Size.
+	EyeY float64
+	// EyeDistance is the distance between the eyes, as a fraction of Size. The eyes are
+	// centered horizontally.
+	EyeDistance float64
+}
+
+// DefaultOptions returns the layout used unless told otherwise, close to the one face
+// recognition models are commonly trained on.
+func DefaultOptions() Options {
+	return Options{
+		Size:        112,
+		EyeY:        0.45,
+		EyeDistance: 0.3,
+	}
+}
+
+// Chip is a face rotated and scaled to put the eyes at the positions given by Options.
+type Chip struct {
+	image.Image
+
+	// Roll is the in-plane rotation of the face in degrees, positive values meaning
+	// counter-clockwise rotation, as in facedetect.Face.
+	Roll float64
+	// EyeDistance is the distance between the eyes on the source image, in pixels.
+	EyeDistance float64
+	// Region is the bounding box of the chip on the source image.
+	Region image.Rectangle
+}
+
+// Align cuts the chip of face f out of img. Face coordinates are relative to the top left corner
+// of img, and so is the returned Region. Parts of the chip outside of img are black.
+func Align(img image.Image, f facedetect.Face, opts Options) (*Chip, error) {
+	if f.LeftEye == nil || f.RightEye == nil {
+		return nil, ErrNoEyes
+	}
+	dx := float64(f.RightEye.X - f.LeftEye.X)
+	dy := float64(f.RightEye.Y - f.LeftEye.Y)
+	dist := math.Hypot(dx, dy)
+	if dist == 0 {
+		return nil, ErrNoEyes
+	}
+
+	// Source to chip transform: rotate the eyes level around their midpoint, scale them to the
+	// requested distance and move the midpoint to its place. Pixel centers are at half.
+	ib := img.Bounds()
+	size := float64(opts.Size)
+	s := opts.EyeDistance * size / dist
+	cos, sin := dx/dist, dy/dist
+	mx := float64(f.LeftEye.X+f.RightEye.X)/2 + 0.5 + float64(ib.Min.X)
+	my := float64(f.LeftEye.Y+f.RightEye.Y)/2 + 0.5 + float64(ib.Min.Y)
+	a, b, c, d := s*cos, s*sin, -s*sin, s*cos
+	s2d := f64.Aff3{
+		a, b, size/2 - (a*mx + b*my),
+		c, d, opts.EyeY*size - (c*mx + d*my),
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, opts.Size, opts.Size))
+	draw.Draw(dst, dst.Rect, image.NewUniform(color.Black), image.Point{}, draw.Src)
+	draw.CatmullRom.Transform(dst, s2d, img, ib, draw.Over, nil)
+
+	return &Chip{
+		Image:       dst,
+		Roll:        -math.Atan2(dy, dx) * 180 / math.Pi,
+		EyeDistance: dist,
+		Region:      region(s2d, size).Sub(ib.Min),
+	}, nil
+}
+
+// region returns the bounding box on the source image of the size by size chip placed by s2d.
+func region(s2d f64.Aff3, size float64) image.Rectangle {
+	// Invert the similarity transform, whose linear part is s times a rotation.
+	a, b, c, d := s2d[0], s2d[1], s2d[3], s2d[4]
+	det := a*d - b*c
+	toSrc := func(x, y float64) (float64, float64) {
+		x, y = x-s2d[2], y-s2d[5]
+		return (d*x - b*y) / det, (-c*x + a*y) / det
+	}
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range [][2]float64{{0, 0}, {size, 0}, {0, size}, {size, size}} {
+		x, y := toSrc(p[0], p[1])
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	return image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY)))
+}
@@ -0,0 +1,100 @@
+package align
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+)
+
+// eyesImage returns a white 200x150 image with black dots at the given eye positions.
+func eyesImage(eyes ...facedetect.Point) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 200, 150))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for _, e := range eyes {
+		for y := e.Y - 3; y <= e.Y+3; y++ {
+			for x := e.X - 3; x <= e.X+3; x++ {
+				img.SetGray(x, y, color.Gray{})
+			}
+		}
+	}
+	return img
+}
+
+func gray(img image.Image, x, y int) uint8 {
+	return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+}
+
+func TestAlign(t *testing.T) {
+	tests := []struct {
+		name        string
+		left, right facedetect.Point
+		roll        float64
+	}{
+		{name: "level", left: facedetect.Point{X: 60, Y: 70}, right: facedetect.Point{X: 120, Y: 70}, roll: 0},
+		{name: "counter-clockwise", left: facedetect.Point{X: 60, Y: 90}, right: facedetect.Point{X: 120, Y: 60}, roll: 26.565},
+		{name: "clockwise", left: facedetect.Point{X: 60, Y: 40}, right: facedetect.Point{X: 100, Y: 80}, roll: -45},
+	}
+	opts := Options{Size: 100, EyeY: 0.4, EyeDistance: 0.3}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := eyesImage(tt.left, tt.right)
+			chip, err := Align(img, facedetect.Face{LeftEye: &tt.left, RightEye: &tt.right}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chip.Bounds() != image.Rect(0, 0, 100, 100) {
+				t.Fatalf("Align() bounds = %v, want 100x100", chip.Bounds())
+			}
+			if math.Abs(chip.Roll-tt.roll) > 0.01 {
+				t.Errorf("Align() roll = %v, want %v", chip.Roll, tt.roll)
+			}
+			dist := math.Hypot(float64(tt.right.X-tt.left.X), float64(tt.right.Y-tt.left.Y))
+			if chip.EyeDistance != dist {
+				t.Errorf("Align() eye distance = %v, want %v", chip.EyeDistance, dist)
+			}
+			// Eyes are at 35, 40 and 65, 40, the centers of the dots are pixels 34 and 64.
+			for _, p := range []image.Point{{34, 39}, {64, 39}} {
+				if g := gray(chip, p.X, p.Y); g > 0x40 {
+					t.Errorf("eye should be at %v, got %#x", p, g)
+				}
+			}
+			for _, p := range []image.Point{{50, 39}, {34, 60}, {64, 20}} {
+				if g := gray(chip, p.X, p.Y); g < 0xc0 {
+					t.Errorf("pixel at %v should be background, got %#x", p, g)
+				}
+			}
+			mid := image.Pt((tt.left.X+tt.right.X)/2, (tt.left.Y+tt.right.Y)/2)
+			if !mid.In(chip.Region) {
+				t.Errorf("Align() region %v should contain the eyes midpoint %v", chip.Region, mid)
+			}
+		})
+	}
+}
+
+func TestAlign_OutsideImage(t *testing.T) {
+	left, right := facedetect.Point{X: 2, Y: 10}, facedetect.Point{X: 12, Y: 10}
+	chip, err := Align(eyesImage(), facedetect.Face{LeftEye: &left, RightEye: &right}, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, a := chip.At(0, 0).RGBA(); r != 0 || g != 0 || b != 0 || a != 0xffff {
+		t.Errorf("parts of the chip outside of the image should be black, got %v", chip.At(0, 0))
+	}
+	if chip.Region.Min.X >= 0 || chip.Region.Min.Y >= 0 {
+		t.Errorf("Align() region %v should extend past the image", chip.Region)
+	}
+}
+
+func TestAlign_NoEyes(t *testing.T) {
+	p := facedetect.Point{X: 10, Y: 10}
+	for _, f := range []facedetect.Face{{}, {LeftEye: &p}, {LeftEye: &p, RightEye: &p}} {
+		if _, err := Align(eyesImage(), f, DefaultOptions()); err != ErrNoEyes {
+			t.Errorf("Align() should return ErrNoEyes, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package annotate
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+	"strconv"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/fogleman/gg"
+)
+
+// Label selects the text drawn above each face.
+type Label int
+
+const (
+	// LabelNone draws no text.
+	LabelNone Label = iota
+	// LabelIndex draws the position of the face in the list of faces.
+	LabelIndex
+	// LabelScore draws the detection score of the face.
+	LabelScore
+)
+
+// Options control the appearance of the annotations.
+type Options struct {
+	// BoxColor is the color of the face bounds and labels.
+	BoxColor color.Color
+	// EyeColor is the color of the eye markers.
+	EyeColor color.Color
+	// MouthColor is the color of the mouth marker.
+	MouthColor color.Color
+	// LandmarkColor is the color of the Face.Landmarks markers.
+	LandmarkColor color.Color
+	// StrokeWidth is the width of the lines, in pixels.
+	StrokeWidth float64
+	// Label is the text drawn above each face.
+	Label Label
+}
+
+// DefaultOptions returns the options used unless told otherwise.
+func DefaultOptions() Options {
+	return Options{
+		BoxColor:      color.NRGBA{R: 0xff, A: 0xff},
+		EyeColor:      color.NRGBA{G: 0xff, A: 0xff},
+		MouthColor:    color.NRGBA{B: 0xff, A: 0xff},
+		LandmarkColor: color.NRGBA{R: 0xff, G: 0xff, A: 0xff},
+		StrokeWidth:   2,
+		Label:         LabelNone,
+	}
+}
+
+// Draw returns a copy of img with the bounds and facial features of faces drawn over it.
+// Face coordinates are relative to the top left corner of img.
+func Draw(img image.Image, faces []facedetect.Face, opts Options) image.Image {
+	b := img.Bounds()
+	dc := gg.NewContext(b.Dx(), b.Dy())
+	dc.DrawImage(img, -b.Min.X, -b.Min.Y)
+	dc.SetLineWidth(opts.StrokeWidth)
+
+	// Feature markers scale with the stroke, so they stay visible on large images.
+	r := 1.5 * opts.StrokeWidth
+
+	for i, f := range faces {
+		if f.Bounds != nil {
+			dc.SetColor(opts.BoxColor)
+			dc.DrawRectangle(float64(f.Bounds.X), float64(f.Bounds.Y), float64(f.Bounds.Width), float64(f.Bounds.Height))
+			dc.Stroke()
+			if text := label(opts.Label, i, f); text != "" {
+				// Above the box, or inside it when there's no room above.
+				y, ay := float64(f.Bounds.Y)-opts.StrokeWidth, 0.0
+				if _, h := dc.MeasureString(text); y < h {
+					y, ay = float64(f.Bounds.Y)+opts.StrokeWidth, 1
+				}
+				dc.DrawStringAnchored(text, float64(f.Bounds.X)+opts.StrokeWidth, y, 0, ay)
+			}
+		}
+
+		dc.SetColor(opts.EyeColor)
+		drawPoints(dc, r, f.LeftEye, f.RightEye)
+		dc.SetColor(opts.MouthColor)
+		drawPoints(dc, r, f.Mouth)
+
+		// Sorted, so the drawing doesn't depend on the map iteration order.
+		names := make([]string, 0, len(f.Landmarks))
+		for name := range f.Landmarks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		dc.SetColor(opts.LandmarkColor)
+		for _, name := range names {
+			drawPoints(dc, r/2, f.Landmarks[name])
+		}
+	}
+	return dc.Image()
+}
+
+func drawPoints(dc *gg.Context, r float64, points ...*facedetect.Point) {
+	for _, p := range points {
+		if p == nil {
+			continue
+		}
+		dc.DrawCircle(float64(p.X), float64(p.Y), r)
+		dc.Fill()
+	}
+}
+
+func label(l Label, i int, f facedetect.Face) string {
+	switch l {
+	case LabelIndex:
+		return strconv.Itoa(i)
+	case LabelScore:
+		return fmt.Sprintf("%.1f", f.Score)
+	}
+	return ""
+}
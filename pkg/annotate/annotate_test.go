@@ -0,0 +1,72 @@
+package annotate
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+)
+
+func TestDraw(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	faces := []facedetect.Face{
+		{
+			Bounds:    &facedetect.Bounds{X: 10, Y: 10, Width: 50, Height: 50},
+			Score:     42,
+			LeftEye:   &facedetect.Point{X: 25, Y: 30},
+			RightEye:  &facedetect.Point{X: 45, Y: 30},
+			Mouth:     &facedetect.Point{X: 35, Y: 50},
+			Landmarks: map[string]*facedetect.Point{"upper_lip": {X: 35, Y: 40}},
+		},
+		// A face without features is drawn as a box only.
+		{Bounds: &facedetect.Bounds{X: 70, Y: 70, Width: 20, Height: 20}},
+	}
+	opts := DefaultOptions()
+	opts.StrokeWidth = 4
+	opts.Label = LabelScore
+
+	out := Draw(img, faces, opts)
+	if out.Bounds() != img.Bounds() {
+		t.Fatalf("Draw() bounds = %v, want %v", out.Bounds(), img.Bounds())
+	}
+
+	same := func(a, b color.Color) bool {
+		return color.NRGBAModel.Convert(a) == color.NRGBAModel.Convert(b)
+	}
+	tests := []struct {
+		name string
+		x, y int
+		want color.Color
+	}{
+		{name: "box", x: 10, y: 35, want: opts.BoxColor},
+		{name: "second box", x: 70, y: 80, want: opts.BoxColor},
+		{name: "left eye", x: 25, y: 30, want: opts.EyeColor},
+		{name: "right eye", x: 45, y: 30, want: opts.EyeColor},
+		{name: "mouth", x: 35, y: 50, want: opts.MouthColor},
+		{name: "landmark", x: 35, y: 40, want: opts.LandmarkColor},
+		{name: "background", x: 95, y: 5, want: color.Transparent},
+	}
+	for _, tt := range tests {
+		if got := out.At(tt.x, tt.y); !same(got, tt.want) {
+			t.Errorf("%s at (%d, %d) = %v, want %v", tt.name, tt.x, tt.y, got, tt.want)
+		}
+	}
+
+	if !same(img.At(10, 35), color.Transparent) {
+		t.Error("Draw() should not modify the source image")
+	}
+}
+
+func Test_label(t *testing.T) {
+	f := facedetect.Face{Score: 12.345}
+	if got := label(LabelNone, 3, f); got != "" {
+		t.Errorf("label(LabelNone) = %q, want empty", got)
+	}
+	if got := label(LabelIndex, 3, f); got != "3" {
+		t.Errorf("label(LabelIndex) = %q, want 3", got)
+	}
+	if got := label(LabelScore, 3, f); got != "12.3" {
+		t.Errorf("label(LabelScore) = %q, want 12.3", got)
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Reloader reloads the cascades of a face detector.
+type Reloader interface {
+	Reload(ctx context.Context) error
+}
+
+// ReloaderFunc is an adapter to allow the use of ordinary functions as Reloader.
+type ReloaderFunc func(ctx context.Context) error
+
+// Reload calls f(ctx).
+func (f ReloaderFunc) Reload(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Admin handles the administrative requests. Its routes change the state of the service, so it
+// must be served on an address that's not publicly reachable.
+type Admin struct {
+	addr string
+	rl   Reloader
+	srv  http.Server
+}
+
+// NewAdmin creates a HTTP API for the administrative requests. Call Serve afterwards and pass a
+// parent context and the return value of Routes as parameters.
+func NewAdmin(addr string, rl Reloader) *Admin {
+	return &Admin{addr: addr, rl: rl}
+}
+
+// Routes returns a http.Handler with routes configured.
+func (a *Admin) Routes() http.Handler {
+	r := mux.NewRouter()
+	r.Methods(http.MethodPost).Path("/admin/reload").HandlerFunc(a.handleReload)
+	r.Methods(http.MethodGet).Path("/debug/vars").Handler(expvar.Handler())
+	return r
+}
+
+// Serve starts a HTTP server and serves provided handler. To reload the face detector, perform
+// a POST request on /admin/reload. Metrics published with expvar are served on /debug/vars.
+func (a *Admin) Serve(ctx context.Context, handler http.Handler) error {
+	return serve(ctx, &a.srv, a.addr, handler)
+}
+
+func (a *Admin) handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := a.rl.Reload(r.Context()); err != nil {
+		http.Error(w, "reload failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
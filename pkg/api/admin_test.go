@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeReloader struct {
+	err   error
+	calls int
+}
+
+func (f *fakeReloader) Reload(ctx context.Context) error {
+	f.calls++
+	return f.err
+}
+
+func TestAdmin_handleReload(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "success", err: nil, want: http.StatusNoContent},
+		{name: "failure", err: fmt.Errorf("fake error"), want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := &fakeReloader{err: tt.err}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+			NewAdmin("", rl).Routes().ServeHTTP(rec, req)
+			if rec.Result().StatusCode != tt.want {
+				t.Errorf("handler returned status code %d, want %d", rec.Result().StatusCode, tt.want)
+			}
+			if rl.calls != 1 {
+				t.Errorf("handler should reload once, reloaded %d times", rl.calls)
+			}
+		})
+	}
+}
+
+func TestAdmin_Routes_MethodNotAllowed(t *testing.T) {
+	rl := &fakeReloader{}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/admin/reload", nil)
+	NewAdmin("", rl).Routes().ServeHTTP(rec, req)
+	if rec.Result().StatusCode != http.StatusMethodNotAllowed || rl.calls != 0 {
+		t.Error("reload should only be triggered by POST requests")
+	}
+}
+
+func TestAdmin_Routes_Vars(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+	NewAdmin("", &fakeReloader{}).Routes().ServeHTTP(rec, req)
+	if rec.Result().StatusCode != http.StatusOK || !strings.Contains(rec.Body.String(), "memstats") {
+		t.Error("admin routes should serve the expvar metrics")
+	}
+}
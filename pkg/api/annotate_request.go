@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image/color"
+	"math"
+	"net/url"
+	"strings"
+
+	"github.com/bokan/facedetection/pkg/annotate"
+)
+
+const (
+	formatJSON = "json"
+	formatPNG  = "png"
+	formatJPEG = "jpeg"
+
+	labelsNone  = "none"
+	labelsIndex = "index"
+	labelsScore = "score"
+
+	// maxStrokeWidth is the widest line annotations can be drawn with, in pixels.
+	maxStrokeWidth = 50
+)
+
+// parseAnnotateOptions reads the annotated image appearance parameters.
+func parseAnnotateOptions(q url.Values, opts *annotate.Options) error {
+	if err := colorParam(q, "box_color", &opts.BoxColor); err != nil {
+		return err
+	}
+	if err := colorParam(q, "eye_color", &opts.EyeColor); err != nil {
+		return err
+	}
+	if err := colorParam(q, "mouth_color", &opts.MouthColor); err != nil {
+		return err
+	}
+	if err := colorParam(q, "landmark_color", &opts.LandmarkColor); err != nil {
+		return err
+	}
+	if err := floatParam(q, "stroke_width", &opts.StrokeWidth); err != nil {
+		return err
+	}
+	if opts.StrokeWidth <= 0 || opts.StrokeWidth > maxStrokeWidth {
+		return fmt.Errorf("stroke_width must be in (0, %d]", maxStrokeWidth)
+	}
+
+	switch q.Get("labels") {
+	case "", labelsNone:
+		opts.Label = annotate.LabelNone
+	case labelsIndex:
+		opts.Label = annotate.LabelIndex
+	case labelsScore:
+		opts.Label = annotate.LabelScore
+	default:
+		return fmt.Errorf("labels must be one of none, index or score")
+	}
+	return nil
+}
+
+// annotateValues adds the canonical form of the annotated image appearance parameters to v.
+func annotateValues(v url.Values, opts annotate.Options) {
+	v.Set("box_color", formatColor(opts.BoxColor))
+	v.Set("eye_color", formatColor(opts.EyeColor))
+	v.Set("mouth_color", formatColor(opts.MouthColor))
+	v.Set("landmark_color", formatColor(opts.LandmarkColor))
+	v.Set("stroke_width", formatFloat(opts.StrokeWidth))
+	switch opts.Label {
+	case annotate.LabelIndex:
+		v.Set("labels", labelsIndex)
+	case annotate.LabelScore:
+		v.Set("labels", labelsScore)
+	default:
+		v.Set("labels", labelsNone)
+	}
+}
+
+// colorParam parses a color in the RRGGBB or RRGGBBAA hex notation, optionally prefixed with #.
+func colorParam(q url.Values, name string, dst *color.Color) error {
+	s := strings.TrimPrefix(q.Get(name), "#")
+	if s == "" {
+		return nil
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil || (len(b) != 3 && len(b) != 4) {
+		return fmt.Errorf("%s must be a RRGGBB or RRGGBBAA hex color", name)
+	}
+	c := color.NRGBA{R: b[0], G: b[1], B: b[2], A: math.MaxUint8}
+	if len(b) == 4 {
+		c.A = b[3]
+	}
+	*dst = c
+	return nil
+}
+
+func formatColor(c color.Color) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return hex.EncodeToString([]byte{n.R, n.G, n.B, n.A})
+}
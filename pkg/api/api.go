@@ -2,35 +2,43 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/bokan/facedetection/pkg/download"
-	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/facedetect/registry"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 )
 
 // API handles Face Detect requests, register routes and creates the HTTP server.
 type API struct {
-	addr string
-	d    download.Downloader
-	fd   facedetect.FaceDetector
-	srv  http.Server
+	addr      string
+	d         download.Downloader
+	detectors *registry.Registry
+	srv       http.Server
+	ctx       context.Context
 }
 
-// NewAPI creates a HTTP API responsible for serving face detection requests.
+// NewAPI creates a HTTP API responsible for serving face detection requests. Requests select
+// one of the detectors by name with the detector query parameter, or get the default one.
 // Call Serve afterwards and pass a parent context and the return value of Routes
 // as parameters.
-func NewAPI(addr string, d download.Downloader, fd facedetect.FaceDetector) *API {
-	return &API{addr: addr, d: d, fd: fd}
+func NewAPI(addr string, d download.Downloader, detectors *registry.Registry) *API {
+	return &API{addr: addr, d: d, detectors: detectors}
 }
 
 // Routes returns a http.Handler with routes configured.
 func (a *API) Routes() http.Handler {
 	r := mux.NewRouter()
 	r.Methods(http.MethodGet).Path("/v1/face-detect").HandlerFunc(a.handleFaceDetect)
+	r.Methods(http.MethodGet).Path("/v1/face-redact").HandlerFunc(a.handleFaceRedact)
+	r.Methods(http.MethodGet).Path("/v1/face-crops").HandlerFunc(a.handleFaceCrops)
+	r.Methods(http.MethodGet).Path("/v1/smart-crop").HandlerFunc(a.handleSmartCrop)
 
 	allowAllOrigins := handlers.AllowedOriginValidator(func(origin string) bool {
 		return true // Allow all origins
@@ -41,11 +49,68 @@ func (a *API) Routes() http.Handler {
 	return handlers.RecoveryHandler()(handlers.CORS(headersOk, allowAllOrigins, methodsOk)(r))
 }
 
+// CacheKey returns the HTTPCache key for r. Face detection, redaction, crops and smart crop requests
+// are keyed by their canonical query parameters and the name and version of the detector, so
+// equivalent requests share a cache entry and differently tuned ones never collide. The key also
+// holds the generation of the detectors, so that responses cached before a reload aren't served.
+func (a *API) CacheKey(r *http.Request) string {
+	q := r.URL.Query()
+	var v url.Values
+	switch r.URL.Path {
+	case "/v1/face-detect":
+		if dr, err := parseDetectRequest(q); err == nil {
+			v = dr.values()
+		}
+	case "/v1/face-redact":
+		if rr, err := parseRedactRequest(q); err == nil {
+			v = rr.values()
+		}
+	case "/v1/face-crops":
+		if cr, err := parseCropsRequest(q); err == nil {
+			v = cr.values()
+		}
+	case "/v1/smart-crop":
+		if sr, err := parseSmartCropRequest(q); err == nil {
+			v = sr.values()
+		}
+	}
+	if v != nil {
+		if e, err := a.detectors.Get(q.Get("detector")); err == nil {
+			v.Set("detector", e.Name)
+			v.Set("detector_version", e.Version)
+			v.Set("detector_generation", strconv.FormatUint(a.detectors.Generation(), 10))
+			return fmt.Sprintf("%s-%s?%s", r.Method, r.URL.Path, v.Encode())
+		}
+	}
+	return fmt.Sprintf("%s-%s", r.Method, r.URL.String())
+}
+
+// CacheAlias returns one more HTTPCache key for r, whose response has the given header. When
+// the image was downloaded from another URL than image_url, because of redirects, the response
+// is also cached for requests made with that URL.
+func (a *API) CacheAlias(r *http.Request, header http.Header) string {
+	finalURL := header.Get(headerImageURL)
+	q := r.URL.Query()
+	if finalURL == "" || finalURL == q.Get("image_url") {
+		return ""
+	}
+	q.Set("image_url", finalURL)
+	alias := *r
+	alias.URL = &url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+	return a.CacheKey(&alias)
+}
+
 // Serve starts a HTTP server and serves provided handler. To invoke face detection
 // endpoint, perform a GET request on /v1/face-detect?={image_url}.
 func (a *API) Serve(ctx context.Context, handler http.Handler) error {
-	a.srv = http.Server{
-		Addr:              a.addr,
+	a.ctx = ctx
+	return serve(ctx, &a.srv, a.addr, handler)
+}
+
+// serve runs srv on addr until ctx ends.
+func serve(ctx context.Context, srv *http.Server, addr string, handler http.Handler) error {
+	*srv = http.Server{
+		Addr:              addr,
 		ReadTimeout:       time.Second * 2,
 		ReadHeaderTimeout: time.Second * 2,
 		WriteTimeout:      time.Second * 5,
@@ -58,9 +123,9 @@ func (a *API) Serve(ctx context.Context, handler http.Handler) error {
 	}
 	go func() {
 		<-ctx.Done()
-		_ = a.srv.Shutdown(ctx)
+		_ = srv.Shutdown(ctx)
 	}()
-	if err := a.srv.ListenAndServe(); err != nil {
+	if err := srv.ListenAndServe(); err != nil {
 		return err
 	}
 	return nil
@@ -4,8 +4,11 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/bokan/facedetection/pkg/facedetect/registry"
 )
 
 func TestAPI_Routes_CORS(t *testing.T) {
@@ -23,6 +26,98 @@ func TestAPI_Routes_CORS(t *testing.T) {
 	}
 }
 
+func TestAPI_CacheKey(t *testing.T) {
+	detectors := registry.NewRegistry()
+	_ = detectors.Register("pigo", "1", nil)
+	_ = detectors.Register("fast", "2", nil)
+	a := NewAPI("", nil, detectors)
+	key := func(target string) string {
+		return a.CacheKey(httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	if key("/v1/face-detect?image_url=http://foo/&min_size=20") != key("/v1/face-detect?min_size=20&image_url=http://foo/") {
+		t.Error("parameter order should not change the cache key")
+	}
+	if key("/v1/face-detect?image_url=http://foo/") != key("/v1/face-detect?image_url=http://foo/&min_size=20") {
+		t.Error("explicitly passed default options should not change the cache key")
+	}
+	if key("/v1/face-detect?image_url=http://foo/") == key("/v1/face-detect?image_url=http://foo/&min_size=40") {
+		t.Error("differently tuned requests should not share the cache key")
+	}
+	if key("/v1/face-detect?image_url=http://foo/&max_size=1000") != key("/v1/face-detect?image_url=http://foo/&max_size=1000&max_size_ratio=0") {
+		t.Error("an explicit max size should replace the default max size ratio")
+	}
+	if key("/v1/face-detect?image_url=http://foo/") == key("/v1/face-detect?image_url=http://foo/&max_size=1000") {
+		t.Error("an explicit max size should not share the cache key with the default relative one")
+	}
+	if key("/v1/face-detect?image_url=http://foo/") == key("/v1/face-detect?image_url=http://foo/&format=png") {
+		t.Error("requests for different response formats should not share the cache key")
+	}
+	if key("/v1/face-detect?image_url=http://foo/&box_color=%23FF0000") != key("/v1/face-detect?image_url=http://foo/&box_color=ff0000ff") {
+		t.Error("equivalent colors should not change the cache key")
+	}
+	if key("/v1/face-redact?image_url=http://foo/") != key("/v1/face-redact?image_url=http://foo/&method=blur&padding=0.1") {
+		t.Error("explicitly passed default redaction options should not change the cache key")
+	}
+	if key("/v1/face-redact?image_url=http://foo/") == key("/v1/face-redact?image_url=http://foo/&method=fill") {
+		t.Error("differently redacted requests should not share the cache key")
+	}
+	if key("/v1/face-redact?image_url=http://foo/") == key("/v1/face-detect?image_url=http://foo/") {
+		t.Error("redaction and detection requests should not share the cache key")
+	}
+	if key("/v1/face-crops?image_url=http://foo/") != key("/v1/face-crops?image_url=http://foo/&archive=multipart&mode=square") {
+		t.Error("explicitly passed default crop options should not change the cache key")
+	}
+	if key("/v1/face-crops?image_url=http://foo/") == key("/v1/face-crops?image_url=http://foo/&archive=zip") {
+		t.Error("differently packed crops should not share the cache key")
+	}
+	if key("/v1/face-crops?image_url=http://foo/&mode=aligned") != key("/v1/face-crops?image_url=http://foo/&mode=aligned&size=112&margin=0.5") {
+		t.Error("options without effect on aligned chips should not change the cache key")
+	}
+	if key("/v1/smart-crop?image_url=http://foo/&width=16&height=9") == key("/v1/smart-crop?image_url=http://foo/&width=1&height=1") {
+		t.Error("smart crops of different sizes should not share the cache key")
+	}
+	if key("/v1/face-detect?image_url=http://foo/") != key("/v1/face-detect?image_url=http://foo/&detector=pigo") {
+		t.Error("explicitly selecting the default detector should not change the cache key")
+	}
+	if key("/v1/face-detect?image_url=http://foo/") == key("/v1/face-detect?image_url=http://foo/&detector=fast") {
+		t.Error("requests to different detectors should not share the cache key")
+	}
+	if !strings.Contains(key("/v1/face-detect?image_url=http://foo/&detector=fast"), "detector_version=2") {
+		t.Error("cache key should include the detector version")
+	}
+	before := key("/v1/face-detect?image_url=http://foo/")
+	detectors.Reloaded()
+	if key("/v1/face-detect?image_url=http://foo/") == before {
+		t.Error("requests after a reload should not share the cache key of the requests before")
+	}
+}
+
+func TestAPI_CacheAlias(t *testing.T) {
+	detectors := registry.NewRegistry()
+	_ = detectors.Register("pigo", "1", nil)
+	a := NewAPI("", nil, detectors)
+	alias := func(target, finalURL string) string {
+		header := http.Header{}
+		if finalURL != "" {
+			header.Set(headerImageURL, finalURL)
+		}
+		return a.CacheAlias(httptest.NewRequest(http.MethodGet, target, nil), header)
+	}
+
+	if alias("/v1/face-detect?image_url=http://foo/", "") != "" {
+		t.Error("responses without final url should have no alias")
+	}
+	if alias("/v1/face-detect?image_url=http://foo/", "http://foo/") != "" {
+		t.Error("responses of images that weren't redirected should have no alias")
+	}
+	got := alias("/v1/face-detect?image_url=http://foo/&min_size=40", "http://bar/")
+	want := a.CacheKey(httptest.NewRequest(http.MethodGet, "/v1/face-detect?min_size=40&image_url=http://bar/", nil))
+	if got != want {
+		t.Errorf("CacheAlias() = %s, want the key of the request for the final url %s", got, want)
+	}
+}
+
 func TestAPI_Serve(t *testing.T) {
 	a := NewAPI(":0", nil, nil)
 	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/bokan/facedetection/pkg/align"
+	"github.com/bokan/facedetection/pkg/crop"
+	"github.com/bokan/facedetection/pkg/facedetect"
+)
+
+const (
+	modeSquare  = "square"
+	modeAspect  = "aspect"
+	modeAligned = "aligned"
+
+	archiveMultipart = "multipart"
+	archiveZIP       = "zip"
+	archiveJSON      = "json"
+
+	// maxCropSize is the largest size crops can be resized to, in pixels.
+	maxCropSize = 1024
+)
+
+// cropsRequest holds the validated query parameters of a face crops request.
+type cropsRequest struct {
+	imageURL string
+	opts     facedetect.DetectOptions
+	faceFilter
+
+	crop crop.Options
+	// aligned selects face chips with leveled eyes instead of crops, laid out by align.
+	aligned bool
+	align   align.Options
+	// format is the image format of the crops, formatPNG or formatJPEG.
+	format string
+	// archive is the way the crops are packed in the response, one of archive* constants.
+	archive string
+}
+
+// parseCropsRequest validates the query parameters and fills in the defaults for the omitted ones.
+// Returned errors are meant to be shown to the client.
+func parseCropsRequest(q url.Values) (*cropsRequest, error) {
+	imageURL, err := parseImageURL(q)
+	if err != nil {
+		return nil, err
+	}
+
+	cr := &cropsRequest{
+		imageURL: imageURL,
+		opts:     facedetect.DefaultDetectOptions(),
+		crop:     crop.DefaultOptions(),
+		align:    align.DefaultOptions(),
+		format:   formatJPEG,
+		archive:  archiveMultipart,
+	}
+	if err := parseDetectOptions(q, &cr.opts); err != nil {
+		return nil, err
+	}
+	// Crops are cut out of the first frame, as displayed.
+	if cr.opts.AllFrames {
+		return nil, fmt.Errorf("frames=all is not supported by face crops")
+	}
+	if cr.opts.Frame == facedetect.FrameStored {
+		return nil, fmt.Errorf("frame=stored is not supported by face crops")
+	}
+	if err := parseFaceFilter(q, &cr.faceFilter); err != nil {
+		return nil, err
+	}
+
+	switch q.Get("mode") {
+	case "", modeSquare:
+		cr.crop.Mode = crop.ModeSquare
+	case modeAspect:
+		cr.crop.Mode = crop.ModeAspect
+	case modeAligned:
+		cr.aligned = true
+	default:
+		return nil, fmt.Errorf("mode must be one of square, aspect or aligned")
+	}
+	if err := floatParam(q, "margin", &cr.crop.Margin); err != nil {
+		return nil, err
+	}
+	if cr.crop.Margin < 0 || cr.crop.Margin > 1 {
+		return nil, fmt.Errorf("margin must be in [0, 1]")
+	}
+	if err := intParam(q, "size", &cr.crop.Size); err != nil {
+		return nil, err
+	}
+	if cr.crop.Size < 0 || cr.crop.Size > maxCropSize {
+		return nil, fmt.Errorf("size must be in [0, %d]", maxCropSize)
+	}
+	if cr.aligned {
+		// Chips are always resized, margin has no effect on them.
+		if cr.crop.Size > 0 {
+			cr.align.Size = cr.crop.Size
+		}
+		cr.crop = crop.DefaultOptions()
+		cr.crop.Size = cr.align.Size
+	}
+
+	switch f := q.Get("format"); f {
+	case "":
+	case formatPNG, formatJPEG:
+		cr.format = f
+	default:
+		return nil, fmt.Errorf("format must be png or jpeg")
+	}
+	switch a := q.Get("archive"); a {
+	case "":
+	case archiveMultipart, archiveZIP, archiveJSON:
+		cr.archive = a
+	default:
+		return nil, fmt.Errorf("archive must be one of multipart, zip or json")
+	}
+	return cr, nil
+}
+
+// values returns the canonical form of the request.
+func (cr *cropsRequest) values() url.Values {
+	v := url.Values{}
+	v.Set("image_url", cr.imageURL)
+	detectOptionsValues(v, cr.opts)
+	cr.faceFilter.values(v)
+	switch {
+	case cr.aligned:
+		v.Set("mode", modeAligned)
+	case cr.crop.Mode == crop.ModeAspect:
+		v.Set("mode", modeAspect)
+	default:
+		v.Set("mode", modeSquare)
+	}
+	v.Set("margin", formatFloat(cr.crop.Margin))
+	v.Set("size", strconv.Itoa(cr.crop.Size))
+	v.Set("format", cr.format)
+	v.Set("archive", cr.archive)
+	return v
+}
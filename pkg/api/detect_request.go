@@ -0,0 +1,413 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bokan/facedetection/pkg/annotate"
+	"github.com/bokan/facedetection/pkg/facedetect"
+)
+
+const (
+	sortByScore = "score"
+	sortBySize  = "size"
+	sortByX     = "x"
+	sortByY     = "y"
+
+	orderAsc  = "asc"
+	orderDesc = "desc"
+
+	landmarksBasic = "basic"
+	landmarksFull  = "full"
+
+	frameDisplayed = "displayed"
+	frameStored    = "stored"
+
+	framesFirst = "first"
+	framesAll   = "all"
+)
+
+// Clients are held to tighter bounds than DetectOptions.Validate, so that a single request can't
+// scan an image for much longer than a default one.
+const (
+	// minMinSize is the smallest face size, in pixels, clients can look for.
+	minMinSize = 10
+	// minMinSizeRatio is the smallest face size, relative to the image, clients can look for.
+	minMinSizeRatio = 0.01
+	// minShiftFactor is the smallest step of the detection window clients can ask for.
+	minShiftFactor = 0.05
+	// maxScans bounds the number of scans of the working image, one per angle, times its pixels
+	// relative to the default budget.
+	maxScans = 4
+)
+
+// detectRequest holds the validated query parameters of a face detection request.
+type detectRequest struct {
+	imageURL string
+	opts     facedetect.DetectOptions
+	faceFilter
+
+	// format is formatJSON, or the format of the annotated image to respond with.
+	format string
+	// annotate is the appearance of the annotated image.
+	annotate annotate.Options
+}
+
+// parseDetectRequest validates the query parameters and fills in the defaults for the omitted ones.
+// Returned errors are meant to be shown to the client.
+func parseDetectRequest(q url.Values) (*detectRequest, error) {
+	imageURL, err := parseImageURL(q)
+	if err != nil {
+		return nil, err
+	}
+
+	dr := &detectRequest{
+		imageURL: imageURL,
+		opts:     facedetect.DefaultDetectOptions(),
+		format:   formatJSON,
+		annotate: annotate.DefaultOptions(),
+	}
+	if err := parseDetectOptions(q, &dr.opts); err != nil {
+		return nil, err
+	}
+	if err := parseFaceFilter(q, &dr.faceFilter); err != nil {
+		return nil, err
+	}
+
+	switch f := q.Get("format"); f {
+	case "":
+	case formatJSON, formatPNG, formatJPEG:
+		dr.format = f
+	default:
+		return nil, fmt.Errorf("format must be one of json, png or jpeg")
+	}
+	if dr.format != formatJSON {
+		// Annotations are drawn on the first frame, as displayed.
+		if dr.opts.AllFrames {
+			return nil, fmt.Errorf("frames=all is supported only with json format")
+		}
+		if dr.opts.Frame == facedetect.FrameStored {
+			return nil, fmt.Errorf("frame=stored is supported only with json format")
+		}
+	}
+	if err := parseAnnotateOptions(q, &dr.annotate); err != nil {
+		return nil, err
+	}
+	return dr, nil
+}
+
+// parseImageURL returns the image_url parameter, validated to be an http or https URL.
+func parseImageURL(q url.Values) (string, error) {
+	imageURL, ok := q["image_url"]
+	if !ok || len(imageURL) == 0 {
+		return "", fmt.Errorf("image_url query parameter missing")
+	}
+
+	u, err := url.Parse(imageURL[0])
+	if err != nil {
+		return "", fmt.Errorf("image_url is not a valid url")
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("image_url scheme must be http or https")
+	}
+	return imageURL[0], nil
+}
+
+func parseDetectOptions(q url.Values, opts *facedetect.DetectOptions) error {
+	if err := intParam(q, "min_size", &opts.MinSize); err != nil {
+		return err
+	}
+	if err := intParam(q, "max_size", &opts.MaxSize); err != nil {
+		return err
+	}
+	if _, ok := q["max_size"]; ok {
+		// An explicit max size replaces the default one, relative to the image.
+		opts.MaxSizeRatio = 0
+	}
+	if err := floatParam(q, "min_size_ratio", &opts.MinSizeRatio); err != nil {
+		return err
+	}
+	if err := floatParam(q, "max_size_ratio", &opts.MaxSizeRatio); err != nil {
+		return err
+	}
+	if err := intParam(q, "max_pixels", &opts.MaxPixels); err != nil {
+		return err
+	}
+	if err := floatParam(q, "shift_factor", &opts.ShiftFactor); err != nil {
+		return err
+	}
+	if err := floatParam(q, "scale_factor", &opts.ScaleFactor); err != nil {
+		return err
+	}
+	if err := floatParam(q, "iou_threshold", &opts.IoUThreshold); err != nil {
+		return err
+	}
+	if err := float32Param(q, "quality_threshold", &opts.QualityThreshold); err != nil {
+		return err
+	}
+	if err := float32Param(q, "features_quality_threshold", &opts.FeaturesQualityThreshold); err != nil {
+		return err
+	}
+	if err := intParam(q, "perturbs", &opts.Perturbs); err != nil {
+		return err
+	}
+	if err := floatListParam(q, "angles", &opts.Angles); err != nil {
+		return err
+	}
+
+	switch q.Get("landmarks") {
+	case "", landmarksBasic:
+		opts.FullLandmarks = false
+	case landmarksFull:
+		opts.FullLandmarks = true
+	default:
+		return fmt.Errorf("landmarks must be basic or full")
+	}
+
+	switch q.Get("frame") {
+	case "", frameDisplayed:
+		opts.Frame = facedetect.FrameDisplayed
+	case frameStored:
+		opts.Frame = facedetect.FrameStored
+	default:
+		return fmt.Errorf("frame must be displayed or stored")
+	}
+
+	switch q.Get("frames") {
+	case "", framesFirst:
+		opts.AllFrames = false
+	case framesAll:
+		opts.AllFrames = true
+	default:
+		return fmt.Errorf("frames must be first or all")
+	}
+	if err := intParam(q, "max_frames", &opts.MaxFrames); err != nil {
+		return err
+	}
+	if err := intParam(q, "max_frames_pixels", &opts.MaxFramesPixels); err != nil {
+		return err
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	return checkDetectCost(*opts)
+}
+
+// checkDetectCost checks that the options asked by a client keep the detection within the bounds
+// of the server, whatever the library accepts.
+func checkDetectCost(opts facedetect.DetectOptions) error {
+	def := facedetect.DefaultDetectOptions()
+	scans := len(opts.Angles)
+	if scans == 0 {
+		scans = 1
+	}
+	switch {
+	case opts.MinSize < minMinSize:
+		return fmt.Errorf("min_size must be at least %d", minMinSize)
+	case opts.MinSizeRatio != 0 && opts.MinSizeRatio < minMinSizeRatio:
+		return fmt.Errorf("min_size_ratio must be 0 or at least %g", minMinSizeRatio)
+	case opts.ShiftFactor < minShiftFactor:
+		return fmt.Errorf("shift_factor must be at least %g", minShiftFactor)
+	case opts.MaxPixels == 0 || opts.MaxPixels > def.MaxPixels:
+		return fmt.Errorf("max_pixels must be between %d and %d", facedetect.MinMaxPixels, def.MaxPixels)
+	case opts.MaxFrames > def.MaxFrames:
+		return fmt.Errorf("max_frames must be at most %d", def.MaxFrames)
+	case opts.MaxFramesPixels > def.MaxFramesPixels:
+		return fmt.Errorf("max_frames_pixels must be at most %d", def.MaxFramesPixels)
+	case scans*opts.MaxPixels > maxScans*def.MaxPixels:
+		return fmt.Errorf("angles times max_pixels must be at most %d", maxScans*def.MaxPixels)
+	case opts.AllFrames && scans*opts.MaxFramesPixels > maxScans*def.MaxFramesPixels:
+		return fmt.Errorf("angles times max_frames_pixels must be at most %d", maxScans*def.MaxFramesPixels)
+	}
+	return nil
+}
+
+// values returns the canonical form of the request. Requests that differ only in parameter order
+// or in explicitly passing the default values encode to the same string.
+func (dr *detectRequest) values() url.Values {
+	v := url.Values{}
+	v.Set("image_url", dr.imageURL)
+	detectOptionsValues(v, dr.opts)
+	dr.faceFilter.values(v)
+	v.Set("format", dr.format)
+	annotateValues(v, dr.annotate)
+	return v
+}
+
+// detectOptionsValues adds the canonical form of the detection options to v.
+func detectOptionsValues(v url.Values, opts facedetect.DetectOptions) {
+	v.Set("min_size", strconv.Itoa(opts.MinSize))
+	v.Set("max_size", strconv.Itoa(opts.MaxSize))
+	v.Set("min_size_ratio", formatFloat(opts.MinSizeRatio))
+	v.Set("max_size_ratio", formatFloat(opts.MaxSizeRatio))
+	v.Set("max_pixels", strconv.Itoa(opts.MaxPixels))
+	v.Set("shift_factor", formatFloat(opts.ShiftFactor))
+	v.Set("scale_factor", formatFloat(opts.ScaleFactor))
+	v.Set("iou_threshold", formatFloat(opts.IoUThreshold))
+	v.Set("quality_threshold", formatFloat(float64(opts.QualityThreshold)))
+	v.Set("features_quality_threshold", formatFloat(float64(opts.FeaturesQualityThreshold)))
+	v.Set("perturbs", strconv.Itoa(opts.Perturbs))
+	v.Set("angles", formatFloatList(opts.Angles))
+	v.Set("landmarks", landmarksBasic)
+	if opts.FullLandmarks {
+		v.Set("landmarks", landmarksFull)
+	}
+	v.Set("frame", frameDisplayed)
+	if opts.Frame == facedetect.FrameStored {
+		v.Set("frame", frameStored)
+	}
+	v.Set("frames", framesFirst)
+	if opts.AllFrames {
+		v.Set("frames", framesAll)
+	}
+	v.Set("max_frames", strconv.Itoa(opts.MaxFrames))
+	v.Set("max_frames_pixels", strconv.Itoa(opts.MaxFramesPixels))
+}
+
+// faceFilter selects and orders the detected faces a response is made of.
+type faceFilter struct {
+	// minScore filters out the faces detected with lower confidence.
+	minScore float32
+	// sortBy is one of sortBy* constants, or empty to keep the detector order.
+	sortBy string
+	// order is either orderAsc or orderDesc.
+	order string
+}
+
+// parseFaceFilter reads the min_score, sort and order parameters.
+func parseFaceFilter(q url.Values, ff *faceFilter) error {
+	ff.order = orderDesc
+	if err := float32Param(q, "min_score", &ff.minScore); err != nil {
+		return err
+	}
+
+	switch s := q.Get("sort"); s {
+	case "", sortByScore, sortBySize, sortByX, sortByY:
+		ff.sortBy = s
+	default:
+		return fmt.Errorf("sort must be one of score, size, x or y")
+	}
+
+	switch o := q.Get("order"); o {
+	case "":
+	case orderAsc, orderDesc:
+		ff.order = o
+	default:
+		return fmt.Errorf("order must be asc or desc")
+	}
+	return nil
+}
+
+// values adds the canonical form of the filter to v.
+func (ff *faceFilter) values(v url.Values) {
+	v.Set("min_score", formatFloat(float64(ff.minScore)))
+	v.Set("sort", ff.sortBy)
+	v.Set("order", ff.order)
+}
+
+// filterAndSort drops the faces scored below minScore and orders the rest as requested.
+func (ff *faceFilter) filterAndSort(faces []facedetect.Face) []facedetect.Face {
+	var out []facedetect.Face
+	for _, f := range faces {
+		if f.Score >= ff.minScore {
+			out = append(out, f)
+		}
+	}
+	if ff.sortBy == "" {
+		return out
+	}
+
+	key := func(f facedetect.Face) float64 {
+		switch ff.sortBy {
+		case sortByScore:
+			return float64(f.Score)
+		case sortBySize:
+			return float64(f.Bounds.Width * f.Bounds.Height)
+		case sortByX:
+			return float64(f.Bounds.X)
+		default:
+			return float64(f.Bounds.Y)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if ff.order == orderAsc {
+			return key(out[i]) < key(out[j])
+		}
+		return key(out[i]) > key(out[j])
+	})
+	return out
+}
+
+func intParam(q url.Values, name string, dst *int) error {
+	s := q.Get(name)
+	if s == "" {
+		return nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%s must be an integer", name)
+	}
+	*dst = v
+	return nil
+}
+
+func floatParam(q url.Values, name string, dst *float64) error {
+	s := q.Get(name)
+	if s == "" {
+		return nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("%s must be a number", name)
+	}
+	*dst = v
+	return nil
+}
+
+func float32Param(q url.Values, name string, dst *float32) error {
+	s := q.Get(name)
+	if s == "" {
+		return nil
+	}
+	v, err := strconv.ParseFloat(s, 32)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("%s must be a number", name)
+	}
+	*dst = float32(v)
+	return nil
+}
+
+// floatListParam parses a comma separated list of numbers.
+func floatListParam(q url.Values, name string, dst *[]float64) error {
+	s := q.Get(name)
+	if s == "" {
+		return nil
+	}
+	var list []float64
+	for _, f := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("%s must be a comma separated list of numbers", name)
+		}
+		list = append(list, v)
+	}
+	*dst = list
+	return nil
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func formatFloatList(list []float64) string {
+	s := make([]string, len(list))
+	for i, v := range list {
+		s[i] = formatFloat(v)
+	}
+	return strings.Join(s, ",")
+}
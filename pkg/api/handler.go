@@ -1,11 +1,18 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
 	"net/http"
-	"net/url"
+	"strings"
 
+	"github.com/bokan/facedetection/pkg/download"
 	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/facedetect/registry"
 )
 
 // Error is reserved for future use.
@@ -16,47 +23,101 @@ type Error struct {
 
 // Faces structure is response sent to client. It encapsulates response from face detector.
 type Faces struct {
-	Faces []facedetect.Face `json:"Faces"`
+	Faces       []facedetect.Face       `json:"Faces"`
+	Frames      []facedetect.FrameFaces `json:"frames,omitempty"`
+	Format      string                  `json:"format,omitempty"`
+	Orientation int                     `json:"orientation"`
+	Detector    Detector                `json:"detector"`
 }
 
-func (a *API) handleFaceDetect(w http.ResponseWriter, r *http.Request) {
-	imageURL, ok := r.URL.Query()["image_url"]
-	if !ok || len(imageURL) == 0 {
-		http.Error(w, "image_url query parameter missing", 400)
-		return
+// Detector identifies the face detector that handled a request.
+type Detector struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+const (
+	// statusClientClosedRequest is a non-standard status code for requests the client gave up on
+	// before the response was sent.
+	statusClientClosedRequest = 499
+
+	// retryAfterBusy is the number of seconds clients are asked to wait when the detector is busy.
+	retryAfterBusy = "1"
+
+	// headerImageURL is the response header holding the URL the image was downloaded from, after
+	// the redirects.
+	headerImageURL = "X-Image-URL"
+)
+
+// contextErrorStatus returns the response status code for a request whose context has ended.
+// The server shutting down or an exceeded deadline map to 503, while the client abandoning the
+// request maps to statusClientClosedRequest.
+func (a *API) contextErrorStatus(r *http.Request) (int, bool) {
+	err := r.Context().Err()
+	switch {
+	case err == nil:
+		return 0, false
+	case a.ctx != nil && a.ctx.Err() != nil, err == context.DeadlineExceeded:
+		return http.StatusServiceUnavailable, true
 	}
+	return statusClientClosedRequest, true
+}
 
-	u, err := url.Parse(imageURL[0])
+func (a *API) handleFaceDetect(w http.ResponseWriter, r *http.Request) {
+	dr, err := parseDetectRequest(r.URL.Query())
 	if err != nil {
-		http.Error(w, "image_url is not a valid url", 400)
+		http.Error(w, err.Error(), 400)
 		return
 	}
-
-	if u.Scheme != "http" && u.Scheme != "https" {
-		http.Error(w, "image_url scheme must be http or https", 400)
+	fd, ok := a.detector(w, r)
+	if !ok {
 		return
 	}
 
-	body, err := a.d.Download(r.Context(), imageURL[0])
+	body, err := a.d.Download(r.Context(), dr.imageURL)
 	if err != nil {
-		http.Error(w, "image download failed", 400)
+		a.downloadError(w, r, err)
 		return
 	}
+	w.Header().Set(headerImageURL, download.FinalURL(body, dr.imageURL))
 	defer func() {
 		_ = body.Close()
 	}()
 
-	detections, err := a.fd.DetectFaces(r.Context(), body)
-	if err != nil {
-		if err == facedetect.ErrUnsupportedImageFormat || err == facedetect.ErrImageError {
-			http.Error(w, "unsupported image format", 400)
+	var img io.Reader = body
+	var data []byte
+	if dr.format != formatJSON {
+		// The image is needed once more to draw the annotations on.
+		data, err = ioutil.ReadAll(body)
+		if err != nil {
+			a.downloadError(w, r, err)
 			return
 		}
-		http.Error(w, "an internal error happened during face detection", 500)
+		img = bytes.NewReader(data)
+	}
+
+	result, err := fd.DetectFaces(r.Context(), img, dr.opts)
+	if err != nil {
+		a.detectError(w, r, err)
 		return
 	}
 
-	response := Faces{Faces: detections}
+	faces := dr.filterAndSort(result.Faces)
+	if dr.format != formatJSON {
+		writeAnnotated(w, data, faces, dr)
+		return
+	}
+
+	response := Faces{
+		Faces:       faces,
+		Format:      result.Format,
+		Orientation: result.Orientation,
+		Detector:    Detector{Name: fd.Name, Version: fd.Version},
+	}
+	for _, f := range result.Frames {
+		f.Faces = dr.filterAndSort(f.Faces)
+		response.Frames = append(response.Frames, f)
+	}
 	js, err := json.Marshal(response)
 	if err != nil {
 		http.Error(w, "an internal error happened", http.StatusInternalServerError)
@@ -66,3 +127,91 @@ func (a *API) handleFaceDetect(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(200)
 	_, _ = w.Write(js)
 }
+
+// detector returns the detector selected with the detector query parameter, naming it in the
+// response headers. When there's no such detector, it responds to the request and returns false.
+func (a *API) detector(w http.ResponseWriter, r *http.Request) (*registry.Entry, bool) {
+	e, err := a.detectors.Get(r.URL.Query().Get("detector"))
+	if err != nil {
+		http.Error(w, "detector must be one of "+strings.Join(a.detectors.Names(), ", "), 400)
+		return nil, false
+	}
+	w.Header().Set("X-Face-Detector", e.Name)
+	w.Header().Set("X-Face-Detector-Version", e.Version)
+	return e, true
+}
+
+// downloadAll reads the whole image at imageURL. When the download fails, it responds to the
+// request and returns false.
+func (a *API) downloadAll(w http.ResponseWriter, r *http.Request, imageURL string) ([]byte, bool) {
+	body, err := a.d.Download(r.Context(), imageURL)
+	if err == nil {
+		defer func() {
+			_ = body.Close()
+		}()
+		w.Header().Set(headerImageURL, download.FinalURL(body, imageURL))
+		var data []byte
+		if data, err = ioutil.ReadAll(body); err == nil {
+			return data, true
+		}
+	}
+	a.downloadError(w, r, err)
+	return nil, false
+}
+
+// downloadError responds to a request whose image download failed with err.
+func (a *API) downloadError(w http.ResponseWriter, r *http.Request, err error) {
+	if status, ok := a.contextErrorStatus(r); ok {
+		http.Error(w, "request ended before the image was downloaded", status)
+		return
+	}
+	switch {
+	case errors.Is(err, download.ErrForbiddenAddress):
+		http.Error(w, "image_url points to a forbidden address", http.StatusForbidden)
+		return
+	case errors.Is(err, download.ErrFileIsTooBig):
+		http.Error(w, "image is too big", 400)
+		return
+	case errors.Is(err, download.ErrNotAnImage):
+		http.Error(w, "image_url is not an image", 400)
+		return
+	case errors.Is(err, download.ErrTooManyRedirects):
+		http.Error(w, "image_url redirects too many times", 400)
+		return
+	case errors.Is(err, download.ErrForbiddenRedirect):
+		http.Error(w, "image_url redirects to a forbidden url", 400)
+		return
+	}
+	http.Error(w, "image download failed", 400)
+}
+
+// detectError responds to a request whose face detection failed with err.
+func (a *API) detectError(w http.ResponseWriter, r *http.Request, err error) {
+	if status, ok := a.contextErrorStatus(r); ok {
+		http.Error(w, "request ended before face detection completed", status)
+		return
+	}
+	// The image may be streamed to the detector, failing the detection when its download does.
+	if errors.Is(err, download.ErrFileIsTooBig) {
+		a.downloadError(w, r, err)
+		return
+	}
+	if err == facedetect.ErrBusy {
+		w.Header().Set("Retry-After", retryAfterBusy)
+		http.Error(w, "face detector is busy, retry later", http.StatusTooManyRequests)
+		return
+	}
+	if err == facedetect.ErrUnsupportedImageFormat || err == facedetect.ErrImageError {
+		http.Error(w, "unsupported image format", 400)
+		return
+	}
+	if err == facedetect.ErrAnimationTooLarge {
+		http.Error(w, "animation exceeds the frame limits", 400)
+		return
+	}
+	if errors.Is(err, facedetect.ErrInvalidDetectOptions) {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	http.Error(w, "an internal error happened during face detection", 500)
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/bokan/facedetection/pkg/annotate"
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/imagecodec"
+)
+
+// writeAnnotated responds with the image in data, with faces drawn over it, encoded in dr.format.
+func writeAnnotated(w http.ResponseWriter, data []byte, faces []facedetect.Face, dr *detectRequest) {
+	decoded, err := imagecodec.Decode(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, "unsupported image format", 400)
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	if err := imagecodec.Encode(buf, annotate.Draw(decoded.Image, faces, dr.annotate), dr.format); err != nil {
+		http.Error(w, "an internal error happened during image annotation", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", imagecodec.ContentType(dr.format))
+	w.WriteHeader(200)
+	_, _ = w.Write(buf.Bytes())
+}
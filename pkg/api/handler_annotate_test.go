@@ -0,0 +1,109 @@
+package api
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bokan/facedetection/pkg/download/fakedownloader"
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/facedetect/fakefacedetect"
+)
+
+func TestAPI_handleFaceDetect_Annotated(t *testing.T) {
+	src := &bytes.Buffer{}
+	if err := png.Encode(src, image.NewGray(image.Rect(0, 0, 100, 100))); err != nil {
+		t.Fatal(err)
+	}
+	faces := []facedetect.Face{
+		{Bounds: &facedetect.Bounds{X: 10, Y: 10, Width: 50, Height: 50}, Score: 50},
+		{Bounds: &facedetect.Bounds{X: 70, Y: 70, Width: 20, Height: 20}, Score: 10},
+	}
+
+	tests := []struct {
+		format      string
+		contentType string
+	}{
+		{format: "png", contentType: "image/png"},
+		{format: "jpeg", contentType: "image/jpeg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			a := &API{
+				d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(bytes.NewReader(src.Bytes())), nil),
+				detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(faces, nil)),
+			}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/&format="+tt.format+"&box_color=00ff00&stroke_width=4&min_score=20", nil)
+			a.handleFaceDetect(rec, req)
+			if rec.Result().StatusCode != 200 {
+				t.Fatalf("handler should return status code 200 on success, got %d: %s", rec.Result().StatusCode, rec.Body.String())
+			}
+			if got := rec.Result().Header.Get("Content-Type"); got != tt.contentType {
+				t.Errorf("handler returned content type %q, want %q", got, tt.contentType)
+			}
+
+			out, format, err := image.Decode(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != tt.format || out.Bounds() != image.Rect(0, 0, 100, 100) {
+				t.Errorf("handler returned %s image with bounds %v", format, out.Bounds())
+			}
+			isGreen := func(x, y int) bool {
+				r, g, b, _ := out.At(x, y).RGBA()
+				return g > 0xc000 && r < 0x4000 && b < 0x4000
+			}
+			if !isGreen(10, 35) {
+				t.Errorf("face bounds should be drawn with the requested color, got %v", out.At(10, 35))
+			}
+			if isGreen(70, 80) {
+				t.Error("faces filtered out by min_score should not be drawn")
+			}
+			if r, g, b, _ := out.At(35, 35).RGBA(); r > 0x1000 || g > 0x1000 || b > 0x1000 {
+				t.Errorf("image should be left untouched inside the bounds, got %v", color.RGBA64{uint16(r), uint16(g), uint16(b), 0xffff})
+			}
+		})
+	}
+}
+
+func TestAPI_handleFaceDetect_AnnotatedInvalidImage(t *testing.T) {
+	a := &API{
+		d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(strings.NewReader("foo")), nil),
+		detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(nil, nil)),
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/&format=png", nil)
+	a.handleFaceDetect(rec, req)
+	if rec.Result().StatusCode != 400 {
+		t.Error("handler should return status code 400 when the image can't be decoded for annotation")
+	}
+}
+
+func TestAPI_handleFaceDetect_InvalidAnnotateOptions(t *testing.T) {
+	tests := []string{
+		"/?image_url=http://localhost/&format=gif",
+		"/?image_url=http://localhost/&format=png&frames=all",
+		"/?image_url=http://localhost/&format=png&frame=stored",
+		"/?image_url=http://localhost/&box_color=red",
+		"/?image_url=http://localhost/&eye_color=00ff",
+		"/?image_url=http://localhost/&stroke_width=0",
+		"/?image_url=http://localhost/&stroke_width=1000",
+		"/?image_url=http://localhost/&labels=all",
+	}
+	for _, target := range tests {
+		a := &API{}
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		a.handleFaceDetect(rec, req)
+		if rec.Result().StatusCode != 400 {
+			t.Errorf("handler should return status code 400 for invalid annotate options, target: %s", target)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+
+	"github.com/bokan/facedetection/pkg/align"
+	"github.com/bokan/facedetection/pkg/crop"
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/imagecodec"
+)
+
+// cropsManifest is the name of the face crops response part describing the crops.
+const cropsManifest = "faces.json"
+
+// Crop describes a single face thumbnail of a face crops response. File is the name of the
+// response part holding the thumbnail, or Data the thumbnail itself when crops are sent as JSON.
+// Region is the area of the image the thumbnail was cut out of.
+type Crop struct {
+	File      string            `json:"file,omitempty"`
+	Data      []byte            `json:"data,omitempty"`
+	Region    facedetect.Bounds `json:"region"`
+	Face      facedetect.Face   `json:"face"`
+	Alignment *Alignment        `json:"alignment,omitempty"`
+}
+
+// Alignment describes how a face chip was leveled. Roll is the in-plane rotation of the face in
+// degrees, positive values meaning counter-clockwise rotation, and EyeDistance the distance
+// between the eyes on the image, in pixels.
+type Alignment struct {
+	Roll        float64 `json:"roll"`
+	EyeDistance float64 `json:"eye_distance"`
+}
+
+// Crops is the manifest of a face crops response, sent as its first part.
+type Crops struct {
+	Crops       []Crop   `json:"crops"`
+	Format      string   `json:"format,omitempty"`
+	Orientation int      `json:"orientation"`
+	Detector    Detector `json:"detector"`
+}
+
+// cropFile is a named part of a face crops response.
+type cropFile struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// handleFaceCrops responds with the detected faces cut out of the downloaded image, packed as
+// a multipart/mixed body or a ZIP archive whose first part, faces.json, lists the crops, or as
+// the JSON list alone with the crops embedded.
+func (a *API) handleFaceCrops(w http.ResponseWriter, r *http.Request) {
+	cr, err := parseCropsRequest(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	fd, ok := a.detector(w, r)
+	if !ok {
+		return
+	}
+
+	data, ok := a.downloadAll(w, r, cr.imageURL)
+	if !ok {
+		return
+	}
+
+	result, err := fd.DetectFaces(r.Context(), bytes.NewReader(data), cr.opts)
+	if err != nil {
+		a.detectError(w, r, err)
+		return
+	}
+
+	decoded, err := imagecodec.Decode(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, "unsupported image format", 400)
+		return
+	}
+
+	manifest := Crops{
+		Crops:       []Crop{},
+		Format:      result.Format,
+		Orientation: result.Orientation,
+		Detector:    Detector{Name: fd.Name, Version: fd.Version},
+	}
+	var files []cropFile
+	for _, f := range cr.filterAndSort(result.Faces) {
+		img, c, ok := cutFace(decoded.Image, f, cr)
+		if !ok {
+			continue
+		}
+		buf := &bytes.Buffer{}
+		if err := imagecodec.Encode(buf, img, cr.format); err != nil {
+			http.Error(w, "an internal error happened during face cropping", http.StatusInternalServerError)
+			return
+		}
+
+		if cr.archive == archiveJSON {
+			c.Data = buf.Bytes()
+		} else {
+			c.File = fmt.Sprintf("face-%d.%s", len(files), cr.format)
+			files = append(files, cropFile{name: c.File, contentType: imagecodec.ContentType(cr.format), data: buf.Bytes()})
+		}
+		manifest.Crops = append(manifest.Crops, c)
+	}
+
+	js, err := json.Marshal(manifest)
+	if err != nil {
+		http.Error(w, "an internal error happened", http.StatusInternalServerError)
+		return
+	}
+	if cr.archive == archiveJSON {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		_, _ = w.Write(js)
+		return
+	}
+	files = append([]cropFile{{name: cropsManifest, contentType: "application/json", data: js}}, files...)
+
+	body := &bytes.Buffer{}
+	var contentType string
+	if cr.archive == archiveZIP {
+		contentType = "application/zip"
+		err = writeZIP(body, files)
+	} else {
+		contentType, err = writeMultipart(body, files)
+	}
+	if err != nil {
+		http.Error(w, "an internal error happened", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(200)
+	_, _ = w.Write(body.Bytes())
+}
+
+// cutFace returns the crop or the aligned chip of face f, and its description without the
+// image data. Faces that can't be cut out, for lack of bounds or eyes, are reported as not ok.
+func cutFace(img image.Image, f facedetect.Face, cr *cropsRequest) (image.Image, Crop, bool) {
+	c := Crop{Face: f}
+	var out image.Image
+	var region image.Rectangle
+	if cr.aligned {
+		chip, err := align.Align(img, f, cr.align)
+		if err != nil {
+			return nil, c, false
+		}
+		out, region = chip, chip.Region
+		c.Alignment = &Alignment{Roll: chip.Roll, EyeDistance: chip.EyeDistance}
+	} else {
+		if f.Bounds == nil {
+			return nil, c, false
+		}
+		th, err := crop.Crop(img, f.Bounds, cr.crop)
+		if err != nil {
+			return nil, c, false
+		}
+		out, region = th, th.Region
+	}
+	c.Region = facedetect.Bounds{X: region.Min.X, Y: region.Min.Y, Width: region.Dx(), Height: region.Dy()}
+	return out, c, true
+}
+
+// writeMultipart writes files as a multipart/mixed body and returns its content type.
+func writeMultipart(w io.Writer, files []cropFile) (string, error) {
+	mw := multipart.NewWriter(w)
+	for _, f := range files {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", f.contentType)
+		h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", f.name))
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return "", err
+		}
+		if _, err := part.Write(f.data); err != nil {
+			return "", err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+	return "multipart/mixed; boundary=" + mw.Boundary(), nil
+}
+
+// writeZIP writes files as a ZIP archive. Images are stored, as they're compressed already.
+func writeZIP(w io.Writer, files []cropFile) error {
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		method := zip.Store
+		if f.name == cropsManifest {
+			method = zip.Deflate
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: method})
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
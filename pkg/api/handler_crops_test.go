@@ -0,0 +1,209 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bokan/facedetection/pkg/download/fakedownloader"
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/facedetect/fakefacedetect"
+)
+
+func TestAPI_handleFaceCrops(t *testing.T) {
+	src := &bytes.Buffer{}
+	if err := png.Encode(src, image.NewGray(image.Rect(0, 0, 200, 100))); err != nil {
+		t.Fatal(err)
+	}
+	faces := []facedetect.Face{
+		{Bounds: &facedetect.Bounds{X: 10, Y: 10, Width: 40, Height: 40}, Score: 20},
+		{Bounds: &facedetect.Bounds{X: 100, Y: 20, Width: 60, Height: 60}, Score: 50},
+		{Bounds: &facedetect.Bounds{X: 150, Y: 70, Width: 20, Height: 20}, Score: 5},
+	}
+
+	// readers return the names of the response parts, in order, and their contents.
+	readers := map[string]func(t *testing.T, rec *httptest.ResponseRecorder) ([]string, map[string][]byte){
+		"multipart": func(t *testing.T, rec *httptest.ResponseRecorder) ([]string, map[string][]byte) {
+			mediaType, params, err := mime.ParseMediaType(rec.Result().Header.Get("Content-Type"))
+			if err != nil || mediaType != "multipart/mixed" {
+				t.Fatalf("handler returned content type %q", rec.Result().Header.Get("Content-Type"))
+			}
+			var names []string
+			files := map[string][]byte{}
+			mr := multipart.NewReader(rec.Body, params["boundary"])
+			for {
+				part, err := mr.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := ioutil.ReadAll(part)
+				if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, part.FileName())
+				files[part.FileName()] = data
+			}
+			return names, files
+		},
+		"zip": func(t *testing.T, rec *httptest.ResponseRecorder) ([]string, map[string][]byte) {
+			if ct := rec.Result().Header.Get("Content-Type"); ct != "application/zip" {
+				t.Fatalf("handler returned content type %q", ct)
+			}
+			zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			files := map[string][]byte{}
+			for _, f := range zr.File {
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := ioutil.ReadAll(rc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, f.Name)
+				files[f.Name] = data
+			}
+			return names, files
+		},
+	}
+	for archive, read := range readers {
+		t.Run(archive, func(t *testing.T) {
+			a := &API{
+				d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(bytes.NewReader(src.Bytes())), nil),
+				detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(faces, nil)),
+			}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/&archive="+archive+"&format=png&margin=0.5&size=32&min_score=10&sort=score", nil)
+			a.handleFaceCrops(rec, req)
+			if rec.Result().StatusCode != 200 {
+				t.Fatalf("handler should return status code 200 on success, got %d: %s", rec.Result().StatusCode, rec.Body.String())
+			}
+
+			names, files := read(t, rec)
+			if want := []string{"faces.json", "face-0.png", "face-1.png"}; strings.Join(names, ",") != strings.Join(want, ",") {
+				t.Fatalf("handler returned parts %v, want %v", names, want)
+			}
+			var manifest Crops
+			if err := json.Unmarshal(files["faces.json"], &manifest); err != nil {
+				t.Fatal(err)
+			}
+			if len(manifest.Crops) != 2 || manifest.Crops[0].Face.Score != 50 || manifest.Crops[0].File != "face-0.png" {
+				t.Fatalf("manifest should list the crops by score, got %+v", manifest.Crops)
+			}
+			// The 120 pixel square around the second face is shifted to fit the image height.
+			if got, want := manifest.Crops[0].Region, (facedetect.Bounds{X: 80, Y: 0, Width: 100, Height: 100}); got != want {
+				t.Errorf("manifest region = %+v, want %+v", got, want)
+			}
+			for _, name := range names[1:] {
+				img, err := png.Decode(bytes.NewReader(files[name]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if img.Bounds() != image.Rect(0, 0, 32, 32) {
+					t.Errorf("%s bounds = %v, want 32x32", name, img.Bounds())
+				}
+			}
+		})
+	}
+}
+
+func TestAPI_handleFaceCrops_AlignedJSON(t *testing.T) {
+	src := &bytes.Buffer{}
+	if err := png.Encode(src, image.NewGray(image.Rect(0, 0, 200, 100))); err != nil {
+		t.Fatal(err)
+	}
+	faces := []facedetect.Face{
+		{
+			Bounds:   &facedetect.Bounds{X: 50, Y: 20, Width: 60, Height: 60},
+			Score:    50,
+			LeftEye:  &facedetect.Point{X: 60, Y: 50},
+			RightEye: &facedetect.Point{X: 100, Y: 40},
+		},
+		// Faces without eyes can't be aligned.
+		{Bounds: &facedetect.Bounds{X: 120, Y: 20, Width: 60, Height: 60}, Score: 40},
+	}
+	a := &API{
+		d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(bytes.NewReader(src.Bytes())), nil),
+		detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(faces, nil)),
+	}
+	rec := httptest.NewRecorder()
+	a.handleFaceCrops(rec, httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/&mode=aligned&archive=json&format=png&size=64", nil))
+	if rec.Result().StatusCode != 200 {
+		t.Fatalf("handler should return status code 200 on success, got %d: %s", rec.Result().StatusCode, rec.Body.String())
+	}
+	if ct := rec.Result().Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("handler returned content type %q, want application/json", ct)
+	}
+
+	var manifest Crops
+	if err := json.Unmarshal(rec.Body.Bytes(), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Crops) != 1 {
+		t.Fatalf("handler should return the chip of the face with eyes only, got %d", len(manifest.Crops))
+	}
+	c := manifest.Crops[0]
+	if c.File != "" || c.Alignment == nil {
+		t.Fatalf("chip should be embedded and described by its alignment, got %+v", c)
+	}
+	if c.Alignment.Roll < 14 || c.Alignment.Roll > 14.1 || c.Alignment.EyeDistance < 41.2 || c.Alignment.EyeDistance > 41.3 {
+		t.Errorf("handler returned alignment %+v", c.Alignment)
+	}
+	img, err := png.Decode(bytes.NewReader(c.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 64, 64) {
+		t.Errorf("chip bounds = %v, want 64x64", img.Bounds())
+	}
+}
+
+func TestAPI_handleFaceCrops_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		body   string
+		fdErr  error
+		status int
+	}{
+		{name: "missing url", query: "", status: 400},
+		{name: "invalid mode", query: "image_url=http://localhost/&mode=circle", status: 400},
+		{name: "invalid margin", query: "image_url=http://localhost/&margin=-1", status: 400},
+		{name: "invalid size", query: "image_url=http://localhost/&size=4096", status: 400},
+		{name: "invalid format", query: "image_url=http://localhost/&format=json", status: 400},
+		{name: "invalid archive", query: "image_url=http://localhost/&archive=tar", status: 400},
+		{name: "all frames", query: "image_url=http://localhost/&frames=all", status: 400},
+		{name: "invalid image", query: "image_url=http://localhost/", body: "foo", status: 400},
+		{name: "busy", query: "image_url=http://localhost/", fdErr: facedetect.ErrBusy, status: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &API{
+				d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(strings.NewReader(tt.body)), nil),
+				detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(nil, tt.fdErr)),
+			}
+			rec := httptest.NewRecorder()
+			a.handleFaceCrops(rec, httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil))
+			if rec.Result().StatusCode != tt.status {
+				t.Errorf("handler returned status code %d, want %d: %s", rec.Result().StatusCode, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/imagecodec"
+	"github.com/bokan/facedetection/pkg/redact"
+)
+
+// handleFaceRedact responds with the downloaded image, its faces redacted. The image keeps its
+// format and dimensions, except for formats that can't be encoded, which are returned as PNG.
+// Metadata such as EXIF is dropped, with the orientation applied to the pixels.
+func (a *API) handleFaceRedact(w http.ResponseWriter, r *http.Request) {
+	rr, err := parseRedactRequest(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	fd, ok := a.detector(w, r)
+	if !ok {
+		return
+	}
+
+	data, ok := a.downloadAll(w, r, rr.imageURL)
+	if !ok {
+		return
+	}
+
+	result, err := fd.DetectFaces(r.Context(), bytes.NewReader(data), rr.opts)
+	if err != nil {
+		a.detectError(w, r, err)
+		return
+	}
+
+	anim, err := imagecodec.DecodeAll(bytes.NewReader(data), rr.opts.MaxFrames, rr.opts.MaxFramesPixels)
+	if err != nil {
+		http.Error(w, "unsupported image format", 400)
+		return
+	}
+	faces, ok := framesFaces(result, len(anim.Frames))
+	if !ok {
+		// Frames left out by the detector would be returned with their faces in the clear.
+		http.Error(w, "an internal error happened during face redaction", http.StatusInternalServerError)
+		return
+	}
+	for i := range anim.Frames {
+		anim.Frames[i].Image = redact.Redact(anim.Frames[i].Image, rr.faces(faces[i]), rr.redact)
+	}
+
+	format := anim.Format
+	buf := &bytes.Buffer{}
+	err = imagecodec.EncodeAll(buf, anim, format)
+	if err == imagecodec.ErrUnsupportedEncoding {
+		format = formatPNG
+		buf.Reset()
+		err = imagecodec.EncodeAll(buf, anim, format)
+	}
+	if err != nil {
+		http.Error(w, "an internal error happened during face redaction", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", imagecodec.ContentType(format))
+	w.WriteHeader(200)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// framesFaces returns the faces detected on each of the n frames of the image, or false when the
+// detector didn't analyze every frame. Detectors that don't report frames are assumed to have
+// analyzed a still image.
+func framesFaces(result *facedetect.Result, n int) ([][]facedetect.Face, bool) {
+	if len(result.Frames) == 0 {
+		return [][]facedetect.Face{result.Faces}, n == 1
+	}
+	if len(result.Frames) != n {
+		return nil, false
+	}
+	faces := make([][]facedetect.Face, n)
+	seen := make([]bool, n)
+	for _, f := range result.Frames {
+		if f.Index < 0 || f.Index >= n || seen[f.Index] {
+			return nil, false
+		}
+		seen[f.Index] = true
+		faces[f.Index] = f.Faces
+	}
+	return faces, true
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bokan/facedetection/pkg/download/fakedownloader"
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/facedetect/fakefacedetect"
+	"golang.org/x/image/webp"
+)
+
+func TestAPI_handleFaceRedact(t *testing.T) {
+	white := image.NewGray(image.Rect(0, 0, 100, 80))
+	for i := range white.Pix {
+		white.Pix[i] = 0xff
+	}
+	encode := func(format string) []byte {
+		buf := &bytes.Buffer{}
+		var err error
+		switch format {
+		case "png":
+			err = png.Encode(buf, white)
+		case "jpeg":
+			err = jpeg.Encode(buf, white, nil)
+		case "gif":
+			err = gif.Encode(buf, white, nil)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	webpData, err := ioutil.ReadFile("../imagecodec/testdata/gopher.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	faces := []facedetect.Face{
+		{Bounds: &facedetect.Bounds{X: 10, Y: 10, Width: 30, Height: 30}, Score: 50},
+		{Bounds: &facedetect.Bounds{X: 60, Y: 40, Width: 20, Height: 20}, Score: 10},
+	}
+
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		bounds      image.Rectangle
+	}{
+		{name: "png", data: encode("png"), contentType: "image/png", bounds: white.Rect},
+		{name: "jpeg", data: encode("jpeg"), contentType: "image/jpeg", bounds: white.Rect},
+		{name: "gif", data: encode("gif"), contentType: "image/gif", bounds: white.Rect},
+		{name: "webp falls back to png", data: webpData, contentType: "image/png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &API{
+				d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(bytes.NewReader(tt.data)), nil),
+				detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(faces, nil)),
+			}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/&method=fill&fill_color=000000&padding=0&min_score=20", nil)
+			a.handleFaceRedact(rec, req)
+			if rec.Result().StatusCode != 200 {
+				t.Fatalf("handler should return status code 200 on success, got %d: %s", rec.Result().StatusCode, rec.Body.String())
+			}
+			if got := rec.Result().Header.Get("Content-Type"); got != tt.contentType {
+				t.Errorf("handler returned content type %q, want %q", got, tt.contentType)
+			}
+			out, _, err := image.Decode(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.bounds.Empty() {
+				src, err := webp.Decode(bytes.NewReader(tt.data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				tt.bounds = src.Bounds()
+			}
+			if out.Bounds() != tt.bounds {
+				t.Errorf("handler returned image with bounds %v, want %v", out.Bounds(), tt.bounds)
+			}
+			if y := color.GrayModel.Convert(out.At(25, 25)).(color.Gray).Y; y > 0x20 {
+				t.Errorf("face should be filled, got %#x", y)
+			}
+			if tt.name != "webp falls back to png" {
+				if y := color.GrayModel.Convert(out.At(70, 50)).(color.Gray).Y; y < 0xe0 {
+					t.Errorf("faces filtered out by min_score should not be redacted, got %#x", y)
+				}
+			}
+		})
+	}
+}
+
+func TestAPI_handleFaceRedact_Animated(t *testing.T) {
+	pal := color.Palette{color.Black, color.White}
+	g := &gif.GIF{Delay: []int{10, 20}}
+	for i := 0; i < 2; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 60, 60), pal)
+		for j := range frame.Pix {
+			frame.Pix[j] = 1
+		}
+		g.Image = append(g.Image, frame)
+	}
+	buf := &bytes.Buffer{}
+	if err := gif.EncodeAll(buf, g); err != nil {
+		t.Fatal(err)
+	}
+
+	fd := &framesFaceDetect{}
+	a := &API{
+		d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil),
+		detectors: singleDetector(fd),
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/&method=fill&padding=0", nil)
+	a.handleFaceRedact(rec, req)
+	if rec.Result().StatusCode != 200 {
+		t.Fatalf("handler should return status code 200 on success, got %d: %s", rec.Result().StatusCode, rec.Body.String())
+	}
+	if !fd.opts.AllFrames {
+		t.Error("faces should be detected on every frame")
+	}
+	out, err := gif.DecodeAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Image) != 2 || out.Delay[1] != 20 {
+		t.Fatalf("handler should keep the animation, got %d frames with delays %v", len(out.Image), out.Delay)
+	}
+	// Each frame has its own face, at 0, 0 and 30, 30.
+	isBlack := func(img image.Image, x, y int) bool {
+		return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y < 0x20
+	}
+	if !isBlack(out.Image[0], 5, 5) || isBlack(out.Image[0], 35, 35) {
+		t.Error("first frame should have its face redacted only")
+	}
+	if isBlack(out.Image[1], 5, 5) || !isBlack(out.Image[1], 35, 35) {
+		t.Error("second frame should have its face redacted only")
+	}
+}
+
+func TestAPI_handleFaceRedact_MissingFrames(t *testing.T) {
+	pal := color.Palette{color.Black, color.White}
+	g := &gif.GIF{Delay: []int{10, 20}}
+	for i := 0; i < 2; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 60, 60), pal))
+	}
+	buf := &bytes.Buffer{}
+	if err := gif.EncodeAll(buf, g); err != nil {
+		t.Fatal(err)
+	}
+
+	// The detector reports faces, but not per frame.
+	faces := []facedetect.Face{{Bounds: &facedetect.Bounds{X: 0, Y: 0, Width: 10, Height: 10}}}
+	a := &API{
+		d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil),
+		detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(faces, nil)),
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/", nil)
+	a.handleFaceRedact(rec, req)
+	if rec.Result().StatusCode != http.StatusInternalServerError {
+		t.Errorf("handler should fail rather than return frames without redaction, got %d", rec.Result().StatusCode)
+	}
+	if ct := rec.Header().Get("Content-Type"); strings.HasPrefix(ct, "image/") {
+		t.Errorf("handler should not return an image, got %s", ct)
+	}
+}
+
+func Test_framesFaces(t *testing.T) {
+	face := []facedetect.Face{{Score: 1}}
+	tests := []struct {
+		name   string
+		result *facedetect.Result
+		n      int
+		want   bool
+	}{
+		{name: "still image", result: &facedetect.Result{Faces: face}, n: 1, want: true},
+		{name: "animation without frames", result: &facedetect.Result{Faces: face}, n: 2},
+		{name: "every frame", result: &facedetect.Result{Frames: []facedetect.FrameFaces{{Index: 1}, {Index: 0, Faces: face}}}, n: 2, want: true},
+		{name: "missing frame", result: &facedetect.Result{Frames: []facedetect.FrameFaces{{Index: 0, Faces: face}}}, n: 2},
+		{name: "duplicate frame", result: &facedetect.Result{Frames: []facedetect.FrameFaces{{Index: 0}, {Index: 0}}}, n: 2},
+		{name: "unknown frame", result: &facedetect.Result{Frames: []facedetect.FrameFaces{{Index: 0}, {Index: 2}}}, n: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			faces, ok := framesFaces(tt.result, tt.n)
+			if ok != tt.want {
+				t.Fatalf("framesFaces() ok = %v, want %v", ok, tt.want)
+			}
+			if ok && (len(faces) != tt.n || len(faces[0]) != 1) {
+				t.Errorf("framesFaces() = %v", faces)
+			}
+		})
+	}
+}
+
+// framesFaceDetect reports a 10x10 face on every frame, moving by 30 pixels diagonally.
+type framesFaceDetect struct {
+	opts facedetect.DetectOptions
+}
+
+func (f *framesFaceDetect) DetectFaces(ctx context.Context, img io.Reader, opts facedetect.DetectOptions) (*facedetect.Result, error) {
+	f.opts = opts
+	res := &facedetect.Result{Format: "gif", Orientation: 1}
+	for i := 0; i < 2; i++ {
+		faces := []facedetect.Face{{Bounds: &facedetect.Bounds{X: 30 * i, Y: 30 * i, Width: 10, Height: 10}}}
+		res.Frames = append(res.Frames, facedetect.FrameFaces{Index: i, Faces: faces})
+	}
+	res.Faces = res.Frames[0].Faces
+	return res, nil
+}
+
+func TestAPI_handleFaceRedact_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		body   string
+		fdErr  error
+		status int
+	}{
+		{name: "missing url", query: "", status: 400},
+		{name: "invalid method", query: "image_url=http://localhost/&method=erase", status: 400},
+		{name: "invalid padding", query: "image_url=http://localhost/&padding=2", status: 400},
+		{name: "invalid strength", query: "image_url=http://localhost/&strength=-1", status: 400},
+		{name: "invalid fill color", query: "image_url=http://localhost/&fill_color=red", status: 400},
+		{name: "translucent fill color", query: "image_url=http://localhost/&fill_color=00000000", status: 400},
+		{name: "stored frame", query: "image_url=http://localhost/&frame=stored", status: 400},
+		{name: "too many angles for every frame", query: "image_url=http://localhost/&angles=-10,0,10,20,30&max_pixels=1000000", status: 400},
+		{name: "invalid image", query: "image_url=http://localhost/", body: "foo", status: 400},
+		{name: "busy", query: "image_url=http://localhost/", fdErr: facedetect.ErrBusy, status: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &API{
+				d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(strings.NewReader(tt.body)), nil),
+				detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(nil, tt.fdErr)),
+			}
+			rec := httptest.NewRecorder()
+			a.handleFaceRedact(rec, httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil))
+			if rec.Result().StatusCode != tt.status {
+				t.Errorf("handler returned status code %d, want %d: %s", rec.Result().StatusCode, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"net/http"
+
+	"github.com/bokan/facedetection/pkg/crop"
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/imagecodec"
+	"golang.org/x/image/draw"
+)
+
+// SmartCrop is the JSON response of a smart crop request. Crop is the area of the image, as
+// displayed, with the requested aspect ratio, Faces are the faces the crop was placed around.
+// Faces left out of the crop, or cut off by it, aren't listed, except for a single face too
+// large for the crop, which is centered in it.
+type SmartCrop struct {
+	Crop        facedetect.Bounds `json:"crop"`
+	Faces       []facedetect.Face `json:"faces"`
+	Width       int               `json:"width"`
+	Height      int               `json:"height"`
+	Format      string            `json:"format,omitempty"`
+	Orientation int               `json:"orientation"`
+	Detector    Detector          `json:"detector"`
+}
+
+// handleSmartCrop responds with the crop of the downloaded image to the requested aspect ratio
+// keeping the faces in frame, either as JSON or as the cropped image resized to the requested size.
+func (a *API) handleSmartCrop(w http.ResponseWriter, r *http.Request) {
+	sr, err := parseSmartCropRequest(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	fd, ok := a.detector(w, r)
+	if !ok {
+		return
+	}
+
+	data, ok := a.downloadAll(w, r, sr.imageURL)
+	if !ok {
+		return
+	}
+
+	result, err := fd.DetectFaces(r.Context(), bytes.NewReader(data), sr.opts)
+	if err != nil {
+		a.detectError(w, r, err)
+		return
+	}
+
+	decoded, err := imagecodec.Decode(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, "unsupported image format", 400)
+		return
+	}
+	ib := decoded.Bounds()
+	rect, faces := crop.Smart(image.Rect(0, 0, ib.Dx(), ib.Dy()), sr.faces(result.Faces), sr.width, sr.height)
+
+	if sr.format != formatJSON {
+		dst := image.NewNRGBA(image.Rect(0, 0, sr.width, sr.height))
+		draw.CatmullRom.Scale(dst, dst.Rect, decoded.Image, rect.Add(ib.Min), draw.Src, nil)
+		buf := &bytes.Buffer{}
+		if err := imagecodec.Encode(buf, dst, sr.format); err != nil {
+			http.Error(w, "an internal error happened during smart crop", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", imagecodec.ContentType(sr.format))
+		w.WriteHeader(200)
+		_, _ = w.Write(buf.Bytes())
+		return
+	}
+
+	response := SmartCrop{
+		Crop:        facedetect.Bounds{X: rect.Min.X, Y: rect.Min.Y, Width: rect.Dx(), Height: rect.Dy()},
+		Faces:       faces,
+		Width:       ib.Dx(),
+		Height:      ib.Dy(),
+		Format:      result.Format,
+		Orientation: result.Orientation,
+		Detector:    Detector{Name: fd.Name, Version: fd.Version},
+	}
+	if response.Faces == nil {
+		response.Faces = []facedetect.Face{}
+	}
+	js, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "an internal error happened", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, _ = w.Write(js)
+}
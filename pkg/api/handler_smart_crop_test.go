@@ -0,0 +1,124 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bokan/facedetection/pkg/download/fakedownloader"
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/facedetect/fakefacedetect"
+)
+
+func TestAPI_handleSmartCrop(t *testing.T) {
+	src := &bytes.Buffer{}
+	if err := png.Encode(src, image.NewGray(image.Rect(0, 0, 400, 200))); err != nil {
+		t.Fatal(err)
+	}
+	faces := []facedetect.Face{
+		{Bounds: &facedetect.Bounds{X: 300, Y: 50, Width: 40, Height: 40}, Score: 50},
+		{Bounds: &facedetect.Bounds{X: 10, Y: 50, Width: 40, Height: 40}, Score: 5},
+	}
+
+	tests := []struct {
+		name  string
+		faces []facedetect.Face
+		query string
+		want  facedetect.Bounds
+		kept  int
+	}{
+		{name: "faces", faces: faces, query: "width=100&height=100&min_score=10", want: facedetect.Bounds{X: 200, Y: 0, Width: 200, Height: 200}, kept: 1},
+		{name: "face left out", faces: faces, query: "width=100&height=100", want: facedetect.Bounds{X: 200, Y: 0, Width: 200, Height: 200}, kept: 1},
+		{name: "no faces", query: "width=100&height=100", want: facedetect.Bounds{X: 100, Y: 0, Width: 200, Height: 200}},
+		{name: "wide", faces: faces, query: "width=1600&height=400", want: facedetect.Bounds{X: 0, Y: 20, Width: 400, Height: 100}, kept: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &API{
+				d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(bytes.NewReader(src.Bytes())), nil),
+				detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(tt.faces, nil)),
+			}
+			rec := httptest.NewRecorder()
+			a.handleSmartCrop(rec, httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/&"+tt.query, nil))
+			if rec.Result().StatusCode != 200 {
+				t.Fatalf("handler should return status code 200 on success, got %d: %s", rec.Result().StatusCode, rec.Body.String())
+			}
+			var res SmartCrop
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Crop != tt.want {
+				t.Errorf("handler returned crop %+v, want %+v", res.Crop, tt.want)
+			}
+			if len(res.Faces) != tt.kept {
+				t.Errorf("handler returned %d faces, want the %d the crop was placed around", len(res.Faces), tt.kept)
+			}
+			if res.Width != 400 || res.Height != 200 {
+				t.Errorf("handler returned image size %dx%d, want 400x200", res.Width, res.Height)
+			}
+		})
+	}
+}
+
+func TestAPI_handleSmartCrop_Image(t *testing.T) {
+	src := &bytes.Buffer{}
+	if err := png.Encode(src, image.NewGray(image.Rect(0, 0, 400, 200))); err != nil {
+		t.Fatal(err)
+	}
+	a := &API{
+		d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(bytes.NewReader(src.Bytes())), nil),
+		detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(nil, nil)),
+	}
+	rec := httptest.NewRecorder()
+	a.handleSmartCrop(rec, httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/&width=64&height=48&format=jpeg", nil))
+	if rec.Result().StatusCode != 200 {
+		t.Fatalf("handler should return status code 200 on success, got %d: %s", rec.Result().StatusCode, rec.Body.String())
+	}
+	if ct := rec.Result().Header.Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("handler returned content type %q, want image/jpeg", ct)
+	}
+	out, _, err := image.Decode(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Bounds() != image.Rect(0, 0, 64, 48) {
+		t.Errorf("handler returned image with bounds %v, want the requested size", out.Bounds())
+	}
+}
+
+func TestAPI_handleSmartCrop_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		body   string
+		fdErr  error
+		status int
+	}{
+		{name: "missing size", query: "image_url=http://localhost/&width=100", status: 400},
+		{name: "invalid size", query: "image_url=http://localhost/&width=0&height=100", status: 400},
+		{name: "too large", query: "image_url=http://localhost/&width=10000&height=100", status: 400},
+		{name: "invalid format", query: "image_url=http://localhost/&width=1&height=1&format=gif", status: 400},
+		{name: "stored frame", query: "image_url=http://localhost/&width=1&height=1&frame=stored", status: 400},
+		{name: "invalid image", query: "image_url=http://localhost/&width=1&height=1", body: "foo", status: 400},
+		{name: "busy", query: "image_url=http://localhost/&width=1&height=1", fdErr: facedetect.ErrBusy, status: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &API{
+				d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(strings.NewReader(tt.body)), nil),
+				detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(nil, tt.fdErr)),
+			}
+			rec := httptest.NewRecorder()
+			a.handleSmartCrop(rec, httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil))
+			if rec.Result().StatusCode != tt.status {
+				t.Errorf("handler returned status code %d, want %d: %s", rec.Result().StatusCode, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
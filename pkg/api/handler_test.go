@@ -1,18 +1,31 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/bokan/facedetection/pkg/download"
 	"github.com/bokan/facedetection/pkg/download/fakedownloader"
 	"github.com/bokan/facedetection/pkg/facedetect"
 	"github.com/bokan/facedetection/pkg/facedetect/fakefacedetect"
+	"github.com/bokan/facedetection/pkg/facedetect/registry"
 )
 
+// singleDetector returns a registry holding fd only, named "fake".
+func singleDetector(fd facedetect.FaceDetector) *registry.Registry {
+	r := registry.NewRegistry()
+	_ = r.Register("fake", "1", fd)
+	return r
+}
+
 func TestAPI_handleFaceDetect_WithoutImageURLParam(t *testing.T) {
 	a := &API{}
 	rec := httptest.NewRecorder()
@@ -43,9 +56,62 @@ func TestAPI_handleFaceDetect_InvalidImageURLScheme(t *testing.T) {
 	}
 }
 
+func TestAPI_handleFaceDetect_InvalidDetectOptions(t *testing.T) {
+	tests := []string{
+		"/?image_url=http://localhost/&min_size=foo",
+		"/?image_url=http://localhost/&scale_factor=NaN",
+		"/?image_url=http://localhost/&min_size=100&max_size=50",
+		"/?image_url=http://localhost/&perturbs=0",
+		"/?image_url=http://localhost/&angles=0,foo",
+		"/?image_url=http://localhost/&angles=400",
+		"/?image_url=http://localhost/&landmarks=some",
+		"/?image_url=http://localhost/&frame=upside-down",
+		"/?image_url=http://localhost/&frames=some",
+		"/?image_url=http://localhost/&frames=all&max_frames=0",
+		"/?image_url=http://localhost/&min_size=5&scale_factor=1.5",
+		"/?image_url=http://localhost/&min_size_ratio=0.001",
+		"/?image_url=http://localhost/&shift_factor=0.01",
+		"/?image_url=http://localhost/&max_pixels=0",
+		"/?image_url=http://localhost/&max_pixels=100000000",
+		"/?image_url=http://localhost/&max_frames=100000",
+		"/?image_url=http://localhost/&angles=-20,-10,0,10,20",
+		"/?image_url=http://localhost/&frames=all&angles=-10,0,10,20,30&max_pixels=1000000",
+	}
+	for _, target := range tests {
+		a := &API{}
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		a.handleFaceDetect(rec, req)
+		if rec.Result().StatusCode != 400 {
+			t.Errorf("handler should return status code 400 for invalid detect options, target: %s", target)
+		}
+	}
+}
+
+func Test_checkDetectCost(t *testing.T) {
+	opts := facedetect.DefaultDetectOptions()
+	if err := checkDetectCost(opts); err != nil {
+		t.Errorf("checkDetectCost() should accept the default options, got: %v", err)
+	}
+	opts.Angles = []float64{-20, -10, 0, 10, 20}
+	opts.MaxPixels = 3000000
+	if err := checkDetectCost(opts); err != nil {
+		t.Errorf("checkDetectCost() should accept more angles on fewer pixels, got: %v", err)
+	}
+	opts.AllFrames = true
+	if err := checkDetectCost(opts); err == nil {
+		t.Error("checkDetectCost() should bound the angles on every frame")
+	}
+	opts.MaxFramesPixels = 20000000
+	if err := checkDetectCost(opts); err != nil {
+		t.Errorf("checkDetectCost() should accept more angles on fewer frame pixels, got: %v", err)
+	}
+}
+
 func TestAPI_handleFaceDetect_DownloaderError(t *testing.T) {
 	a := &API{
-		d: fakedownloader.NewFakeDownloader(nil, fmt.Errorf("fake error")),
+		d:         fakedownloader.NewFakeDownloader(nil, fmt.Errorf("fake error")),
+		detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(nil, nil)),
 	}
 	rec := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/", nil)
@@ -55,10 +121,82 @@ func TestAPI_handleFaceDetect_DownloaderError(t *testing.T) {
 	}
 }
 
+func TestAPI_handleFaceDetect_DownloaderForbiddenAddress(t *testing.T) {
+	a := &API{
+		d:         fakedownloader.NewFakeDownloader(nil, fmt.Errorf("request failed: %w", download.ErrForbiddenAddress)),
+		detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(nil, nil)),
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/?image_url=http://10.0.0.1/", nil)
+	a.handleFaceDetect(rec, req)
+	if rec.Result().StatusCode != http.StatusForbidden {
+		t.Error("handler should return status code 403 when the image is on a forbidden address")
+	}
+}
+
+func TestAPI_handleFaceDetect_DownloaderRedirectErrors(t *testing.T) {
+	for _, err := range []error{download.ErrTooManyRedirects, download.ErrForbiddenRedirect} {
+		a := &API{
+			d:         fakedownloader.NewFakeDownloader(nil, fmt.Errorf("request failed: %w", err)),
+			detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(nil, nil)),
+		}
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/", nil)
+		a.handleFaceDetect(rec, req)
+		if rec.Result().StatusCode != 400 || !strings.Contains(rec.Body.String(), "redirects") {
+			t.Errorf("handler should return status code 400 explaining the redirect error %v, got %d %s", err, rec.Result().StatusCode, rec.Body.String())
+		}
+	}
+}
+
+func TestAPI_handleFaceDetect_ImageURLHeader(t *testing.T) {
+	a := &API{
+		d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(strings.NewReader("")), nil),
+		detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(nil, nil)),
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/", nil)
+	a.handleFaceDetect(rec, req)
+	if got := rec.Header().Get(headerImageURL); got != "http://localhost/" {
+		t.Errorf("handler should name the downloaded image url in the %s header, got %q", headerImageURL, got)
+	}
+}
+
+func TestAPI_handleFaceDetect_DownloaderInvalidImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		download error
+		detect   error
+		want     string
+	}{
+		{name: "not an image", download: download.ErrNotAnImage, want: "image_url is not an image"},
+		{name: "too big", download: download.ErrFileIsTooBig, want: "image is too big"},
+		{name: "too big while detecting", detect: download.ErrFileIsTooBig, want: "image is too big"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.ReadCloser
+			if tt.download == nil {
+				body = ioutil.NopCloser(strings.NewReader(""))
+			}
+			a := &API{
+				d:         fakedownloader.NewFakeDownloader(body, tt.download),
+				detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(nil, tt.detect)),
+			}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/", nil)
+			a.handleFaceDetect(rec, req)
+			if rec.Result().StatusCode != 400 || !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("handler should return status code 400 with %q, got %d %s", tt.want, rec.Result().StatusCode, rec.Body.String())
+			}
+		})
+	}
+}
+
 func TestAPI_handleFaceDetect_FaceDetectorErrorUnsupportedImageFormat(t *testing.T) {
 	a := &API{
-		d:  fakedownloader.NewFakeDownloader(ioutil.NopCloser(strings.NewReader("")), nil),
-		fd: fakefacedetect.NewFakeFaceDetect(nil, facedetect.ErrUnsupportedImageFormat),
+		d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(strings.NewReader("")), nil),
+		detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(nil, facedetect.ErrUnsupportedImageFormat)),
 	}
 	rec := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/", nil)
@@ -68,10 +206,39 @@ func TestAPI_handleFaceDetect_FaceDetectorErrorUnsupportedImageFormat(t *testing
 	}
 }
 
+func TestAPI_handleFaceDetect_FaceDetectorErrorAnimationTooLarge(t *testing.T) {
+	a := &API{
+		d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(strings.NewReader("")), nil),
+		detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(nil, facedetect.ErrAnimationTooLarge)),
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/&frames=all", nil)
+	a.handleFaceDetect(rec, req)
+	if rec.Result().StatusCode != 400 {
+		t.Error("handler should return status code 400 when the animation exceeds the frame limits")
+	}
+}
+
+func TestAPI_handleFaceDetect_FaceDetectorBusy(t *testing.T) {
+	a := &API{
+		d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(strings.NewReader("")), nil),
+		detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(nil, facedetect.ErrBusy)),
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/", nil)
+	a.handleFaceDetect(rec, req)
+	if rec.Result().StatusCode != http.StatusTooManyRequests {
+		t.Error("handler should return status code 429 when face detector is busy")
+	}
+	if rec.Result().Header.Get("Retry-After") == "" {
+		t.Error("handler should set Retry-After header when face detector is busy")
+	}
+}
+
 func TestAPI_handleFaceDetect_FaceDetectorError(t *testing.T) {
 	a := &API{
-		d:  fakedownloader.NewFakeDownloader(ioutil.NopCloser(strings.NewReader("")), nil),
-		fd: fakefacedetect.NewFakeFaceDetect(nil, fmt.Errorf("fake error")),
+		d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(strings.NewReader("")), nil),
+		detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(nil, fmt.Errorf("fake error"))),
 	}
 	rec := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/", nil)
@@ -81,6 +248,48 @@ func TestAPI_handleFaceDetect_FaceDetectorError(t *testing.T) {
 	}
 }
 
+func TestAPI_handleFaceDetect_ContextEnded(t *testing.T) {
+	tests := []struct {
+		name       string
+		cancelBase bool
+		want       int
+	}{
+		{
+			name:       "client closed request",
+			cancelBase: false,
+			want:       statusClientClosedRequest,
+		},
+		{
+			name:       "server shutting down",
+			cancelBase: true,
+			want:       http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, cancelBase := context.WithCancel(context.Background())
+			defer cancelBase()
+			ctx, cancel := context.WithCancel(base)
+			if tt.cancelBase {
+				cancelBase()
+			}
+			cancel()
+
+			a := &API{
+				d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(strings.NewReader("")), nil),
+				detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(nil, context.Canceled)),
+				ctx:       base,
+			}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/", nil).WithContext(ctx)
+			a.handleFaceDetect(rec, req)
+			if rec.Result().StatusCode != tt.want {
+				t.Errorf("handler should return status code %d, got %d", tt.want, rec.Result().StatusCode)
+			}
+		})
+	}
+}
+
 func TestAPI_handleFaceDetect_Success(t *testing.T) {
 	faces := []facedetect.Face{
 		{
@@ -90,6 +299,7 @@ func TestAPI_handleFaceDetect_Success(t *testing.T) {
 				Height: 100,
 				Width:  100,
 			},
+			Score: 42.5,
 			Mouth: &facedetect.Point{
 				X: 60,
 				Y: 100,
@@ -105,8 +315,8 @@ func TestAPI_handleFaceDetect_Success(t *testing.T) {
 		},
 	}
 	a := &API{
-		d:  fakedownloader.NewFakeDownloader(ioutil.NopCloser(strings.NewReader("")), nil),
-		fd: fakefacedetect.NewFakeFaceDetect(faces, nil),
+		d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(strings.NewReader("")), nil),
+		detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(faces, nil)),
 	}
 	rec := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/", nil)
@@ -120,9 +330,128 @@ func TestAPI_handleFaceDetect_Success(t *testing.T) {
 	}
 
 	got := rec.Body.String()
-	expected := "{\"Faces\":[{\"bounds\":{\"x\":10,\"y\":20,\"height\":100,\"width\":100},\"mouth\":{\"x\":60,\"y\":100},\"right_eye\":{\"x\":30,\"y\":50},\"left_eye\":{\"x\":90,\"y\":50}}]}"
+	expected := "{\"Faces\":[{\"bounds\":{\"x\":10,\"y\":20,\"height\":100,\"width\":100},\"score\":42.5,\"mouth\":{\"x\":60,\"y\":100},\"right_eye\":{\"x\":30,\"y\":50},\"left_eye\":{\"x\":90,\"y\":50}}],\"orientation\":1,\"detector\":{\"name\":\"fake\",\"version\":\"1\"}}"
 	if got != expected {
 		t.Error("handler should return expected json payload")
 	}
 
 }
+
+func TestAPI_handleFaceDetect_MinScoreAndSort(t *testing.T) {
+	faces := []facedetect.Face{
+		{Bounds: &facedetect.Bounds{X: 1, Width: 10, Height: 10}, Score: 30},
+		{Bounds: &facedetect.Bounds{X: 2, Width: 50, Height: 50}, Score: 10},
+		{Bounds: &facedetect.Bounds{X: 3, Width: 20, Height: 20}, Score: 50},
+	}
+	tests := []struct {
+		name  string
+		query string
+		wantX []int
+	}{
+		{
+			name:  "detector order",
+			query: "",
+			wantX: []int{1, 2, 3},
+		},
+		{
+			name:  "min score",
+			query: "&min_score=20",
+			wantX: []int{1, 3},
+		},
+		{
+			name:  "sort by score",
+			query: "&sort=score",
+			wantX: []int{3, 1, 2},
+		},
+		{
+			name:  "sort by size ascending",
+			query: "&sort=size&order=asc",
+			wantX: []int{1, 3, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &API{
+				d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(strings.NewReader("")), nil),
+				detectors: singleDetector(fakefacedetect.NewFakeFaceDetect(faces, nil)),
+			}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/"+tt.query, nil)
+			a.handleFaceDetect(rec, req)
+			if rec.Result().StatusCode != 200 {
+				t.Fatalf("handler should return status code 200, got %d", rec.Result().StatusCode)
+			}
+			var got Faces
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			var gotX []int
+			for _, f := range got.Faces {
+				gotX = append(gotX, f.Bounds.X)
+			}
+			if !reflect.DeepEqual(gotX, tt.wantX) {
+				t.Errorf("got faces %v, want %v", gotX, tt.wantX)
+			}
+		})
+	}
+}
+
+func TestAPI_handleFaceDetect_InvalidSort(t *testing.T) {
+	a := &API{}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/&sort=foo", nil)
+	a.handleFaceDetect(rec, req)
+	if rec.Result().StatusCode != 400 {
+		t.Error("handler should return status code 400 for unknown sort key")
+	}
+}
+
+func TestAPI_handleFaceDetect_Detector(t *testing.T) {
+	detectors := registry.NewRegistry()
+	_ = detectors.Register("pigo", "1.4.2", fakefacedetect.NewFakeFaceDetect(nil, nil))
+	faces := []facedetect.Face{{Bounds: &facedetect.Bounds{Width: 10, Height: 10}, Score: 10}}
+	_ = detectors.Register("other", "2", fakefacedetect.NewFakeFaceDetect(faces, nil))
+
+	tests := []struct {
+		name     string
+		detector string
+		want     Detector
+		faces    int
+	}{
+		{name: "default", want: Detector{Name: "pigo", Version: "1.4.2"}},
+		{name: "selected", detector: "other", want: Detector{Name: "other", Version: "2"}, faces: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &API{
+				d:         fakedownloader.NewFakeDownloader(ioutil.NopCloser(strings.NewReader("")), nil),
+				detectors: detectors,
+			}
+			rec := httptest.NewRecorder()
+			a.handleFaceDetect(rec, httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/&detector="+tt.detector, nil))
+			if rec.Result().StatusCode != 200 {
+				t.Fatalf("handler should return status code 200 on success, got %d: %s", rec.Result().StatusCode, rec.Body.String())
+			}
+			var res Faces
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Detector != tt.want || len(res.Faces) != tt.faces {
+				t.Errorf("handler returned %d faces from detector %+v, want %d from %+v", len(res.Faces), res.Detector, tt.faces, tt.want)
+			}
+			if h := rec.Result().Header; h.Get("X-Face-Detector") != tt.want.Name || h.Get("X-Face-Detector-Version") != tt.want.Version {
+				t.Errorf("handler returned detector headers %q %q", h.Get("X-Face-Detector"), h.Get("X-Face-Detector-Version"))
+			}
+		})
+	}
+
+	a := &API{detectors: detectors}
+	rec := httptest.NewRecorder()
+	a.handleFaceDetect(rec, httptest.NewRequest(http.MethodGet, "/?image_url=http://localhost/&detector=opencv", nil))
+	if rec.Result().StatusCode != 400 {
+		t.Errorf("handler should return status code 400 for unknown detectors, got %d", rec.Result().StatusCode)
+	}
+	if got := rec.Body.String(); !strings.Contains(got, "other, pigo") {
+		t.Errorf("handler should list the known detectors, got %q", got)
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/redact"
+)
+
+const (
+	methodBlur     = "blur"
+	methodPixelate = "pixelate"
+	methodFill     = "fill"
+
+	// maxStrength is the largest blur deviation or pixelation block size, in pixels.
+	maxStrength = 500
+)
+
+// redactRequest holds the validated query parameters of a face redaction request.
+type redactRequest struct {
+	imageURL string
+	opts     facedetect.DetectOptions
+
+	// minScore skips the faces detected with lower confidence.
+	minScore float32
+	redact   redact.Options
+}
+
+// parseRedactRequest validates the query parameters and fills in the defaults for the omitted ones.
+// Returned errors are meant to be shown to the client.
+func parseRedactRequest(q url.Values) (*redactRequest, error) {
+	imageURL, err := parseImageURL(q)
+	if err != nil {
+		return nil, err
+	}
+
+	rr := &redactRequest{
+		imageURL: imageURL,
+		opts:     facedetect.DefaultDetectOptions(),
+		redact:   redact.DefaultOptions(),
+	}
+	if err := parseDetectOptions(q, &rr.opts); err != nil {
+		return nil, err
+	}
+	// Faces are redacted on the image as displayed, on every frame of animations.
+	if rr.opts.Frame == facedetect.FrameStored {
+		return nil, fmt.Errorf("frame=stored is not supported by face redaction")
+	}
+	rr.opts.AllFrames = true
+	if err := checkDetectCost(rr.opts); err != nil {
+		return nil, err
+	}
+
+	if err := float32Param(q, "min_score", &rr.minScore); err != nil {
+		return nil, err
+	}
+
+	switch q.Get("method") {
+	case "", methodBlur:
+		rr.redact.Method = redact.MethodBlur
+	case methodPixelate:
+		rr.redact.Method = redact.MethodPixelate
+	case methodFill:
+		rr.redact.Method = redact.MethodFill
+	default:
+		return nil, fmt.Errorf("method must be one of blur, pixelate or fill")
+	}
+	if err := floatParam(q, "padding", &rr.redact.Padding); err != nil {
+		return nil, err
+	}
+	if rr.redact.Padding < 0 || rr.redact.Padding > 1 {
+		return nil, fmt.Errorf("padding must be in [0, 1]")
+	}
+	if err := intParam(q, "strength", &rr.redact.Strength); err != nil {
+		return nil, err
+	}
+	if rr.redact.Strength < 0 || rr.redact.Strength > maxStrength {
+		return nil, fmt.Errorf("strength must be in [0, %d]", maxStrength)
+	}
+	if err := colorParam(q, "fill_color", &rr.redact.FillColor); err != nil {
+		return nil, err
+	}
+	if _, _, _, a := rr.redact.FillColor.RGBA(); a != 0xffff {
+		return nil, fmt.Errorf("fill_color must be opaque")
+	}
+	return rr, nil
+}
+
+// values returns the canonical form of the request.
+func (rr *redactRequest) values() url.Values {
+	v := url.Values{}
+	v.Set("image_url", rr.imageURL)
+	detectOptionsValues(v, rr.opts)
+	v.Set("min_score", formatFloat(float64(rr.minScore)))
+	switch rr.redact.Method {
+	case redact.MethodPixelate:
+		v.Set("method", methodPixelate)
+	case redact.MethodFill:
+		v.Set("method", methodFill)
+	default:
+		v.Set("method", methodBlur)
+	}
+	v.Set("padding", formatFloat(rr.redact.Padding))
+	v.Set("strength", strconv.Itoa(rr.redact.Strength))
+	v.Set("fill_color", formatColor(rr.redact.FillColor))
+	return v
+}
+
+// faces returns the faces scored at least minScore.
+func (rr *redactRequest) faces(faces []facedetect.Face) []facedetect.Face {
+	var out []facedetect.Face
+	for _, f := range faces {
+		if f.Score >= rr.minScore {
+			out = append(out, f)
+		}
+	}
+	return out
+}
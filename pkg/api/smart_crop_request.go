@@ -0,0 +1,98 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+)
+
+// maxSmartCropSize is the largest target width and height of a smart crop, in pixels.
+const maxSmartCropSize = 4096
+
+// smartCropRequest holds the validated query parameters of a smart crop request.
+type smartCropRequest struct {
+	imageURL string
+	opts     facedetect.DetectOptions
+
+	// minScore ignores the faces detected with lower confidence.
+	minScore float32
+	// width and height are the target size, giving the aspect ratio of the crop.
+	width, height int
+	// format is formatJSON, or the format of the cropped image to respond with.
+	format string
+}
+
+// parseSmartCropRequest validates the query parameters and fills in the defaults for the omitted
+// ones. Returned errors are meant to be shown to the client.
+func parseSmartCropRequest(q url.Values) (*smartCropRequest, error) {
+	imageURL, err := parseImageURL(q)
+	if err != nil {
+		return nil, err
+	}
+
+	sr := &smartCropRequest{
+		imageURL: imageURL,
+		opts:     facedetect.DefaultDetectOptions(),
+		format:   formatJSON,
+	}
+	if err := parseDetectOptions(q, &sr.opts); err != nil {
+		return nil, err
+	}
+	// The crop is chosen on the first frame, as displayed.
+	if sr.opts.AllFrames {
+		return nil, fmt.Errorf("frames=all is not supported by smart crop")
+	}
+	if sr.opts.Frame == facedetect.FrameStored {
+		return nil, fmt.Errorf("frame=stored is not supported by smart crop")
+	}
+	if err := float32Param(q, "min_score", &sr.minScore); err != nil {
+		return nil, err
+	}
+
+	if q.Get("width") == "" || q.Get("height") == "" {
+		return nil, fmt.Errorf("width and height query parameters are required")
+	}
+	if err := intParam(q, "width", &sr.width); err != nil {
+		return nil, err
+	}
+	if err := intParam(q, "height", &sr.height); err != nil {
+		return nil, err
+	}
+	if sr.width < 1 || sr.width > maxSmartCropSize || sr.height < 1 || sr.height > maxSmartCropSize {
+		return nil, fmt.Errorf("width and height must be in [1, %d]", maxSmartCropSize)
+	}
+
+	switch f := q.Get("format"); f {
+	case "":
+	case formatJSON, formatPNG, formatJPEG:
+		sr.format = f
+	default:
+		return nil, fmt.Errorf("format must be one of json, png or jpeg")
+	}
+	return sr, nil
+}
+
+// values returns the canonical form of the request.
+func (sr *smartCropRequest) values() url.Values {
+	v := url.Values{}
+	v.Set("image_url", sr.imageURL)
+	detectOptionsValues(v, sr.opts)
+	v.Set("min_score", formatFloat(float64(sr.minScore)))
+	v.Set("width", strconv.Itoa(sr.width))
+	v.Set("height", strconv.Itoa(sr.height))
+	v.Set("format", sr.format)
+	return v
+}
+
+// faces returns the faces scored at least minScore.
+func (sr *smartCropRequest) faces(faces []facedetect.Face) []facedetect.Face {
+	var out []facedetect.Face
+	for _, f := range faces {
+		if f.Score >= sr.minScore {
+			out = append(out, f)
+		}
+	}
+	return out
+}
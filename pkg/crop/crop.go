@@ -0,0 +1,127 @@
+package crop
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"golang.org/x/image/draw"
+)
+
+// ErrOutsideImage is returned by Crop when the face region doesn't overlap the image.
+var ErrOutsideImage = fmt.Errorf("face outside of the image")
+
+// Mode selects the shape of the crops.
+type Mode int
+
+const (
+	// ModeSquare crops square regions centered on the faces, shifted to fit in the image.
+	ModeSquare Mode = iota
+	// ModeAspect crops the face bounds grown by the margin and clipped to the image, keeping
+	// their aspect ratio when resized.
+	ModeAspect
+)
+
+// Options control the cropping.
+type Options struct {
+	// Mode is the shape of the crops.
+	Mode Mode
+	// Margin grows the face bounds on every side by the given fraction of the face size.
+	Margin float64
+	// Size, when positive, resizes the crops to Size by Size pixels in ModeSquare, or to fit
+	// within Size by Size pixels in ModeAspect.
+	Size int
+}
+
+// DefaultOptions returns the options used unless told otherwise.
+func DefaultOptions() Options {
+	return Options{
+		Mode:   ModeSquare,
+		Margin: 0.2,
+	}
+}
+
+// Thumbnail is a face cropped out of an image. Region is the cropped area of the image.
+type Thumbnail struct {
+	image.Image
+
+	Region image.Rectangle
+}
+
+// Crop cuts the face with bounds b out of img, resizing it if requested. Face coordinates are
+// relative to the top left corner of img, and so is the returned Region.
+func Crop(img image.Image, b *facedetect.Bounds, opts Options) (*Thumbnail, error) {
+	ib := img.Bounds()
+	r := Region(b, image.Rect(0, 0, ib.Dx(), ib.Dy()), opts)
+	if r.Empty() {
+		return nil, ErrOutsideImage
+	}
+
+	w, h := r.Dx(), r.Dy()
+	if opts.Size > 0 {
+		w, h = opts.Size, opts.Size
+		if opts.Mode == ModeAspect {
+			w, h = fit(r.Dx(), r.Dy(), opts.Size)
+		}
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	src := r.Add(ib.Min)
+	if w == r.Dx() && h == r.Dy() {
+		draw.Draw(dst, dst.Rect, img, src.Min, draw.Src)
+	} else {
+		draw.CatmullRom.Scale(dst, dst.Rect, img, src, draw.Src, nil)
+	}
+	return &Thumbnail{Image: dst, Region: r}, nil
+}
+
+// Region returns the area of an image with bounds ib cropped for the face with bounds b.
+func Region(b *facedetect.Bounds, ib image.Rectangle, opts Options) image.Rectangle {
+	if opts.Mode == ModeAspect {
+		mx := int(math.Round(opts.Margin * float64(b.Width)))
+		my := int(math.Round(opts.Margin * float64(b.Height)))
+		return image.Rect(b.X-mx, b.Y-my, b.X+b.Width+mx, b.Y+b.Height+my).Intersect(ib)
+	}
+
+	side := b.Width
+	if b.Height > side {
+		side = b.Height
+	}
+	side = int(math.Round(float64(side) * (1 + 2*opts.Margin)))
+	if side > ib.Dx() {
+		side = ib.Dx()
+	}
+	if side > ib.Dy() {
+		side = ib.Dy()
+	}
+	x := b.X + b.Width/2 - side/2
+	y := b.Y + b.Height/2 - side/2
+	x = clamp(x, ib.Min.X, ib.Max.X-side)
+	y = clamp(y, ib.Min.Y, ib.Max.Y-side)
+	return image.Rect(x, y, x+side, y+side)
+}
+
+// fit returns the size of a w by h rectangle scaled for its longer side to be size.
+func fit(w, h, size int) (int, int) {
+	if w >= h {
+		return size, maxInt(1, int(math.Round(float64(h)*float64(size)/float64(w))))
+	}
+	return maxInt(1, int(math.Round(float64(w)*float64(size)/float64(h)))), size
+}
+
+func clamp(v, lo, hi int) int {
+	if v > hi {
+		v = hi
+	}
+	if v < lo {
+		v = lo
+	}
+	return v
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,119 @@
+package crop
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+)
+
+func TestRegion(t *testing.T) {
+	ib := image.Rect(0, 0, 200, 100)
+	tests := []struct {
+		name string
+		b    facedetect.Bounds
+		opts Options
+		want image.Rectangle
+	}{
+		{
+			name: "square",
+			b:    facedetect.Bounds{X: 50, Y: 30, Width: 40, Height: 40},
+			opts: Options{Mode: ModeSquare, Margin: 0.25},
+			want: image.Rect(40, 20, 100, 80),
+		},
+		{
+			name: "square shifted into the image",
+			b:    facedetect.Bounds{X: 0, Y: 60, Width: 40, Height: 40},
+			opts: Options{Mode: ModeSquare, Margin: 0.25},
+			want: image.Rect(0, 40, 60, 100),
+		},
+		{
+			name: "square shrunk to the image",
+			b:    facedetect.Bounds{X: 50, Y: 10, Width: 80, Height: 80},
+			opts: Options{Mode: ModeSquare, Margin: 0.5},
+			want: image.Rect(40, 0, 140, 100),
+		},
+		{
+			name: "square of a tall face",
+			b:    facedetect.Bounds{X: 50, Y: 30, Width: 20, Height: 40},
+			opts: Options{Mode: ModeSquare},
+			want: image.Rect(40, 30, 80, 70),
+		},
+		{
+			name: "aspect clipped",
+			b:    facedetect.Bounds{X: 180, Y: 30, Width: 40, Height: 20},
+			opts: Options{Mode: ModeAspect, Margin: 0.5},
+			want: image.Rect(160, 20, 200, 60),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Region(&tt.b, ib, tt.opts); got != tt.want {
+				t.Errorf("Region() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCrop(t *testing.T) {
+	// The left half of the image is black, the right half white.
+	img := image.NewGray(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 100; x < 200; x++ {
+			img.SetGray(x, y, color.Gray{Y: 0xff})
+		}
+	}
+	b := &facedetect.Bounds{X: 80, Y: 30, Width: 40, Height: 20}
+
+	tests := []struct {
+		name   string
+		opts   Options
+		bounds image.Rectangle
+	}{
+		{name: "square", opts: Options{Mode: ModeSquare}, bounds: image.Rect(0, 0, 40, 40)},
+		{name: "square resized", opts: Options{Mode: ModeSquare, Size: 20}, bounds: image.Rect(0, 0, 20, 20)},
+		{name: "aspect", opts: Options{Mode: ModeAspect}, bounds: image.Rect(0, 0, 40, 20)},
+		{name: "aspect resized", opts: Options{Mode: ModeAspect, Size: 100}, bounds: image.Rect(0, 0, 100, 50)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th, err := Crop(img, b, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if th.Bounds() != tt.bounds {
+				t.Fatalf("Crop() bounds = %v, want %v", th.Bounds(), tt.bounds)
+			}
+			w := th.Bounds().Dx()
+			if y := color.GrayModel.Convert(th.At(1, 1)).(color.Gray).Y; y != 0 {
+				t.Errorf("left side of the crop should be black, got %#x", y)
+			}
+			if y := color.GrayModel.Convert(th.At(w-2, 1)).(color.Gray).Y; y != 0xff {
+				t.Errorf("right side of the crop should be white, got %#x", y)
+			}
+		})
+	}
+}
+
+func TestCrop_Offset(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 100, 100))
+	img.SetGray(60, 60, color.Gray{Y: 0xff})
+	th, err := Crop(img.SubImage(image.Rect(50, 50, 100, 100)), &facedetect.Bounds{X: 10, Y: 10, Width: 10, Height: 10}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if th.Region != image.Rect(10, 10, 20, 20) {
+		t.Errorf("Crop() region = %v, want it relative to the top left corner of the image", th.Region)
+	}
+	if y := color.GrayModel.Convert(th.At(0, 0)).(color.Gray).Y; y != 0xff {
+		t.Error("crop should start at the face bounds")
+	}
+}
+
+func TestCrop_OutsideImage(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 100, 100))
+	if _, err := Crop(img, &facedetect.Bounds{X: 200, Y: 0, Width: 10, Height: 10}, Options{Mode: ModeAspect}); err != ErrOutsideImage {
+		t.Errorf("Crop() should return ErrOutsideImage, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package crop
+
+import (
+	"image"
+	"math"
+	"sort"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+)
+
+// Smart returns the largest area of an image with bounds ib having the aspect ratio of w by h,
+// placed to keep as many faces in frame as possible. Faces are taken by descending score for as
+// long as their facial features fit together. Without faces, the area is centered.
+//
+// The faces the area was placed around are returned with it, in their order in faces. When the
+// best scored face alone doesn't fit, it's the only one, centered in the area.
+func Smart(ib image.Rectangle, faces []facedetect.Face, w, h int) (image.Rectangle, []facedetect.Face) {
+	cw, ch := ib.Dx(), int(math.Round(float64(ib.Dx())*float64(h)/float64(w)))
+	if ch > ib.Dy() {
+		cw, ch = int(math.Round(float64(ib.Dy())*float64(w)/float64(h))), ib.Dy()
+	}
+	if cw < 1 {
+		cw = 1
+	}
+	if ch < 1 {
+		ch = 1
+	}
+
+	order := make([]int, len(faces))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return faces[order[i]].Score > faces[order[j]].Score
+	})
+	var keep image.Rectangle
+	kept := make([]bool, len(faces))
+	for _, i := range order {
+		e := extent(faces[i]).Intersect(ib)
+		if e.Empty() {
+			continue
+		}
+		u := keep.Union(e)
+		if u.Dx() > cw || u.Dy() > ch {
+			if keep.Empty() {
+				// The best face alone doesn't fit, frame its center.
+				keep = image.Rectangle{Min: center(e), Max: center(e).Add(image.Pt(1, 1))}
+				kept[i] = true
+			}
+			continue
+		}
+		keep = u
+		kept[i] = true
+	}
+	var placed []facedetect.Face
+	for i, f := range faces {
+		if kept[i] {
+			placed = append(placed, f)
+		}
+	}
+
+	c := center(ib)
+	if !keep.Empty() {
+		c = center(keep)
+	}
+	x := clamp(c.X-cw/2, ib.Min.X, ib.Max.X-cw)
+	y := clamp(c.Y-ch/2, ib.Min.Y, ib.Max.Y-ch)
+	return image.Rect(x, y, x+cw, y+ch), placed
+}
+
+// extent returns the rectangle covering the face bounds and all of its facial features.
+func extent(f facedetect.Face) image.Rectangle {
+	var r image.Rectangle
+	if f.Bounds != nil {
+		r = image.Rect(f.Bounds.X, f.Bounds.Y, f.Bounds.X+f.Bounds.Width, f.Bounds.Y+f.Bounds.Height)
+	}
+	points := []*facedetect.Point{f.LeftEye, f.RightEye, f.Mouth}
+	for _, p := range f.Landmarks {
+		points = append(points, p)
+	}
+	for _, p := range points {
+		if p != nil {
+			r = r.Union(image.Rect(p.X, p.Y, p.X+1, p.Y+1))
+		}
+	}
+	return r
+}
+
+func center(r image.Rectangle) image.Point {
+	return image.Pt((r.Min.X+r.Max.X)/2, (r.Min.Y+r.Max.Y)/2)
+}
@@ -0,0 +1,70 @@
+package crop
+
+import (
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+)
+
+func TestSmart(t *testing.T) {
+	ib := image.Rect(0, 0, 400, 200)
+	face := func(x, y, size int, score float32) facedetect.Face {
+		return facedetect.Face{Bounds: &facedetect.Bounds{X: x, Y: y, Width: size, Height: size}, Score: score}
+	}
+
+	tests := []struct {
+		name  string
+		faces []facedetect.Face
+		w, h  int
+		want  image.Rectangle
+		kept  []int // Indexes of the faces the crop is placed around.
+	}{
+		{name: "no faces", w: 1, h: 1, want: image.Rect(100, 0, 300, 200)},
+		{name: "wider target", w: 4, h: 1, want: image.Rect(0, 50, 400, 150)},
+		{name: "single face", faces: []facedetect.Face{face(20, 50, 40, 10)}, w: 1, h: 1, want: image.Rect(0, 0, 200, 200), kept: []int{0}},
+		{name: "face at the right edge", faces: []facedetect.Face{face(300, 50, 40, 10)}, w: 1, h: 1, want: image.Rect(200, 0, 400, 200), kept: []int{0}},
+		{name: "face out of the image", faces: []facedetect.Face{face(500, 50, 40, 10)}, w: 1, h: 1, want: image.Rect(100, 0, 300, 200)},
+		{
+			name:  "faces fitting together",
+			faces: []facedetect.Face{face(100, 50, 40, 10), face(220, 50, 40, 20)},
+			w:     1, h: 1,
+			want: image.Rect(80, 0, 280, 200),
+			kept: []int{0, 1},
+		},
+		{
+			name:  "best scored faces are kept",
+			faces: []facedetect.Face{face(10, 50, 40, 30), face(350, 50, 40, 10), face(100, 50, 40, 20)},
+			w:     1, h: 1,
+			want: image.Rect(0, 0, 200, 200),
+			kept: []int{0, 2},
+		},
+		{
+			name: "landmarks are kept in frame",
+			faces: []facedetect.Face{{
+				Bounds:    &facedetect.Bounds{X: 100, Y: 20, Width: 40, Height: 40},
+				Landmarks: map[string]*facedetect.Point{"chin": {X: 120, Y: 110}},
+			}},
+			w: 4, h: 1,
+			want: image.Rect(0, 15, 400, 115),
+			kept: []int{0},
+		},
+		{name: "face larger than the crop", faces: []facedetect.Face{face(100, 0, 200, 10)}, w: 4, h: 1, want: image.Rect(0, 50, 400, 150), kept: []int{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, kept := Smart(ib, tt.faces, tt.w, tt.h)
+			if got != tt.want {
+				t.Errorf("Smart() = %v, want %v", got, tt.want)
+			}
+			var want []facedetect.Face
+			for _, i := range tt.kept {
+				want = append(want, tt.faces[i])
+			}
+			if !reflect.DeepEqual(kept, want) {
+				t.Errorf("Smart() kept %v, want %v", kept, want)
+			}
+		})
+	}
+}
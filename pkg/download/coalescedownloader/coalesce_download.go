@@ -0,0 +1,150 @@
+package coalescedownloader
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bokan/facedetection/pkg/download"
+)
+
+// CoalesceDownloader shares a single download of the wrapped Downloader among the concurrent
+// calls for the same URL. The file is buffered in memory and every caller reads its own copy.
+//
+// The shared download doesn't depend on the context of the call that started it: it goes on as
+// long as any caller waits for it, and is cancelled once all of them gave up or at the latest of
+// their deadlines, whichever comes first. A caller without deadline lifts the latter.
+type CoalesceDownloader struct {
+	d           download.Downloader
+	maxFileSize int64
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// call is a download shared by the callers waiting for it.
+type call struct {
+	done     chan struct{} // Closed once the download ended, data or err being set.
+	data     []byte
+	finalURL string
+	err      error
+
+	// Guarded by CoalesceDownloader.mu.
+	waiters   int
+	deadline  time.Time   // Latest deadline of the waiters.
+	unbounded bool        // Set once a waiter without deadline joined.
+	timer     *time.Timer // Cancels the download at the deadline.
+
+	cancel context.CancelFunc
+}
+
+// NewCoalesceDownloader wraps d, buffering files up to maxFileSize. Bigger files fail with
+// download.ErrFileIsTooBig.
+func NewCoalesceDownloader(d download.Downloader, maxFileSize int64) *CoalesceDownloader {
+	return &CoalesceDownloader{d: d, maxFileSize: maxFileSize, calls: map[string]*call{}}
+}
+
+// Download returns the file at url, joining the download already running for it, if any. The
+// returned body implements download.Located.
+func (c *CoalesceDownloader) Download(ctx context.Context, url string) (io.ReadCloser, error) {
+	c.mu.Lock()
+	cl, ok := c.calls[url]
+	if !ok {
+		var fetchCtx context.Context
+		cl = &call{done: make(chan struct{})}
+		fetchCtx, cl.cancel = context.WithCancel(context.Background())
+		c.calls[url] = cl
+		go c.fetch(fetchCtx, url, cl)
+	}
+	cl.waiters++
+	c.extend(ctx, url, cl)
+	c.mu.Unlock()
+
+	select {
+	case <-cl.done:
+	case <-ctx.Done():
+		c.leave(url, cl)
+		return nil, ctx.Err()
+	}
+
+	if cl.err != nil {
+		if ctx.Err() != nil {
+			// The download expired with the deadline of ctx.
+			return nil, ctx.Err()
+		}
+		return nil, cl.err
+	}
+	return download.NewFile(cl.data, cl.finalURL), nil
+}
+
+// fetch runs the download shared by the callers of cl.
+func (c *CoalesceDownloader) fetch(ctx context.Context, url string, cl *call) {
+	defer cl.cancel()
+	cl.data, cl.finalURL, cl.err = download.ReadFile(ctx, c.d, url, c.maxFileSize)
+
+	c.mu.Lock()
+	if cl.timer != nil {
+		cl.timer.Stop()
+	}
+	if c.calls[url] == cl {
+		delete(c.calls, url)
+	}
+	c.mu.Unlock()
+	close(cl.done)
+}
+
+// extend makes the download of cl last until the deadline of ctx, when it's later than the ones of
+// the other callers. It's called with c.mu held.
+func (c *CoalesceDownloader) extend(ctx context.Context, url string, cl *call) {
+	if cl.unbounded {
+		return
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		cl.unbounded = true
+		if cl.timer != nil {
+			cl.timer.Stop()
+		}
+		return
+	}
+	if !deadline.After(cl.deadline) {
+		return
+	}
+	cl.deadline = deadline
+	if cl.timer == nil {
+		cl.timer = time.AfterFunc(time.Until(deadline), func() { c.expire(url, cl) })
+	} else {
+		cl.timer.Reset(time.Until(deadline))
+	}
+}
+
+// expire cancels the download of cl once the deadlines of its callers passed. Later calls start a
+// new download.
+func (c *CoalesceDownloader) expire(url string, cl *call) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// The timer may fire while a caller extends or lifts the deadline.
+	if cl.unbounded || time.Now().Before(cl.deadline) {
+		return
+	}
+	cl.cancel()
+	if c.calls[url] == cl {
+		delete(c.calls, url)
+	}
+}
+
+// leave removes a caller that gave up waiting for cl, cancelling the download once nobody waits
+// for it anymore. Later calls start a new download.
+func (c *CoalesceDownloader) leave(url string, cl *call) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cl.waiters--
+	if cl.waiters > 0 {
+		return
+	}
+	cl.cancel()
+	if c.calls[url] == cl {
+		delete(c.calls, url)
+	}
+}
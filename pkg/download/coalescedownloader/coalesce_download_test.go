@@ -0,0 +1,276 @@
+package coalescedownloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bokan/facedetection/pkg/download"
+)
+
+// blockingDownloader serves its file once released, counting the downloads.
+type blockingDownloader struct {
+	file    string
+	err     error
+	release chan struct{}
+
+	mu        sync.Mutex
+	downloads int
+	cancelled chan struct{} // Closed when the context of a download ends before the release.
+}
+
+func newBlockingDownloader(file string, err error) *blockingDownloader {
+	return &blockingDownloader{file: file, err: err, release: make(chan struct{}), cancelled: make(chan struct{})}
+}
+
+func (b *blockingDownloader) Download(ctx context.Context, url string) (io.ReadCloser, error) {
+	b.mu.Lock()
+	b.downloads++
+	b.mu.Unlock()
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+		close(b.cancelled)
+		return nil, ctx.Err()
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	return ioutil.NopCloser(strings.NewReader(b.file)), nil
+}
+
+func (b *blockingDownloader) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.downloads
+}
+
+// waitWaiters waits until n callers wait for the download of url.
+func waitWaiters(t *testing.T, c *CoalesceDownloader, url string, n int) {
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		cl := c.calls[url]
+		waiting := cl != nil && cl.waiters == n
+		c.mu.Unlock()
+		if waiting {
+			return
+		}
+	}
+	t.Fatalf("%d callers should wait for %s", n, url)
+}
+
+func TestCoalesceDownloader_Download(t *testing.T) {
+	d := newBlockingDownloader("image", nil)
+	c := NewCoalesceDownloader(d, 1024)
+
+	const callers = 10
+	var wg sync.WaitGroup
+	got := make([]string, callers)
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body, err := c.Download(context.Background(), "http://foo/")
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			b, _ := ioutil.ReadAll(body)
+			got[i] = string(b)
+			if u := download.FinalURL(body, ""); u != "http://foo/" {
+				errs[i] = fmt.Errorf("FinalURL() = %q", u)
+			}
+			_ = body.Close()
+		}(i)
+	}
+	waitWaiters(t, c, "http://foo/", callers)
+	close(d.release)
+	wg.Wait()
+
+	for i := range got {
+		if errs[i] != nil || got[i] != "image" {
+			t.Errorf("caller %d got %q, error = %v", i, got[i], errs[i])
+		}
+	}
+	if n := d.count(); n != 1 {
+		t.Errorf("concurrent callers should share one download, got %d", n)
+	}
+
+	// Later calls download the file again.
+	body, err := c.Download(context.Background(), "http://foo/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = body.Close()
+	if n := d.count(); n != 2 {
+		t.Errorf("a call after the shared download ended should download again, got %d downloads", n)
+	}
+}
+
+func TestCoalesceDownloader_Download_Errors(t *testing.T) {
+	failure := fmt.Errorf("failure")
+	d := newBlockingDownloader("", failure)
+	close(d.release)
+	if _, err := NewCoalesceDownloader(d, 1024).Download(context.Background(), "http://foo/"); err != failure {
+		t.Errorf("Download() error = %v, want %v", err, failure)
+	}
+
+	d = newBlockingDownloader("image", nil)
+	close(d.release)
+	if _, err := NewCoalesceDownloader(d, 4).Download(context.Background(), "http://foo/"); err != download.ErrFileIsTooBig {
+		t.Errorf("Download() error = %v, want %v", err, download.ErrFileIsTooBig)
+	}
+}
+
+func TestCoalesceDownloader_Download_LeaderCancelled(t *testing.T) {
+	d := newBlockingDownloader("image", nil)
+	c := NewCoalesceDownloader(d, 1024)
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := c.Download(leaderCtx, "http://foo/")
+		leaderErr <- err
+	}()
+	waitWaiters(t, c, "http://foo/", 1)
+
+	type result struct {
+		body io.ReadCloser
+		err  error
+	}
+	follower := make(chan result, 1)
+	go func() {
+		body, err := c.Download(context.Background(), "http://foo/")
+		follower <- result{body, err}
+	}()
+	waitWaiters(t, c, "http://foo/", 2)
+
+	cancelLeader()
+	if err := <-leaderErr; err != context.Canceled {
+		t.Errorf("leader Download() error = %v, want %v", err, context.Canceled)
+	}
+	close(d.release)
+	r := <-follower
+	if r.err != nil {
+		t.Fatalf("follower Download() error = %v, the leader giving up should not fail it", r.err)
+	}
+	_ = r.body.Close()
+	select {
+	case <-d.cancelled:
+		t.Error("the shared download should not be cancelled while a caller waits for it")
+	default:
+	}
+}
+
+func TestCoalesceDownloader_Download_AllCancelled(t *testing.T) {
+	d := newBlockingDownloader("image", nil)
+	c := NewCoalesceDownloader(d, 1024)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = c.Download(ctx, "http://foo/")
+		}()
+	}
+	waitWaiters(t, c, "http://foo/", 2)
+	cancel()
+	wg.Wait()
+
+	select {
+	case <-d.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the shared download should be cancelled once every caller gave up")
+	}
+
+	// A new call starts a new download rather than joining the cancelled one.
+	close(d.release)
+	body, err := c.Download(context.Background(), "http://foo/")
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	_ = body.Close()
+}
+
+func TestCoalesceDownloader_Download_Deadline(t *testing.T) {
+	d := newBlockingDownloader("image", nil)
+	c := NewCoalesceDownloader(d, 1024)
+
+	first, cancelFirst := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelFirst()
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := c.Download(first, "http://foo/")
+		firstErr <- err
+	}()
+	waitWaiters(t, c, "http://foo/", 1)
+
+	second, cancelSecond := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancelSecond()
+	secondErr := make(chan error, 1)
+	go func() {
+		body, err := c.Download(second, "http://foo/")
+		if err == nil {
+			_ = body.Close()
+		}
+		secondErr <- err
+	}()
+	waitWaiters(t, c, "http://foo/", 2)
+
+	// The server is still slow past the deadline of the first caller.
+	if err := <-firstErr; err != context.DeadlineExceeded {
+		t.Errorf("first Download() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-d.cancelled:
+		t.Fatal("the shared download should last until the latest deadline of its callers")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(d.release)
+	if err := <-secondErr; err != nil {
+		t.Errorf("second Download() error = %v, the download should end within its deadline", err)
+	}
+}
+
+func TestCoalesceDownloader_Download_NoDeadline(t *testing.T) {
+	d := newBlockingDownloader("image", nil)
+	c := NewCoalesceDownloader(d, 1024)
+
+	first, cancelFirst := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelFirst()
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := c.Download(first, "http://foo/")
+		firstErr <- err
+	}()
+	waitWaiters(t, c, "http://foo/", 1)
+
+	secondErr := make(chan error, 1)
+	go func() {
+		body, err := c.Download(context.Background(), "http://foo/")
+		if err == nil {
+			_ = body.Close()
+		}
+		secondErr <- err
+	}()
+	waitWaiters(t, c, "http://foo/", 2)
+
+	<-firstErr
+	select {
+	case <-d.cancelled:
+		t.Fatal("a caller without deadline should lift the deadline of the shared download")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(d.release)
+	if err := <-secondErr; err != nil {
+		t.Errorf("second Download() error = %v", err)
+	}
+}
@@ -1,9 +1,12 @@
 package download
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"time"
 )
 
 var (
@@ -12,8 +15,99 @@ var (
 
 	// ErrFileIsTooBig is returned by Downloader.Download calls when requested file size is too big.
 	ErrFileIsTooBig = fmt.Errorf("file is too big")
+
+	// ErrNotAnImage is returned by Downloader.Download calls when the file isn't an image of a
+	// supported format.
+	ErrNotAnImage = fmt.Errorf("file is not an image")
+
+	// ErrForbiddenAddress is returned, possibly wrapped, by Downloader.Download calls when the
+	// server address is one the downloader mustn't connect to, e.g. on the internal network.
+	ErrForbiddenAddress = fmt.Errorf("forbidden server address")
+
+	// ErrTooManyRedirects is returned, possibly wrapped, by Downloader.Download calls when the
+	// server redirects more times than the downloader follows.
+	ErrTooManyRedirects = fmt.Errorf("too many redirects")
+
+	// ErrForbiddenRedirect is returned, possibly wrapped, by Downloader.Download calls when the
+	// server redirects to a URL the downloader mustn't follow, e.g. from https to http.
+	ErrForbiddenRedirect = fmt.Errorf("forbidden redirect")
 )
 
+// StatusError is returned by Downloader.Download calls when the server responds with a status
+// code different than 200. It matches ErrNon200StatusCode with errors.Is.
+//
+// RetryAfter is the delay the server asked to wait before trying again with the Retry-After
+// header, zero when it didn't.
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%v: %d", ErrNon200StatusCode, e.StatusCode)
+}
+
+// Unwrap returns ErrNon200StatusCode.
+func (e *StatusError) Unwrap() error {
+	return ErrNon200StatusCode
+}
+
+// Located is implemented by the bodies returned by Downloaders following redirects. FinalURL is
+// the URL the body was downloaded from, after the redirects.
+type Located interface {
+	FinalURL() string
+}
+
+// FinalURL returns the URL body was downloaded from, or url, the one it was requested with, when
+// body doesn't tell.
+func FinalURL(body io.Reader, url string) string {
+	if l, ok := body.(Located); ok {
+		return l.FinalURL()
+	}
+	return url
+}
+
+// ReadFile downloads the file at url with d and reads it whole, failing with ErrFileIsTooBig when
+// it's bigger than maxFileSize. The URL the file was downloaded from is returned with it.
+func ReadFile(ctx context.Context, d Downloader, url string, maxFileSize int64) ([]byte, string, error) {
+	rc, err := d.Download(ctx, url)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	data, err := ioutil.ReadAll(io.LimitReader(rc, maxFileSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > maxFileSize {
+		return nil, "", ErrFileIsTooBig
+	}
+	return data, FinalURL(rc, url), nil
+}
+
+// File is a downloaded file held in memory. It implements Located.
+type File struct {
+	*bytes.Reader
+	url string
+}
+
+// NewFile returns the file holding data, downloaded from finalURL.
+func NewFile(data []byte, finalURL string) *File {
+	return &File{Reader: bytes.NewReader(data), url: finalURL}
+}
+
+// Close does nothing, the file being in memory.
+func (f *File) Close() error {
+	return nil
+}
+
+// FinalURL returns the URL the file was downloaded from.
+func (f *File) FinalURL() string {
+	return f.url
+}
+
 // Downloader initiates a download of a resource specified by url parameter.
 type Downloader interface {
 	Download(ctx context.Context, url string) (io.ReadCloser, error)
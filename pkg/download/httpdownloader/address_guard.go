@@ -0,0 +1,124 @@
+package httpdownloader
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/bokan/facedetection/pkg/download"
+)
+
+// forbiddenNetworks are the networks that aren't publicly routable: loopback, private, link-local,
+// multicast, carrier-grade NAT and reserved ranges, in both IPv4 and IPv6.
+var forbiddenNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"100::/64",
+	"2001:db8::/32",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// AddressGuard rejects the connections to addresses that aren't publicly routable, unless they're
+// in an allowed network. Addresses are checked once resolved, right before connecting, so a host
+// name resolving to another address than the one checked can't get through.
+type AddressGuard struct {
+	allowed []*net.IPNet
+}
+
+// NewAddressGuard returns an AddressGuard letting through the networks given in CIDR notation,
+// e.g. "10.1.0.0/16", on top of the publicly routable ones.
+func NewAddressGuard(allowed ...string) (*AddressGuard, error) {
+	nets, err := parseCIDRs(allowed)
+	if err != nil {
+		return nil, err
+	}
+	return &AddressGuard{allowed: nets}, nil
+}
+
+// Allowed tells whether connecting to ip is allowed.
+func (g *AddressGuard) Allowed(ip net.IP) bool {
+	for _, n := range g.allowed {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	for _, n := range forbiddenNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Control checks the address a net.Dialer is about to connect to, failing with
+// download.ErrForbiddenAddress when it isn't allowed. It's meant for net.Dialer.Control.
+func (g *AddressGuard) Control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !g.Allowed(ip) {
+		return fmt.Errorf("%w: %s", download.ErrForbiddenAddress, host)
+	}
+	return nil
+}
+
+// NewClient returns an http.Client whose connections are checked by guard. It doesn't use the
+// proxy of the environment, whose address would be checked instead of the server's.
+func NewClient(guard *AddressGuard) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   guard.Control,
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+}
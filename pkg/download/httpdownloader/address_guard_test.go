@@ -0,0 +1,88 @@
+package httpdownloader
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bokan/facedetection/pkg/download"
+)
+
+func TestAddressGuard_Allowed(t *testing.T) {
+	g, err := NewAddressGuard("10.1.0.0/16", "fd00::/64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "93.184.216.34", want: true},
+		{ip: "2606:2800:220:1::1", want: true},
+		{ip: "127.0.0.1", want: false},
+		{ip: "::ffff:127.0.0.1", want: false},
+		{ip: "169.254.169.254", want: false},
+		{ip: "10.0.0.1", want: false},
+		{ip: "172.16.5.4", want: false},
+		{ip: "192.168.1.1", want: false},
+		{ip: "100.64.0.1", want: false},
+		{ip: "0.0.0.0", want: false},
+		{ip: "224.0.0.1", want: false},
+		{ip: "255.255.255.255", want: false},
+		{ip: "::1", want: false},
+		{ip: "::", want: false},
+		{ip: "fe80::1", want: false},
+		{ip: "fc00::1", want: false},
+		{ip: "ff02::1", want: false},
+		{ip: "10.1.2.3", want: true},
+		{ip: "fd00::1", want: true},
+	}
+	for _, tt := range tests {
+		if got := g.Allowed(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("Allowed(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	if _, err := NewAddressGuard("10.1.0.0"); err == nil {
+		t.Error("NewAddressGuard() should fail for an invalid CIDR")
+	}
+}
+
+func TestAddressGuard_Control(t *testing.T) {
+	g, _ := NewAddressGuard()
+	if err := g.Control("tcp4", "8.8.8.8:443", nil); err != nil {
+		t.Errorf("Control() error = %v", err)
+	}
+	if err := g.Control("tcp6", "[::1]:80", nil); !errors.Is(err, download.ErrForbiddenAddress) {
+		t.Errorf("Control() error = %v, want %v", err, download.ErrForbiddenAddress)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(pngSignature))
+	}))
+	defer srv.Close()
+
+	g, _ := NewAddressGuard()
+	d := NewHTTPDownloader(NewClient(g), time.Second*5, 1024)
+	// Host names are checked once resolved.
+	for _, url := range []string{srv.URL, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)} {
+		if _, err := d.Download(context.Background(), url); !errors.Is(err, download.ErrForbiddenAddress) {
+			t.Errorf("Download(%s) error = %v, want %v", url, err, download.ErrForbiddenAddress)
+		}
+	}
+
+	g, _ = NewAddressGuard("127.0.0.0/8", "::1/128")
+	d = NewHTTPDownloader(NewClient(g), time.Second*5, 1024)
+	body, err := d.Download(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	_ = body.Close()
+}
@@ -0,0 +1,85 @@
+package httpdownloader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/bokan/facedetection/pkg/download"
+)
+
+// sniffLen is the number of bytes needed to recognize the image formats.
+const sniffLen = 12
+
+// imageSignatures are the leading bytes of the image formats that can be downloaded, WebP being
+// told apart by isImage.
+var imageSignatures = []string{
+	"\xFF\xD8\xFF",      // JPEG
+	"\x89PNG\r\n\x1A\n", // PNG
+	"GIF87a",            // GIF
+	"GIF89a",            // GIF
+	"BM",                // BMP
+	"II*\x00",           // Little-endian TIFF
+	"MM\x00*",           // Big-endian TIFF
+}
+
+// isImage tells whether a body starting with head and served with contentType is an image of
+// one of the supported formats. Bodies served as generic binary data are judged on head alone.
+func isImage(contentType string, head []byte) bool {
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return false
+		}
+		switch {
+		case strings.HasPrefix(mediaType, "image/"):
+		case mediaType == "application/octet-stream", mediaType == "binary/octet-stream":
+		default:
+			return false
+		}
+	}
+	for _, sig := range imageSignatures {
+		if bytes.HasPrefix(head, []byte(sig)) {
+			return true
+		}
+	}
+	return len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP"
+}
+
+// body is a response body failing with download.ErrFileIsTooBig once more than its limit is
+// read, and releasing the request context once closed.
+type body struct {
+	r      io.Reader
+	c      io.Closer
+	left   int64 // Bytes left before exceeding the limit.
+	cancel context.CancelFunc
+	url    string
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.left < 0 {
+		return 0, download.ErrFileIsTooBig
+	}
+	// Reading a byte past the limit tells bodies ending at the limit apart from the ones exceeding it.
+	if int64(len(p)) > b.left+1 {
+		p = p[:b.left+1]
+	}
+	n, err := b.r.Read(p)
+	b.left -= int64(n)
+	if b.left < 0 {
+		return n - 1, download.ErrFileIsTooBig
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	err := b.c.Close()
+	b.cancel()
+	return err
+}
+
+func (b *body) FinalURL() string {
+	return b.url
+}
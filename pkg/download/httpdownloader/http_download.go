@@ -1,20 +1,30 @@
 package httpdownloader
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/bokan/facedetection/pkg/download"
 )
 
+// DefaultMaxRedirects is the number of redirects an HTTPDownloader follows unless told otherwise.
+const DefaultMaxRedirects = 5
+
 // HTTPDownloader downloads a file from an HTTP server.
+//
+// Redirects are followed up to a maximum number of hops, each of them to an http or https URL with
+// a host. Redirects from https to http are refused. The addresses the client connects to are
+// checked on every hop by the client itself, see NewClient.
 type HTTPDownloader struct {
 	client        *http.Client
 	clientTimeOut time.Duration
 	maxFileSize   int64
+	maxRedirects  int
 }
 
 // NewHTTPDownloader instantiates a new HTTPDownloader.
@@ -22,32 +32,103 @@ type HTTPDownloader struct {
 // Requests will be time bound by clientTimeOut.
 // Files bigger than maxFileSize will be rejected.
 func NewHTTPDownloader(client *http.Client, clientTimeOut time.Duration, maxFileSize int64) *HTTPDownloader {
-	return &HTTPDownloader{clientTimeOut: clientTimeOut, maxFileSize: maxFileSize, client: client}
+	d := &HTTPDownloader{clientTimeOut: clientTimeOut, maxFileSize: maxFileSize, maxRedirects: DefaultMaxRedirects}
+	// The client is copied so that its redirect policy can be replaced without affecting others.
+	c := *client
+	c.CheckRedirect = d.checkRedirect
+	d.client = &c
+	return d
 }
 
-// Download initiates a time constrained HTTP GET request, validates Content-Length and returns response body io.ReadCloser.
-func (d *HTTPDownloader) Download(ctx context.Context, url string) (io.ReadCloser, error) {
+// SetMaxRedirects sets the number of redirects followed, zero refusing any. It must be called
+// before the first download.
+func (d *HTTPDownloader) SetMaxRedirects(n int) {
+	d.maxRedirects = n
+}
 
-	// TODO: Redirects?
+// checkRedirect is the http.Client redirect policy, req being the next hop and via the previous ones.
+func (d *HTTPDownloader) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > d.maxRedirects {
+		return download.ErrTooManyRedirects
+	}
+	u := req.URL
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%w: unsupported URL %s", download.ErrForbiddenRedirect, u.Redacted())
+	}
+	if via[len(via)-1].URL.Scheme == "https" && u.Scheme == "http" {
+		return fmt.Errorf("%w: https downgraded to http", download.ErrForbiddenRedirect)
+	}
+	return nil
+}
 
+// Download initiates a time constrained HTTP GET request and returns the response body, once the
+// status, the size announced by Content-Length and the leading bytes of an image are validated.
+//
+// Reading the body fails with download.ErrFileIsTooBig past the maximum file size, whatever the
+// server announced. Closing it ends the request. The body implements download.Located.
+func (d *HTTPDownloader) Download(ctx context.Context, url string) (io.ReadCloser, error) {
 	ctx, cancel := context.WithTimeout(ctx, d.clientTimeOut)
-	_ = cancel
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 	resp, err := d.client.Do(req)
 	if err != nil {
+		cancel()
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
+	fail := func(err error) (io.ReadCloser, error) {
+		_ = resp.Body.Close()
+		cancel()
+		return nil, err
+	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, download.ErrNon200StatusCode
+		return fail(&download.StatusError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: retryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		})
 	}
 	if resp.ContentLength > d.maxFileSize {
-		return nil, download.ErrFileIsTooBig
+		return fail(download.ErrFileIsTooBig)
+	}
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(resp.Body, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return fail(fmt.Errorf("request failed: %w", err))
+	}
+	head = head[:n]
+	if !isImage(resp.Header.Get("Content-Type"), head) {
+		return fail(download.ErrNotAnImage)
 	}
 
-	return resp.Body, nil
+	return &body{
+		r:      io.MultiReader(bytes.NewReader(head), resp.Body),
+		c:      resp.Body,
+		left:   d.maxFileSize,
+		cancel: cancel,
+		url:    resp.Request.URL.String(),
+	}, nil
+}
+
+// retryAfter parses the value of a Retry-After header, either a number of seconds or a date,
+// returning zero when it's missing, invalid or in the past.
+func retryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	t, err := http.ParseTime(v)
+	if err != nil || t.Before(now) {
+		return 0
+	}
+	return t.Sub(now)
 }
@@ -2,15 +2,23 @@ package httpdownloader
 
 import (
 	"context"
-	"io"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/bokan/facedetection/pkg/download"
 )
 
+// pngSignature starts the bodies served as images.
+const pngSignature = "\x89PNG\r\n\x1A\n"
+
 func TestHTTPDownloader_Download1024ByteFile(t *testing.T) {
 	d := NewHTTPDownloader(http.DefaultClient, time.Second*5, 4*1024*1024)
 	ctx := context.Background()
@@ -18,6 +26,7 @@ func TestHTTPDownloader_Download1024ByteFile(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		w.WriteHeader(200)
 		buf := make([]byte, 1024)
+		copy(buf, pngSignature)
 		_, _ = w.Write(buf)
 	}))
 
@@ -26,16 +35,13 @@ func TestHTTPDownloader_Download1024ByteFile(t *testing.T) {
 		t.Errorf("Download() error = %v", err)
 		return
 	}
-	buf := make([]byte, 1024)
-	n, err := got.Read(buf)
+	buf, err := ioutil.ReadAll(got)
 	if err != nil {
-		if err != io.EOF {
-			t.Errorf("%v", err)
-			return
-		}
+		t.Errorf("%v", err)
+		return
 	}
-	if n != 1024 {
-		t.Errorf("expected 1024 bytes, got %d", n)
+	if len(buf) != 1024 {
+		t.Errorf("expected 1024 bytes, got %d", len(buf))
 		return
 	}
 }
@@ -71,10 +77,14 @@ func TestHTTPDownloader_Non200StatusCode(t *testing.T) {
 	}))
 
 	_, err := d.Download(ctx, srv.URL)
-	if err != download.ErrNon200StatusCode {
+	if !errors.Is(err, download.ErrNon200StatusCode) {
 		t.Errorf("status code 404 should cause an error")
 		return
 	}
+	var se *download.StatusError
+	if !errors.As(err, &se) || se.StatusCode != 404 {
+		t.Errorf("error should tell the status code, got: %v", err)
+	}
 }
 
 func TestHTTPDownloader_FileTooBig(t *testing.T) {
@@ -94,3 +104,192 @@ func TestHTTPDownloader_FileTooBig(t *testing.T) {
 }
 
 // TODO: Test timeouts
+
+func TestHTTPDownloader_Redirects(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/image", func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(pngSignature))
+	})
+	mux.HandleFunc("/hop/", func(w http.ResponseWriter, req *http.Request) {
+		n, _ := strconv.Atoi(strings.TrimPrefix(req.URL.Path, "/hop/"))
+		if n == 0 {
+			http.Redirect(w, req, "/image", http.StatusFound)
+			return
+		}
+		http.Redirect(w, req, fmt.Sprintf("/hop/%d", n-1), http.StatusFound)
+	})
+	mux.HandleFunc("/ftp", func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, "ftp://example.com/image", http.StatusFound)
+	})
+
+	d := NewHTTPDownloader(http.DefaultClient, time.Second*5, 1024)
+	d.SetMaxRedirects(3)
+	body, err := d.Download(context.Background(), srv.URL+"/hop/2")
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	_ = body.Close()
+	if got := download.FinalURL(body, ""); got != srv.URL+"/image" {
+		t.Errorf("FinalURL() = %s, want %s", got, srv.URL+"/image")
+	}
+
+	if _, err := d.Download(context.Background(), srv.URL+"/hop/3"); !errors.Is(err, download.ErrTooManyRedirects) {
+		t.Errorf("Download() error = %v, want %v", err, download.ErrTooManyRedirects)
+	}
+	if _, err := d.Download(context.Background(), srv.URL+"/ftp"); !errors.Is(err, download.ErrForbiddenRedirect) {
+		t.Errorf("Download() error = %v, want %v", err, download.ErrForbiddenRedirect)
+	}
+	if http.DefaultClient.CheckRedirect != nil {
+		t.Error("NewHTTPDownloader() should not change the redirect policy of the given client")
+	}
+}
+
+func TestHTTPDownloader_RedirectDowngrade(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(pngSignature))
+	}))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, plain.URL, http.StatusFound)
+	}))
+	defer secure.Close()
+
+	d := NewHTTPDownloader(secure.Client(), time.Second*5, 1024)
+	if _, err := d.Download(context.Background(), secure.URL); !errors.Is(err, download.ErrForbiddenRedirect) {
+		t.Errorf("Download() error = %v, want %v", err, download.ErrForbiddenRedirect)
+	}
+}
+
+func TestHTTPDownloader_FileTooBigWhileStreaming(t *testing.T) {
+	d := NewHTTPDownloader(http.DefaultClient, time.Second*5, 512)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		size, _ := strconv.Atoi(req.URL.Query().Get("size"))
+		buf := make([]byte, size)
+		copy(buf, pngSignature)
+		// Flushing before writing the body makes it chunked, without Content-Length.
+		w.(http.Flusher).Flush()
+		_, _ = w.Write(buf)
+	}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		size    int
+		wantErr error
+	}{
+		{size: 512},
+		{size: 513, wantErr: download.ErrFileIsTooBig},
+		{size: 4096, wantErr: download.ErrFileIsTooBig},
+	} {
+		body, err := d.Download(context.Background(), fmt.Sprintf("%s?size=%d", srv.URL, tt.size))
+		if err != nil {
+			t.Fatalf("Download() error = %v", err)
+		}
+		got, err := ioutil.ReadAll(body)
+		_ = body.Close()
+		if err != tt.wantErr {
+			t.Errorf("reading %d bytes error = %v, want %v", tt.size, err, tt.wantErr)
+		}
+		if len(got) > 512 {
+			t.Errorf("reading %d bytes returned %d bytes, more than the limit", tt.size, len(got))
+		}
+	}
+}
+
+func TestHTTPDownloader_NotAnImage(t *testing.T) {
+	d := NewHTTPDownloader(http.DefaultClient, time.Second*5, 1024)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if ct := req.URL.Query().Get("type"); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		_, _ = w.Write([]byte(req.URL.Query().Get("body")))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		contentType string
+		body        string
+		wantErr     error
+	}{
+		{contentType: "image/png", body: pngSignature},
+		{contentType: "application/octet-stream", body: "GIF89a"},
+		{contentType: "image/webp", body: "RIFF\x00\x01\x00\x00WEBPVP8 "},
+		{body: "\xFF\xD8\xFF\xE0"},
+		{contentType: "text/html", body: pngSignature, wantErr: download.ErrNotAnImage},
+		{contentType: "image/png", body: "<html>", wantErr: download.ErrNotAnImage},
+		{contentType: "image/webp", body: "RIFF\x00\x01\x00\x00WAVE", wantErr: download.ErrNotAnImage},
+		{contentType: "image/png", wantErr: download.ErrNotAnImage},
+	}
+	for _, tt := range tests {
+		q := url.Values{"type": {tt.contentType}, "body": {tt.body}}
+		body, err := d.Download(context.Background(), srv.URL+"?"+q.Encode())
+		if err != tt.wantErr {
+			t.Errorf("Download(%q, %q) error = %v, want %v", tt.contentType, tt.body, err, tt.wantErr)
+		}
+		if err == nil {
+			_ = body.Close()
+		}
+	}
+}
+
+func TestHTTPDownloader_Close(t *testing.T) {
+	d := NewHTTPDownloader(http.DefaultClient, time.Minute, 1024)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(pngSignature))
+	}))
+	defer srv.Close()
+
+	rc, err := d.Download(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelled := false
+	b := rc.(*body)
+	cancel := b.cancel
+	b.cancel = func() {
+		cancelled = true
+		cancel()
+	}
+	if err := rc.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if !cancelled {
+		t.Error("Close() should release the request context")
+	}
+}
+
+func TestHTTPDownloader_RetryAfter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	d := NewHTTPDownloader(http.DefaultClient, time.Second*5, 1024)
+	_, err := d.Download(context.Background(), srv.URL)
+	var se *download.StatusError
+	if !errors.As(err, &se) || se.StatusCode != 503 || se.RetryAfter != 3*time.Second {
+		t.Errorf("Download() error = %#v, want status 503 retried after 3s", err)
+	}
+}
+
+func Test_retryAfter(t *testing.T) {
+	now := time.Date(2020, 8, 24, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		v    string
+		want time.Duration
+	}{
+		{v: "", want: 0},
+		{v: "120", want: 2 * time.Minute},
+		{v: "-1", want: 0},
+		{v: "Mon, 24 Aug 2020 12:00:30 GMT", want: 30 * time.Second},
+		{v: "Mon, 24 Aug 2020 11:00:00 GMT", want: 0},
+		{v: "soon", want: 0},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.v, now); got != tt.want {
+			t.Errorf("retryAfter(%q) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package retrydownloader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"sync"
+	"time"
+
+	"github.com/bokan/facedetection/pkg/download"
+)
+
+// Options tune the retries.
+type Options struct {
+	// MaxAttempts is the maximum number of downloads of a file, the first one included.
+	MaxAttempts int
+	// MinBackoff is the wait before the first retry, doubled for every following one.
+	MinBackoff time.Duration
+	// MaxBackoff bounds the wait between two downloads, including the one asked by the server
+	// with the Retry-After header. Servers asking for more aren't retried.
+	MaxBackoff time.Duration
+	// MaxElapsed bounds every call to Download, its attempts and waits included, on top of the
+	// context deadline. Zero means no bound.
+	MaxElapsed time.Duration
+}
+
+// DefaultOptions returns the options used unless told otherwise.
+func DefaultOptions() Options {
+	return Options{
+		MaxAttempts: 3,
+		MinBackoff:  100 * time.Millisecond,
+		MaxBackoff:  2 * time.Second,
+		// Leaves the API time to detect the faces and answer within its 5s write timeout.
+		MaxElapsed: 4 * time.Second,
+	}
+}
+
+// Stats are the counters of a RetryDownloader.
+type Stats struct {
+	// Downloads counts the calls to Download.
+	Downloads int64 `json:"downloads"`
+	// Retries counts the downloads tried again after a transient failure.
+	Retries int64 `json:"retries"`
+	// Recovered counts the calls to Download that succeeded after a retry.
+	Recovered int64 `json:"recovered"`
+	// Exhausted counts the calls to Download that failed with a transient failure they couldn't
+	// retry anymore, for lack of attempts or time.
+	Exhausted int64 `json:"exhausted"`
+}
+
+// RetryDownloader retries the downloads of the wrapped Downloader failing with a transient error:
+// a network error, or a 429 or 5xx status, including while reading the body. Retries wait with an
+// exponential backoff and jitter, or as long as the server asked with the Retry-After header, and
+// never past the deadline of the context or Options.MaxElapsed. Downloads are GET requests, which
+// are always safe to retry.
+//
+// Files are buffered in memory, so that a download failing midway is retried as a whole.
+type RetryDownloader struct {
+	d           download.Downloader
+	maxFileSize int64
+	opts        Options
+	log         func(kv map[string]interface{})
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// NewRetryDownloader wraps d with the retries tuned by opts, buffering files up to maxFileSize.
+// Bigger files fail with download.ErrFileIsTooBig. Every retry is reported to log, unless it's nil.
+func NewRetryDownloader(d download.Downloader, maxFileSize int64, opts Options, log func(kv map[string]interface{})) *RetryDownloader {
+	return &RetryDownloader{d: d, maxFileSize: maxFileSize, opts: opts, log: log}
+}
+
+// Download downloads url, trying again after transient failures. The error of the last attempt
+// is returned. The returned body implements download.Located.
+func (r *RetryDownloader) Download(ctx context.Context, url string) (io.ReadCloser, error) {
+	r.update(func(s *Stats) { s.Downloads++ })
+	if r.opts.MaxElapsed > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.opts.MaxElapsed)
+		defer cancel()
+	}
+	for attempt := 1; ; attempt++ {
+		data, finalURL, err := download.ReadFile(ctx, r.d, url, r.maxFileSize)
+		if err == nil {
+			if attempt > 1 {
+				r.update(func(s *Stats) { s.Recovered++ })
+			}
+			return download.NewFile(data, finalURL), nil
+		}
+		if ctx.Err() != nil || !transient(err) {
+			return nil, err
+		}
+
+		delay, ok := r.delay(ctx, attempt, err)
+		if !ok {
+			r.update(func(s *Stats) { s.Exhausted++ })
+			return nil, err
+		}
+		r.update(func(s *Stats) { s.Retries++ })
+		if r.log != nil {
+			r.log(map[string]interface{}{
+				"url":     url,
+				"attempt": attempt,
+				"delay":   delay.String(),
+				"err":     err.Error(),
+			})
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, err
+		}
+	}
+}
+
+// Stats returns the current counters.
+func (r *RetryDownloader) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+func (r *RetryDownloader) update(f func(s *Stats)) {
+	r.mu.Lock()
+	f(&r.stats)
+	r.mu.Unlock()
+}
+
+// delay returns the wait before retrying the attempt that failed with err, or false when there's
+// no attempt or time left for a retry.
+func (r *RetryDownloader) delay(ctx context.Context, attempt int, err error) (time.Duration, bool) {
+	if attempt >= r.opts.MaxAttempts {
+		return 0, false
+	}
+
+	// Jitter over the upper half of the backoff spreads the retries while still backing off.
+	backoff := r.opts.MinBackoff << uint(attempt-1)
+	if backoff > r.opts.MaxBackoff || backoff <= 0 {
+		backoff = r.opts.MaxBackoff
+	}
+	delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+
+	var se *download.StatusError
+	if errors.As(err, &se) && se.RetryAfter > 0 {
+		if se.RetryAfter > r.opts.MaxBackoff {
+			return 0, false
+		}
+		delay = se.RetryAfter
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+		return 0, false
+	}
+	return delay, true
+}
+
+// transient tells whether the download failing with err may succeed when tried again.
+func transient(err error) bool {
+	var se *download.StatusError
+	switch {
+	case errors.As(err, &se):
+		return se.StatusCode == http.StatusTooManyRequests || se.StatusCode >= 500
+	case errors.Is(err, download.ErrFileIsTooBig),
+		errors.Is(err, download.ErrNotAnImage),
+		errors.Is(err, download.ErrForbiddenAddress),
+		errors.Is(err, download.ErrTooManyRedirects),
+		errors.Is(err, download.ErrForbiddenRedirect):
+		return false
+	}
+	// Every error of http.Client is a net.Error, what failed is told by the wrapped one.
+	var ue *neturl.Error
+	if errors.As(err, &ue) {
+		err = ue.Err
+	}
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,224 @@
+package retrydownloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/bokan/facedetection/pkg/download"
+	"github.com/bokan/facedetection/pkg/download/httpdownloader"
+)
+
+// seqDownloader fails with the given errors, one per call, then succeeds.
+type seqDownloader struct {
+	errs  []error
+	calls int
+}
+
+func (s *seqDownloader) Download(ctx context.Context, url string) (io.ReadCloser, error) {
+	s.calls++
+	if s.calls <= len(s.errs) {
+		return nil, s.errs[s.calls-1]
+	}
+	return ioutil.NopCloser(strings.NewReader("image")), nil
+}
+
+// truncatedDownloader returns bodies failing midway, then a whole one.
+type truncatedDownloader struct {
+	failures int
+	calls    int
+}
+
+func (d *truncatedDownloader) Download(ctx context.Context, url string) (io.ReadCloser, error) {
+	d.calls++
+	if d.calls <= d.failures {
+		return ioutil.NopCloser(io.MultiReader(strings.NewReader("ima"), iotest.ErrReader(io.ErrUnexpectedEOF))), nil
+	}
+	return ioutil.NopCloser(strings.NewReader("image")), nil
+}
+
+// blockingDownloader blocks until its context ends.
+type blockingDownloader struct {
+	deadline bool
+}
+
+func (d *blockingDownloader) Download(ctx context.Context, url string) (io.ReadCloser, error) {
+	_, d.deadline = ctx.Deadline()
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+var (
+	errReset       = &url.Error{Op: "Get", URL: "http://foo/", Err: &net.OpError{Op: "read", Net: "tcp", Err: fmt.Errorf("connection reset by peer")}}
+	errUnavailable = &download.StatusError{StatusCode: http.StatusServiceUnavailable}
+	errNoScheme    = fmt.Errorf("missing protocol scheme")
+)
+
+func testOptions() Options {
+	return Options{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+}
+
+func TestRetryDownloader_Download(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      []error
+		wantErr   error
+		wantCalls int
+	}{
+		{name: "success", wantCalls: 1},
+		{name: "network error retried", errs: []error{errReset}, wantCalls: 2},
+		{name: "wrapped network error retried", errs: []error{fmt.Errorf("request failed: %w", errReset), errReset}, wantCalls: 3},
+		{name: "unavailable retried", errs: []error{errUnavailable}, wantCalls: 2},
+		{name: "too many requests retried", errs: []error{&download.StatusError{StatusCode: 429}}, wantCalls: 2},
+		{name: "attempts exhausted", errs: []error{errReset, errUnavailable, errUnavailable}, wantErr: errUnavailable, wantCalls: 3},
+		{name: "not found not retried", errs: []error{&download.StatusError{StatusCode: 404}}, wantErr: download.ErrNon200StatusCode, wantCalls: 1},
+		{name: "forbidden address not retried", errs: []error{&url.Error{Op: "Get", URL: "http://foo/", Err: download.ErrForbiddenAddress}}, wantErr: download.ErrForbiddenAddress, wantCalls: 1},
+		{name: "not an image not retried", errs: []error{download.ErrNotAnImage}, wantErr: download.ErrNotAnImage, wantCalls: 1},
+		{name: "invalid url not retried", errs: []error{&url.Error{Op: "parse", URL: ":", Err: errNoScheme}}, wantErr: errNoScheme, wantCalls: 1},
+		{name: "server asking to wait too long not retried", errs: []error{&download.StatusError{StatusCode: 503, RetryAfter: time.Minute}}, wantErr: download.ErrNon200StatusCode, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &seqDownloader{errs: tt.errs}
+			var logged int
+			r := NewRetryDownloader(d, 1024, testOptions(), func(kv map[string]interface{}) { logged++ })
+			body, err := r.Download(context.Background(), "http://foo/")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Download() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				_ = body.Close()
+			}
+			if d.calls != tt.wantCalls {
+				t.Errorf("Download() called the downloader %d times, want %d", d.calls, tt.wantCalls)
+			}
+			if logged != d.calls-1 {
+				t.Errorf("Download() logged %d retries, want %d", logged, d.calls-1)
+			}
+		})
+	}
+}
+
+func TestRetryDownloader_Download_RetryAfter(t *testing.T) {
+	d := &seqDownloader{errs: []error{&download.StatusError{StatusCode: 429, RetryAfter: 15 * time.Millisecond}}}
+	r := NewRetryDownloader(d, 1024, testOptions(), nil)
+	start := time.Now()
+	if _, err := r.Download(context.Background(), "http://foo/"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("Download() retried after %v, before the server asked", elapsed)
+	}
+}
+
+func TestRetryDownloader_Download_Deadline(t *testing.T) {
+	d := &seqDownloader{errs: []error{errUnavailable, errUnavailable}}
+	opts := Options{MaxAttempts: 3, MinBackoff: time.Second, MaxBackoff: time.Second}
+	r := NewRetryDownloader(d, 1024, opts, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := r.Download(ctx, "http://foo/"); err != errUnavailable {
+		t.Errorf("Download() error = %v, want %v", err, errUnavailable)
+	}
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("Download() should give up right away when the retry can't happen before the deadline")
+	}
+	if d.calls != 1 {
+		t.Errorf("Download() called the downloader %d times, want 1", d.calls)
+	}
+	if s := r.Stats(); s.Downloads != 1 || s.Exhausted != 1 || s.Retries != 0 {
+		t.Errorf("Stats() = %+v", s)
+	}
+}
+
+func TestRetryDownloader_Download_Body(t *testing.T) {
+	d := &truncatedDownloader{failures: 1}
+	r := NewRetryDownloader(d, 1024, testOptions(), nil)
+	body, err := r.Download(context.Background(), "http://foo/")
+	if err != nil {
+		t.Fatalf("Download() should retry a body failing midway, got: %v", err)
+	}
+	b, _ := ioutil.ReadAll(body)
+	if string(b) != "image" {
+		t.Errorf("Download() body = %q, want %q", b, "image")
+	}
+	if u := download.FinalURL(body, ""); u != "http://foo/" {
+		t.Errorf("FinalURL() = %q, want %q", u, "http://foo/")
+	}
+	if d.calls != 2 {
+		t.Errorf("Download() called the downloader %d times, want 2", d.calls)
+	}
+
+	d = &truncatedDownloader{failures: 3}
+	r = NewRetryDownloader(d, 1024, testOptions(), nil)
+	if _, err := r.Download(context.Background(), "http://foo/"); err != io.ErrUnexpectedEOF {
+		t.Errorf("Download() error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	d = &truncatedDownloader{}
+	r = NewRetryDownloader(d, 4, testOptions(), nil)
+	if _, err := r.Download(context.Background(), "http://foo/"); err != download.ErrFileIsTooBig {
+		t.Errorf("Download() error = %v, want %v", err, download.ErrFileIsTooBig)
+	}
+	if d.calls != 1 {
+		t.Errorf("Download() called the downloader %d times, want 1", d.calls)
+	}
+}
+
+func TestRetryDownloader_Download_MaxElapsed(t *testing.T) {
+	d := &blockingDownloader{}
+	opts := testOptions()
+	opts.MaxElapsed = 50 * time.Millisecond
+	r := NewRetryDownloader(d, 1024, opts, nil)
+
+	start := time.Now()
+	if _, err := r.Download(context.Background(), "http://foo/"); err != context.DeadlineExceeded {
+		t.Errorf("Download() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Download() took %v, past MaxElapsed", elapsed)
+	}
+	if !d.deadline {
+		t.Error("Download() should give the downloader a deadline")
+	}
+}
+
+func TestRetryDownloader_Download_HTTP(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		case 2:
+			// The body is cut short of the announced length.
+			w.Header().Set("Content-Length", "100")
+		}
+		_, _ = w.Write([]byte("\x89PNG\r\n\x1A\n\x00\x00\x00\x0DIHDR"))
+	}))
+	defer srv.Close()
+
+	r := NewRetryDownloader(httpdownloader.NewHTTPDownloader(http.DefaultClient, time.Second, 1024), 1024, testOptions(), nil)
+	body, err := r.Download(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	_ = body.Close()
+	if s := r.Stats(); s.Downloads != 1 || s.Retries != 2 || s.Recovered != 1 {
+		t.Errorf("Stats() = %+v", s)
+	}
+}
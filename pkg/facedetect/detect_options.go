@@ -0,0 +1,141 @@
+package facedetect
+
+import (
+	"fmt"
+)
+
+// ErrInvalidDetectOptions is returned by DetectOptions.Validate when one of the options is out of range.
+var ErrInvalidDetectOptions = fmt.Errorf("invalid detect options")
+
+const (
+	// MaxAngles is the maximum number of rotation angles a single detection can scan the image at.
+	MaxAngles = 16
+
+	// MinMaxPixels is the smallest pixel budget DetectOptions.MaxPixels and
+	// DetectOptions.MaxFramesPixels can be set to.
+	MinMaxPixels = 100 * 100
+)
+
+// Frame selects the coordinate frame the detections are reported in.
+type Frame int
+
+const (
+	// FrameDisplayed reports coordinates on the image as displayed, with its EXIF orientation applied.
+	FrameDisplayed Frame = iota
+	// FrameStored reports coordinates on the image pixels as stored in the file.
+	FrameStored
+)
+
+// DetectOptions tune the face detection. Trading recall for speed is done by adjusting the
+// scan window sizes and steps, while thresholds control which detections are reported.
+//
+// Use DefaultDetectOptions to obtain the recommended values.
+type DetectOptions struct {
+	// MinSize is the smallest face size, in pixels, the detector looks for.
+	MinSize int
+	// MaxSize is the largest face size, in pixels, the detector looks for.
+	MaxSize int
+	// MinSizeRatio, when positive, overrides MinSize with the given fraction of the shorter image side.
+	MinSizeRatio float64
+	// MaxSizeRatio, when positive, overrides MaxSize with the given fraction of the shorter image side.
+	MaxSizeRatio float64
+	// MaxPixels is the pixel budget of the working resolution. Larger images are scaled down before
+	// the scan and the results are mapped back to the original image coordinates, facial features
+	// being located on the original image. Zero disables the scaling.
+	MaxPixels int
+	// ShiftFactor is the step of the detection window, relative to its size.
+	ShiftFactor float64
+	// ScaleFactor is the rate at which the detection window grows between scans.
+	ScaleFactor float64
+	// IoUThreshold is the intersection over union above which detections are merged into one face.
+	IoUThreshold float64
+	// QualityThreshold is the minimum detection score for a face to be reported.
+	QualityThreshold float32
+	// FeaturesQualityThreshold is the minimum detection score for eyes and mouth to be located.
+	FeaturesQualityThreshold float32
+	// Perturbs is the number of perturbations used when locating facial features.
+	Perturbs int
+	// Angles are the in-plane rotations, in degrees, the image is scanned at. Positive angles find
+	// faces rotated counter-clockwise. Detections found at multiple angles are merged. An empty
+	// slice scans the upright image only.
+	Angles []float64
+	// FullLandmarks enables locating the extended set of facial landmarks, reported in Face.Landmarks.
+	FullLandmarks bool
+	// Frame is the coordinate frame of the reported faces. Faces are always detected on the image
+	// as displayed. In FrameStored, facial features keep the names they have on the displayed image.
+	Frame Frame
+	// AllFrames enables the detection on every frame of animated images, reported in Result.Frames.
+	// Otherwise only the first frame is analyzed.
+	AllFrames bool
+	// MaxFrames is the maximum number of frames of an animation analyzed with AllFrames.
+	MaxFrames int
+	// MaxFramesPixels is the maximum number of pixels in all frames of an animation analyzed with
+	// AllFrames.
+	MaxFramesPixels int
+}
+
+// DefaultDetectOptions returns the options FaceDetector implementations use unless told otherwise.
+func DefaultDetectOptions() DetectOptions {
+	return DetectOptions{
+		MinSize:                  20,
+		MaxSize:                  1000,
+		MaxSizeRatio:             1,
+		MaxPixels:                4000000,
+		ShiftFactor:              0.1,
+		ScaleFactor:              1.1,
+		IoUThreshold:             0.2,
+		QualityThreshold:         20,
+		FeaturesQualityThreshold: 5,
+		Perturbs:                 63,
+		MaxFrames:                100,
+		MaxFramesPixels:          25000000,
+	}
+}
+
+// Validate checks that options are within the ranges a detector can work with. Returned errors wrap
+// ErrInvalidDetectOptions.
+func (o DetectOptions) Validate() error {
+	switch {
+	case o.MinSize < 1:
+		return fmt.Errorf("%w: min size must be positive", ErrInvalidDetectOptions)
+	case o.MaxSize < o.MinSize:
+		return fmt.Errorf("%w: max size must not be smaller than min size", ErrInvalidDetectOptions)
+	case o.MinSizeRatio < 0 || o.MinSizeRatio > 1:
+		return fmt.Errorf("%w: min size ratio must be in [0, 1]", ErrInvalidDetectOptions)
+	case o.MaxSizeRatio < 0 || o.MaxSizeRatio > 1:
+		return fmt.Errorf("%w: max size ratio must be in [0, 1]", ErrInvalidDetectOptions)
+	case o.MaxSizeRatio > 0 && o.MaxSizeRatio < o.MinSizeRatio:
+		return fmt.Errorf("%w: max size ratio must not be smaller than min size ratio", ErrInvalidDetectOptions)
+	case o.MaxPixels != 0 && o.MaxPixels < MinMaxPixels:
+		return fmt.Errorf("%w: max pixels must be 0 or at least %d", ErrInvalidDetectOptions, MinMaxPixels)
+	case o.ShiftFactor <= 0 || o.ShiftFactor > 1:
+		return fmt.Errorf("%w: shift factor must be in (0, 1]", ErrInvalidDetectOptions)
+	case o.ScaleFactor <= 1 || o.ScaleFactor > 2:
+		return fmt.Errorf("%w: scale factor must be in (1, 2]", ErrInvalidDetectOptions)
+	case float64(o.MinSize)*(o.ScaleFactor-1) < 1:
+		// Window sizes are integers, a smaller factor would never grow the window.
+		return fmt.Errorf("%w: scale factor too small for min size", ErrInvalidDetectOptions)
+	case o.IoUThreshold < 0 || o.IoUThreshold > 1:
+		return fmt.Errorf("%w: IoU threshold must be in [0, 1]", ErrInvalidDetectOptions)
+	case o.QualityThreshold < 0:
+		return fmt.Errorf("%w: quality threshold must not be negative", ErrInvalidDetectOptions)
+	case o.FeaturesQualityThreshold < 0:
+		return fmt.Errorf("%w: features quality threshold must not be negative", ErrInvalidDetectOptions)
+	case o.Perturbs < 2 || o.Perturbs > 255:
+		return fmt.Errorf("%w: perturbs must be in [2, 255]", ErrInvalidDetectOptions)
+	case o.Frame != FrameDisplayed && o.Frame != FrameStored:
+		return fmt.Errorf("%w: unknown coordinate frame", ErrInvalidDetectOptions)
+	case o.MaxFrames < 1:
+		return fmt.Errorf("%w: max frames must be positive", ErrInvalidDetectOptions)
+	case o.MaxFramesPixels < MinMaxPixels:
+		return fmt.Errorf("%w: max frames pixels must be at least %d", ErrInvalidDetectOptions, MinMaxPixels)
+	case len(o.Angles) > MaxAngles:
+		return fmt.Errorf("%w: at most %d angles are allowed", ErrInvalidDetectOptions, MaxAngles)
+	}
+	for _, a := range o.Angles {
+		if a < -180 || a > 180 {
+			return fmt.Errorf("%w: angles must be in [-180, 180]", ErrInvalidDetectOptions)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package facedetect
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDetectOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(o *DetectOptions)
+		wantErr bool
+	}{
+		{
+			name:    "defaults",
+			modify:  func(o *DetectOptions) {},
+			wantErr: false,
+		},
+		{
+			name:    "zero min size",
+			modify:  func(o *DetectOptions) { o.MinSize = 0 },
+			wantErr: true,
+		},
+		{
+			name:    "max size smaller than min size",
+			modify:  func(o *DetectOptions) { o.MaxSize = o.MinSize - 1 },
+			wantErr: true,
+		},
+		{
+			name:    "shift factor out of range",
+			modify:  func(o *DetectOptions) { o.ShiftFactor = 1.5 },
+			wantErr: true,
+		},
+		{
+			name:    "scale factor that never grows the window",
+			modify:  func(o *DetectOptions) { o.ScaleFactor = 1.01 },
+			wantErr: true,
+		},
+		{
+			name:    "negative quality threshold",
+			modify:  func(o *DetectOptions) { o.QualityThreshold = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "angles",
+			modify:  func(o *DetectOptions) { o.Angles = []float64{-30, 0, 30} },
+			wantErr: false,
+		},
+		{
+			name:    "angle out of range",
+			modify:  func(o *DetectOptions) { o.Angles = []float64{270} },
+			wantErr: true,
+		},
+		{
+			name:    "size ratios and pixel budget",
+			modify:  func(o *DetectOptions) { o.MinSizeRatio, o.MaxSizeRatio, o.MaxPixels = 0.05, 1, 1000000 },
+			wantErr: false,
+		},
+		{
+			name:    "max size ratio smaller than min size ratio",
+			modify:  func(o *DetectOptions) { o.MinSizeRatio, o.MaxSizeRatio = 0.5, 0.1 },
+			wantErr: true,
+		},
+		{
+			name:    "tiny pixel budget",
+			modify:  func(o *DetectOptions) { o.MaxPixels = 100 },
+			wantErr: true,
+		},
+		{
+			name:    "single perturb",
+			modify:  func(o *DetectOptions) { o.Perturbs = 1 },
+			wantErr: true,
+		},
+		{
+			name:    "no frames",
+			modify:  func(o *DetectOptions) { o.MaxFrames = 0 },
+			wantErr: true,
+		},
+		{
+			name:    "tiny frames pixel budget",
+			modify:  func(o *DetectOptions) { o.MaxFramesPixels = 100 },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := DefaultDetectOptions()
+			tt.modify(&o)
+			err := o.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil && !errors.Is(err, ErrInvalidDetectOptions) {
+				t.Errorf("Validate() error should wrap ErrInvalidDetectOptions, got: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package ensemblefacedetect
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/imagecodec"
+)
+
+// Version identifies the merging of the detections in the responses and the cache keys.
+const Version = "ensemble-1"
+
+// Child is a FaceDetector taking part in an ensemble. Name tags the faces it agreed on.
+type Child struct {
+	facedetect.FaceDetector
+
+	Name string
+}
+
+// Options control how the detections of the children are merged.
+type Options struct {
+	// IoUThreshold is the intersection over union above which detections of the children are
+	// merged into one face.
+	IoUThreshold float64
+	// MinVotes is the number of children that must find a face for it to be reported.
+	MinVotes int
+	// ScoreVoting averages the bounds of merged detections weighted by their scores, and scores
+	// faces by the mean of the children scores, children missing the face scoring zero. Otherwise
+	// the best scored detection is reported as is.
+	ScoreVoting bool
+}
+
+// DefaultOptions returns the options used unless told otherwise.
+func DefaultOptions() Options {
+	return Options{
+		IoUThreshold: 0.3,
+		MinVotes:     1,
+		ScoreVoting:  true,
+	}
+}
+
+// EnsembleFaceDetector runs several FaceDetectors concurrently and merges their detections.
+// The image is decoded once and shared by the children implementing
+// facedetect.DecodedFaceDetector, the others get the encoded image.
+//
+// Facial features of merged faces are the ones of the best scored detection having them, and
+// the faces list the names of the children that found them in Face.Detectors.
+type EnsembleFaceDetector struct {
+	children []Child
+	opts     Options
+}
+
+// NewEnsembleFaceDetector returns a FaceDetector merging the detections of children.
+func NewEnsembleFaceDetector(children []Child, opts Options) *EnsembleFaceDetector {
+	return &EnsembleFaceDetector{children: children, opts: opts}
+}
+
+// DetectFaces runs the detection on every child. It fails when any of them does.
+func (e *EnsembleFaceDetector) DetectFaces(ctx context.Context, img io.Reader, opts facedetect.DetectOptions) (*facedetect.Result, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(img)
+	if err != nil {
+		return nil, facedetect.DecodeError(ctx, err)
+	}
+
+	var frames []*imagecodec.Image
+	var delays []time.Duration
+	result := &facedetect.Result{}
+	if opts.AllFrames {
+		anim, err := imagecodec.DecodeAll(bytes.NewReader(data), opts.MaxFrames, opts.MaxFramesPixels)
+		if err != nil {
+			return nil, facedetect.DecodeError(ctx, err)
+		}
+		for _, f := range anim.Frames {
+			frames = append(frames, &imagecodec.Image{Image: f.Image, Format: anim.Format, Orientation: anim.Orientation})
+			delays = append(delays, f.Delay)
+		}
+		result.Format, result.Orientation = anim.Format, int(anim.Orientation)
+	} else {
+		decoded, err := imagecodec.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, facedetect.DecodeError(ctx, err)
+		}
+		frames = []*imagecodec.Image{decoded}
+		result.Format, result.Orientation = decoded.Format, int(decoded.Orientation)
+	}
+
+	votes, err := e.run(ctx, data, frames, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range frames {
+		perChild := make([][]facedetect.Face, len(e.children))
+		for c := range e.children {
+			perChild[c] = votes[c][i]
+		}
+		faces := e.merge(perChild)
+		if !opts.AllFrames {
+			result.Faces = faces
+			break
+		}
+		result.Frames = append(result.Frames, facedetect.FrameFaces{
+			Index: i,
+			Delay: int(delays[i] / time.Millisecond),
+			Faces: faces,
+		})
+	}
+	if opts.AllFrames {
+		result.Faces = result.Frames[0].Faces
+	}
+	return result, nil
+}
+
+// run runs the children concurrently, returning the faces each of them found on every frame.
+// The first failure cancels the other children.
+func (e *EnsembleFaceDetector) run(ctx context.Context, data []byte, frames []*imagecodec.Image, opts facedetect.DetectOptions) ([][][]facedetect.Face, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	votes := make([][][]facedetect.Face, len(e.children))
+	errs := make([]error, len(e.children))
+	var wg sync.WaitGroup
+	for i, c := range e.children {
+		wg.Add(1)
+		go func(i int, c Child) {
+			defer wg.Done()
+			votes[i], errs[i] = detectFrames(ctx, c, data, frames, opts)
+			if errs[i] != nil {
+				cancel()
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	// Report the error that caused the cancellation rather than the cancellation itself.
+	var first error
+	for _, err := range errs {
+		if err != nil && (first == nil || first == context.Canceled) {
+			first = err
+		}
+	}
+	return votes, first
+}
+
+// detectFrames returns the faces c finds on every frame.
+func detectFrames(ctx context.Context, c Child, data []byte, frames []*imagecodec.Image, opts facedetect.DetectOptions) ([][]facedetect.Face, error) {
+	if dfd, ok := c.FaceDetector.(facedetect.DecodedFaceDetector); ok {
+		faces := make([][]facedetect.Face, len(frames))
+		var err error
+		for i, f := range frames {
+			var res *facedetect.Result
+			if res, err = dfd.DetectDecodedFaces(ctx, f, opts); err != nil {
+				break
+			}
+			faces[i] = res.Faces
+		}
+		if err != facedetect.ErrDecodedUnsupported {
+			return faces, err
+		}
+	}
+
+	res, err := c.DetectFaces(ctx, bytes.NewReader(data), opts)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.AllFrames {
+		return [][]facedetect.Face{res.Faces}, nil
+	}
+	if len(res.Frames) != len(frames) {
+		return nil, fmt.Errorf("detector %s found %d frames instead of %d", c.Name, len(res.Frames), len(frames))
+	}
+	faces := make([][]facedetect.Face, len(frames))
+	for _, f := range res.Frames {
+		if f.Index < 0 || f.Index >= len(frames) {
+			return nil, fmt.Errorf("detector %s reported frame %d out of range", c.Name, f.Index)
+		}
+		faces[f.Index] = f.Faces
+	}
+	return faces, nil
+}
+
+// vote is a face found by one of the children.
+type vote struct {
+	child int
+	face  facedetect.Face
+}
+
+// merge clusters the faces found by every child, perChild being indexed like e.children.
+// Detections are taken by descending score, each one absorbing the remaining detections
+// overlapping it by more than the IoU threshold.
+func (e *EnsembleFaceDetector) merge(perChild [][]facedetect.Face) []facedetect.Face {
+	var all []vote
+	for c, faces := range perChild {
+		for _, f := range faces {
+			all = append(all, vote{child: c, face: f})
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].face.Score > all[j].face.Score
+	})
+
+	merged := []facedetect.Face{}
+	used := make([]bool, len(all))
+	for i := range all {
+		if used[i] {
+			continue
+		}
+		used[i] = true
+		cluster := []vote{all[i]}
+		for j := i + 1; j < len(all); j++ {
+			if !used[j] && iou(all[i].face.Bounds, all[j].face.Bounds) > e.opts.IoUThreshold {
+				used[j] = true
+				cluster = append(cluster, all[j])
+			}
+		}
+		if f, ok := e.mergeCluster(cluster); ok {
+			merged = append(merged, f)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Score > merged[j].Score
+	})
+	return merged
+}
+
+// mergeCluster returns the face agreed on by the detections of cluster, sorted by descending
+// score, or false when too few children found it.
+func (e *EnsembleFaceDetector) mergeCluster(cluster []vote) (facedetect.Face, bool) {
+	// The best score of every child that found the face.
+	best := map[int]float32{}
+	for _, v := range cluster {
+		if s, ok := best[v.child]; !ok || v.face.Score > s {
+			best[v.child] = v.face.Score
+		}
+	}
+	minVotes := e.opts.MinVotes
+	if minVotes < 1 {
+		minVotes = 1
+	}
+	if len(best) < minVotes {
+		return facedetect.Face{}, false
+	}
+
+	f := cluster[0].face
+	if f.Bounds != nil {
+		b := *f.Bounds
+		f.Bounds = &b
+	}
+	for _, v := range cluster[1:] {
+		if f.LeftEye == nil && f.RightEye == nil && f.Mouth == nil {
+			f.LeftEye, f.RightEye, f.Mouth = v.face.LeftEye, v.face.RightEye, v.face.Mouth
+		}
+		if f.Landmarks == nil {
+			f.Landmarks = v.face.Landmarks
+		}
+	}
+
+	f.Detectors = nil
+	for c, child := range e.children {
+		if _, ok := best[c]; ok {
+			f.Detectors = append(f.Detectors, child.Name)
+		}
+	}
+
+	if e.opts.ScoreVoting {
+		var sum float32
+		for _, s := range best {
+			sum += s
+		}
+		f.Score = sum / float32(len(e.children))
+		if f.Bounds != nil {
+			*f.Bounds = weightedBounds(cluster)
+		}
+	}
+	return f, true
+}
+
+// weightedBounds averages the bounds of the detections weighted by their scores. Detections
+// without bounds are skipped, and negative scores weigh nothing.
+func weightedBounds(cluster []vote) facedetect.Bounds {
+	var x, y, w, h, total float64
+	for _, v := range cluster {
+		if v.face.Bounds == nil {
+			continue
+		}
+		weight := math.Max(float64(v.face.Score), 0)
+		x += weight * float64(v.face.Bounds.X)
+		y += weight * float64(v.face.Bounds.Y)
+		w += weight * float64(v.face.Bounds.Width)
+		h += weight * float64(v.face.Bounds.Height)
+		total += weight
+	}
+	if total == 0 {
+		return *cluster[0].face.Bounds
+	}
+	return facedetect.Bounds{
+		X:      int(math.Round(x / total)),
+		Y:      int(math.Round(y / total)),
+		Width:  int(math.Round(w / total)),
+		Height: int(math.Round(h / total)),
+	}
+}
+
+// iou returns the intersection over union of two bounds, zero when either is missing.
+func iou(b1, b2 *facedetect.Bounds) float64 {
+	if b1 == nil || b2 == nil {
+		return 0
+	}
+	r1 := image.Rect(b1.X, b1.Y, b1.X+b1.Width, b1.Y+b1.Height)
+	r2 := image.Rect(b2.X, b2.Y, b2.X+b2.Width, b2.Y+b2.Height)
+	in := r1.Intersect(r2)
+	if in.Empty() {
+		return 0
+	}
+	inArea := float64(in.Dx() * in.Dy())
+	return inArea / (float64(r1.Dx()*r1.Dy()+r2.Dx()*r2.Dy()) - inArea)
+}
@@ -0,0 +1,176 @@
+package ensemblefacedetect
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/facedetect/fakefacedetect"
+	"github.com/bokan/facedetection/pkg/imagecodec"
+)
+
+// decodedFake finds faces on decoded images, counting the calls.
+type decodedFake struct {
+	faces   []facedetect.Face
+	decoded int
+}
+
+func (d *decodedFake) DetectFaces(ctx context.Context, img io.Reader, opts facedetect.DetectOptions) (*facedetect.Result, error) {
+	return nil, fmt.Errorf("should detect on the decoded image")
+}
+
+func (d *decodedFake) DetectDecodedFaces(ctx context.Context, img *imagecodec.Image, opts facedetect.DetectOptions) (*facedetect.Result, error) {
+	d.decoded++
+	return &facedetect.Result{Faces: d.faces}, nil
+}
+
+func testImage(t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 100, 100))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func face(x, y, size int, score float32) facedetect.Face {
+	return facedetect.Face{Bounds: &facedetect.Bounds{X: x, Y: y, Width: size, Height: size}, Score: score}
+}
+
+func TestEnsembleFaceDetector_DetectFaces(t *testing.T) {
+	eye := &facedetect.Point{X: 15, Y: 15}
+	withEye := face(12, 10, 20, 2)
+	withEye.LeftEye = eye
+
+	tests := []struct {
+		name     string
+		children [][]facedetect.Face
+		opts     Options
+		want     []facedetect.Face
+	}{
+		{
+			name: "overlapping faces are merged by score voting",
+			children: [][]facedetect.Face{
+				{face(10, 10, 20, 6)},
+				{withEye},
+			},
+			opts: DefaultOptions(),
+			want: []facedetect.Face{{
+				Bounds:    &facedetect.Bounds{X: 11, Y: 10, Width: 20, Height: 20},
+				LeftEye:   eye,
+				Score:     4,
+				Detectors: []string{"a", "b"},
+			}},
+		},
+		{
+			name: "without score voting the best detection is kept",
+			children: [][]facedetect.Face{
+				{face(10, 10, 20, 6)},
+				{face(12, 10, 20, 2)},
+			},
+			opts: Options{IoUThreshold: 0.3, MinVotes: 1},
+			want: []facedetect.Face{{
+				Bounds:    &facedetect.Bounds{X: 10, Y: 10, Width: 20, Height: 20},
+				Score:     6,
+				Detectors: []string{"a", "b"},
+			}},
+		},
+		{
+			name: "faces missing votes are dropped",
+			children: [][]facedetect.Face{
+				{face(10, 10, 20, 6), face(60, 60, 20, 8)},
+				{face(12, 10, 20, 2)},
+			},
+			opts: Options{IoUThreshold: 0.3, MinVotes: 2},
+			want: []facedetect.Face{{
+				Bounds:    &facedetect.Bounds{X: 10, Y: 10, Width: 20, Height: 20},
+				Score:     6,
+				Detectors: []string{"a", "b"},
+			}},
+		},
+		{
+			name: "distant faces are kept apart",
+			children: [][]facedetect.Face{
+				{face(10, 10, 20, 6)},
+				{face(60, 60, 20, 8)},
+			},
+			opts: DefaultOptions(),
+			want: []facedetect.Face{
+				{Bounds: &facedetect.Bounds{X: 60, Y: 60, Width: 20, Height: 20}, Score: 4, Detectors: []string{"b"}},
+				{Bounds: &facedetect.Bounds{X: 10, Y: 10, Width: 20, Height: 20}, Score: 3, Detectors: []string{"a"}},
+			},
+		},
+		{
+			name: "duplicates of a child count once",
+			children: [][]facedetect.Face{
+				{face(10, 10, 20, 6), face(11, 10, 20, 4)},
+				{},
+			},
+			opts: Options{IoUThreshold: 0.3, MinVotes: 2},
+			want: []facedetect.Face{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			children := []Child{
+				{Name: "a", FaceDetector: fakefacedetect.NewFakeFaceDetect(tt.children[0], nil)},
+				{Name: "b", FaceDetector: &decodedFake{faces: tt.children[1]}},
+			}
+			e := NewEnsembleFaceDetector(children, tt.opts)
+			got, err := e.DetectFaces(context.Background(), bytes.NewReader(testImage(t)), facedetect.DefaultDetectOptions())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Faces, tt.want) {
+				t.Errorf("DetectFaces() faces = %+v, want %+v", got.Faces, tt.want)
+			}
+			if got.Format != "png" {
+				t.Errorf("DetectFaces() format = %q, want png", got.Format)
+			}
+			if n := children[1].FaceDetector.(*decodedFake).decoded; n != 1 {
+				t.Errorf("decoded detector called %d times, want 1", n)
+			}
+		})
+	}
+}
+
+func TestEnsembleFaceDetector_DetectFaces_Errors(t *testing.T) {
+	failure := fmt.Errorf("failure")
+	e := NewEnsembleFaceDetector([]Child{
+		{Name: "a", FaceDetector: fakefacedetect.NewFakeFaceDetect(nil, nil)},
+		{Name: "b", FaceDetector: fakefacedetect.NewFakeFaceDetect(nil, failure)},
+	}, DefaultOptions())
+
+	if _, err := e.DetectFaces(context.Background(), bytes.NewReader(testImage(t)), facedetect.DefaultDetectOptions()); err != failure {
+		t.Errorf("DetectFaces() error = %v, want %v", err, failure)
+	}
+	if _, err := e.DetectFaces(context.Background(), strings.NewReader("not an image"), facedetect.DefaultDetectOptions()); err == nil {
+		t.Error("DetectFaces() should fail for an invalid image")
+	}
+	opts := facedetect.DefaultDetectOptions()
+	opts.MinSize = -1
+	if _, err := e.DetectFaces(context.Background(), bytes.NewReader(testImage(t)), opts); err == nil {
+		t.Error("DetectFaces() should fail for invalid options")
+	}
+}
+
+func TestEnsembleFaceDetector_DetectFaces_AllFrames(t *testing.T) {
+	d := &decodedFake{faces: []facedetect.Face{face(10, 10, 20, 6)}}
+	e := NewEnsembleFaceDetector([]Child{{Name: "a", FaceDetector: d}}, DefaultOptions())
+	opts := facedetect.DefaultDetectOptions()
+	opts.AllFrames = true
+
+	got, err := e.DetectFaces(context.Background(), bytes.NewReader(testImage(t)), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Frames) != 1 || len(got.Frames[0].Faces) != 1 || len(got.Faces) != 1 {
+		t.Errorf("DetectFaces() = %+v, want one frame with one face", got)
+	}
+}
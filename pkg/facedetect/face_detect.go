@@ -3,7 +3,10 @@ package facedetect
 import (
 	"context"
 	"fmt"
+	"image"
 	"io"
+
+	"github.com/bokan/facedetection/pkg/imagecodec"
 )
 
 var (
@@ -12,6 +15,18 @@ var (
 
 	// ErrImageError is returned by FaceDetector.DetectFaces calls when face detector is unable to decode the image.
 	ErrImageError = fmt.Errorf("error loading image")
+
+	// ErrAnimationTooLarge is returned by FaceDetector.DetectFaces calls when an animation exceeds
+	// DetectOptions.MaxFrames or DetectOptions.MaxFramesPixels.
+	ErrAnimationTooLarge = fmt.Errorf("animation too large")
+
+	// ErrBusy is returned by FaceDetector.DetectFaces calls when the detector is too busy to accept
+	// the image. The call can be retried later.
+	ErrBusy = fmt.Errorf("face detector busy")
+
+	// ErrDecodedUnsupported is returned by DecodedFaceDetector.DetectDecodedFaces calls of wrappers
+	// whose wrapped FaceDetector can't analyze decoded images.
+	ErrDecodedUnsupported = fmt.Errorf("decoded images unsupported")
 )
 
 // Bounds contains the position and size of face boundaries rectangle.
@@ -29,14 +44,77 @@ type Point struct {
 }
 
 // Face is a container for face boundaries and positions of facial features.
+//
+// Score is the detection confidence reported by the detector. Scores are comparable only between
+// faces found by the same detector, higher meaning more certain. Angle is the in-plane rotation
+// (roll) of the face in degrees, positive values meaning counter-clockwise rotation.
+//
+// Landmarks are reported only when requested with DetectOptions.FullLandmarks. They are keyed by
+// name, for example "left_eyebrow_outer" or "right_mouth_corner", where left and right follow the
+// same convention as LeftEye and RightEye.
 type Face struct {
 	Bounds   *Bounds `json:"bounds"`
+	Score    float32 `json:"score"`
+	Angle    float64 `json:"angle,omitempty"`
 	Mouth    *Point  `json:"mouth,omitempty"`
 	RightEye *Point  `json:"right_eye,omitempty"`
 	LeftEye  *Point  `json:"left_eye,omitempty"`
+
+	Landmarks map[string]*Point `json:"landmarks,omitempty"`
+
+	// Detectors names the detectors that found the face, when it's the result of merging the
+	// detections of several of them.
+	Detectors []string `json:"detectors,omitempty"`
+}
+
+// FrameFaces are the faces detected on a single frame of an animation. Delay is the time the frame
+// is displayed for, in milliseconds.
+type FrameFaces struct {
+	Index int    `json:"index"`
+	Delay int    `json:"delay"`
+	Faces []Face `json:"faces"`
+}
+
+// Result is a container for detected faces and the information about the analyzed image.
+//
+// Faces are detected on the first frame of animated images. With DetectOptions.AllFrames, Frames
+// holds the faces of every frame, still images having one.
+//
+// Format is the name of the decoded image format, e.g. "jpeg" or "webp". Orientation is the EXIF
+// orientation that was applied to the image before the detection, 1 for images displayed as stored.
+type Result struct {
+	Faces       []Face       `json:"faces"`
+	Frames      []FrameFaces `json:"frames,omitempty"`
+	Format      string       `json:"format"`
+	Orientation int          `json:"orientation"`
 }
 
 // FaceDetector performs the image analysis and returns the list of detected faces with facial features.
+//
+// Implementations should reject invalid opts with an error wrapping ErrInvalidDetectOptions.
 type FaceDetector interface {
-	DetectFaces(ctx context.Context, img io.Reader) ([]Face, error)
+	DetectFaces(ctx context.Context, img io.Reader, opts DetectOptions) (*Result, error)
+}
+
+// DecodedFaceDetector is implemented by FaceDetectors able to analyze an image decoded by the
+// caller, which lets several detections share a single decoding. The image is analyzed as
+// displayed, with img.Orientation telling how it's stored. DetectOptions.AllFrames is ignored.
+type DecodedFaceDetector interface {
+	FaceDetector
+	DetectDecodedFaces(ctx context.Context, img *imagecodec.Image, opts DetectOptions) (*Result, error)
+}
+
+// DecodeError maps the errors of decoding an image with the imagecodec package to the ones
+// returned by FaceDetector.DetectFaces. The context error is returned once ctx is done.
+func DecodeError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	switch err {
+	case image.ErrFormat:
+		return ErrImageError
+	case imagecodec.ErrAnimationTooLarge:
+		return ErrAnimationTooLarge
+	}
+	return err
 }
@@ -20,6 +20,9 @@ func NewFakeFaceDetect(detections []facedetect.Face, err error) *FakeFaceDetect
 }
 
 // DetectFaces returns the parameters given to constructor.
-func (f FakeFaceDetect) DetectFaces(ctx context.Context, img io.Reader) ([]facedetect.Face, error) {
-	return f.detections, f.err
+func (f FakeFaceDetect) DetectFaces(ctx context.Context, img io.Reader, opts facedetect.DetectOptions) (*facedetect.Result, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &facedetect.Result{Faces: f.detections, Orientation: 1}, nil
 }
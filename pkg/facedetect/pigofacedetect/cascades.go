@@ -0,0 +1,207 @@
+package pigofacedetect
+
+import (
+	"embed"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"math"
+	"path"
+
+	pigo "github.com/esimov/pigo/core"
+)
+
+//go:embed cascades
+var cascades embed.FS
+
+// DefaultCascades returns the cascades embedded in the binary, laid out the way
+// PigoFaceDetector.LoadCascadesFS expects them.
+func DefaultCascades() fs.FS {
+	sub, err := fs.Sub(cascades, "cascades")
+	if err != nil {
+		// The directory is embedded at build time, it's always there.
+		panic(err)
+	}
+	return sub
+}
+
+// readCascadeDir is pigo's ReadCascadeDir reading the facial landmark cascades from fsys.
+func readCascadeDir(pl *pigo.PuplocCascade, fsys fs.FS, dir string) (map[string][]*pigo.FlpCascade, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("the provided directory is empty")
+	}
+
+	flpcs := make(map[string][]*pigo.FlpCascade)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		flpc, err := pl.UnpackCascade(data)
+		if err != nil {
+			return nil, err
+		}
+		flpcs[e.Name()] = append(flpcs[e.Name()], &pigo.FlpCascade{PuplocCascade: flpc})
+	}
+	return flpcs, nil
+}
+
+// faceCascade is pigo's face classifier, unpacked from the same cascade file. pigo only scans
+// whole images, faceCascade classifies one region at a time so that scans can be interrupted.
+type faceCascade struct {
+	treeDepth     uint32
+	treeNum       uint32
+	treeCodes     []int8
+	treePred      []float32
+	treeThreshold []float32
+}
+
+// unpackFaceCascade is pigo's Unpack, failing with ErrInvalidCascade on truncated data.
+func unpackFaceCascade(data []byte) (*faceCascade, error) {
+	// The first 8 bytes are skipped, as pigo does.
+	pos := 8
+	read := func() (uint32, error) {
+		if len(data) < pos+4 {
+			return 0, fmt.Errorf("%w: truncated face cascade", ErrInvalidCascade)
+		}
+		v := binary.LittleEndian.Uint32(data[pos:])
+		pos += 4
+		return v, nil
+	}
+
+	fc := &faceCascade{}
+	var err error
+	if fc.treeDepth, err = read(); err != nil {
+		return nil, err
+	}
+	if fc.treeNum, err = read(); err != nil {
+		return nil, err
+	}
+	if fc.treeDepth > 16 {
+		return nil, fmt.Errorf("%w: face cascade trees too deep", ErrInvalidCascade)
+	}
+	leaves := 1 << fc.treeDepth
+	for t := 0; t < int(fc.treeNum); t++ {
+		codes := 4*leaves - 4
+		if len(data) < pos+codes {
+			return nil, fmt.Errorf("%w: truncated face cascade", ErrInvalidCascade)
+		}
+		fc.treeCodes = append(fc.treeCodes, 0, 0, 0, 0)
+		for _, b := range data[pos : pos+codes] {
+			fc.treeCodes = append(fc.treeCodes, int8(b))
+		}
+		pos += codes
+
+		for i := 0; i < leaves; i++ {
+			pred, err := read()
+			if err != nil {
+				return nil, err
+			}
+			fc.treePred = append(fc.treePred, math.Float32frombits(pred))
+		}
+		threshold, err := read()
+		if err != nil {
+			return nil, err
+		}
+		fc.treeThreshold = append(fc.treeThreshold, math.Float32frombits(threshold))
+	}
+	if fc.treeNum == 0 {
+		return nil, fmt.Errorf("%w: empty face cascade", ErrInvalidCascade)
+	}
+	return fc, nil
+}
+
+// classifyRegion is pigo's classifyRegion, scoring the upright region of size s centered on (r, c).
+func (fc *faceCascade) classifyRegion(r, c, s int, pixels []uint8, dim int) float32 {
+	var (
+		root      int
+		out       float32
+		treeDepth = 1 << fc.treeDepth
+	)
+
+	r = r * 256
+	c = c * 256
+
+	for i := 0; i < int(fc.treeNum); i++ {
+		idx := 1
+
+		for j := 0; j < int(fc.treeDepth); j++ {
+			x1 := ((r+int(fc.treeCodes[root+4*idx+0])*s)>>8)*dim + ((c + int(fc.treeCodes[root+4*idx+1])*s) >> 8)
+			x2 := ((r+int(fc.treeCodes[root+4*idx+2])*s)>>8)*dim + ((c + int(fc.treeCodes[root+4*idx+3])*s) >> 8)
+
+			idx = 2*idx + bintest(pixels[x1], pixels[x2])
+		}
+		out += fc.treePred[treeDepth*i+idx-treeDepth]
+
+		if out <= fc.treeThreshold[i] {
+			return -1.0
+		}
+		root += 4 * treeDepth
+	}
+	return out - fc.treeThreshold[fc.treeNum-1]
+}
+
+var (
+	qCosTable = []int{256, 251, 236, 212, 181, 142, 97, 49, 0, -49, -97, -142, -181, -212, -236, -251, -256, -251, -236, -212, -181, -142, -97, -49, 0, 49, 97, 142, 181, 212, 236, 251, 256}
+	qSinTable = []int{0, 49, 97, 142, 181, 212, 236, 251, 256, 251, 236, 212, 181, 142, 97, 49, 0, -49, -97, -142, -181, -212, -236, -251, -256, -251, -236, -212, -181, -142, -97, -49, 0}
+)
+
+// classifyRotatedRegion is pigo's classifyRotatedRegion, scoring the region of size s centered on
+// (r, c) and rotated by a. Like pigo, it clamps both coordinates to the number of rows.
+func (fc *faceCascade) classifyRotatedRegion(r, c, s int, a float64, nrows int, pixels []uint8, dim int) float32 {
+	var (
+		root      int
+		out       float32
+		treeDepth = 1 << fc.treeDepth
+	)
+
+	qsin := s * qSinTable[int(32.0*a)]
+	qcos := s * qCosTable[int(32.0*a)]
+
+	for i := 0; i < int(fc.treeNum); i++ {
+		idx := 1
+
+		for j := 0; j < int(fc.treeDepth); j++ {
+			r1 := clamp(65536*r+qcos*int(fc.treeCodes[root+4*idx+0])-qsin*int(fc.treeCodes[root+4*idx+1]), nrows)
+			c1 := clamp(65536*c+qsin*int(fc.treeCodes[root+4*idx+0])+qcos*int(fc.treeCodes[root+4*idx+1]), nrows)
+
+			r2 := clamp(65536*r+qcos*int(fc.treeCodes[root+4*idx+2])-qsin*int(fc.treeCodes[root+4*idx+3]), nrows)
+			c2 := clamp(65536*c+qsin*int(fc.treeCodes[root+4*idx+2])+qcos*int(fc.treeCodes[root+4*idx+3]), nrows)
+
+			idx = 2*idx + bintest(pixels[r1*dim+c1], pixels[r2*dim+c2])
+		}
+		out += fc.treePred[treeDepth*i+idx-treeDepth]
+
+		if out <= fc.treeThreshold[i] {
+			return -1.0
+		}
+		root += 4 * treeDepth
+	}
+	return out - fc.treeThreshold[fc.treeNum-1]
+}
+
+func bintest(px1, px2 uint8) int {
+	if px1 <= px2 {
+		return 1
+	}
+	return 0
+}
+
+// clamp converts the fixed point coordinate v to a pixel one in range [0, nrows).
+func clamp(v, nrows int) int {
+	if v < 0 {
+		v = 0
+	}
+	v >>= 16
+	if v > nrows-1 {
+		v = nrows - 1
+	}
+	return v
+}
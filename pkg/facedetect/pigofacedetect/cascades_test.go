@@ -0,0 +1,176 @@
+package pigofacedetect
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/facedetect/fakefacedetect"
+	pigo "github.com/esimov/pigo/core"
+)
+
+func TestPigoFaceDetect_LoadCascadesFS(t *testing.T) {
+	img, err := ioutil.ReadFile(path.Join("testdata", "people002.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pfd := NewPigoFaceDetector()
+	if err := pfd.LoadCascadesFS(DefaultCascades()); err != nil {
+		t.Fatal(err)
+	}
+	result, err := pfd.DetectFaces(context.Background(), bytes.NewReader(img), facedetect.DefaultDetectOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Faces) != 3 {
+		t.Errorf("DetectFaces() with the embedded cascades should find 3 faces, got %d", len(result.Faces))
+	}
+}
+
+func TestPigoFaceDetect_LoadCascadesFSMissingFiles(t *testing.T) {
+	full := fstest.MapFS{}
+	err := fs.WalkDir(DefaultCascades(), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(DefaultCascades(), p)
+		full[p] = &fstest.MapFile{Data: data}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, missing := range []string{"facefinder", "puploc", "lps"} {
+		fsys := fstest.MapFS{}
+		for p, f := range full {
+			if p != missing && path.Dir(p) != missing {
+				fsys[p] = f
+			}
+		}
+		if err := NewPigoFaceDetector().LoadCascadesFS(fsys); err == nil {
+			t.Errorf("LoadCascadesFS() should fail without %s", missing)
+		}
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	pfd := NewPigoFaceDetector()
+	if err := pfd.LoadCascadesFS(DefaultCascades()); err != nil {
+		t.Fatal(err)
+	}
+	if err := SelfTest(context.Background(), pfd); err != nil {
+		t.Errorf("SelfTest() should pass with the embedded cascades, got: %v", err)
+	}
+
+	if err := SelfTest(context.Background(), fakefacedetect.NewFakeFaceDetect(nil, nil)); !errors.Is(err, ErrSelfTestFailed) {
+		t.Errorf("SelfTest() should return ErrSelfTestFailed when no faces are found, got: %v", err)
+	}
+	if err := SelfTest(context.Background(), fakefacedetect.NewFakeFaceDetect(nil, fmt.Errorf("fake error"))); !errors.Is(err, ErrSelfTestFailed) {
+		t.Errorf("SelfTest() should return ErrSelfTestFailed when the detection fails, got: %v", err)
+	}
+}
+
+func TestPigoFaceDetect_LoadCascadesFSMalformed(t *testing.T) {
+	pfd := NewPigoFaceDetector()
+	if err := pfd.LoadCascadesFS(DefaultCascades()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pupil localization cascade is not a face finder.
+	mismatched := fstest.MapFS{}
+	for _, name := range []string{"puploc", "lps/lp38"} {
+		data, err := fs.ReadFile(DefaultCascades(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mismatched[name] = &fstest.MapFile{Data: data}
+	}
+	mismatched["facefinder"] = mismatched["puploc"]
+	if err := pfd.LoadCascadesFS(mismatched); !errors.Is(err, ErrInvalidCascade) {
+		t.Errorf("LoadCascadesFS() should return ErrInvalidCascade, got: %v", err)
+	}
+
+	// The previously loaded cascades are still in use.
+	if err := SelfTest(context.Background(), pfd); err != nil {
+		t.Errorf("failed load should leave the detector unchanged, got: %v", err)
+	}
+}
+
+func TestFaceCascade_RunCascadeScales(t *testing.T) {
+	pfd := NewPigoFaceDetector()
+	if err := pfd.LoadCascadesFS(DefaultCascades()); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path.Join("testdata", "people002.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nrgba := pigo.ImgToNRGBA(src)
+	cols, rows := nrgba.Bounds().Dx(), nrgba.Bounds().Dy()
+	cParams := pigo.CascadeParams{
+		MinSize:     20,
+		MaxSize:     400,
+		ShiftFactor: 0.1,
+		ScaleFactor: 1.1,
+		ImageParams: pigo.ImageParams{
+			Pixels: pigo.RgbToGrayscale(nrgba),
+			Rows:   rows,
+			Cols:   cols,
+			Dim:    cols,
+		},
+	}
+
+	// The interruptible scan finds the same windows as pigo.
+	for _, a := range []float64{0, 0.02, 1.5} {
+		got, err := pfd.runCascadeScales(context.Background(), cParams, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := pfd.classifier.RunCascade(cParams, a)
+		if len(want) == 0 {
+			t.Fatalf("pigo should find windows with angle %v", a)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("runCascadeScales() with angle %v found %d windows, pigo %d", a, len(got), len(want))
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pfd.runCascadeScales(ctx, cParams, 0); err != context.Canceled {
+		t.Errorf("runCascadeScales() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestUnpackFaceCascade(t *testing.T) {
+	data, err := fs.ReadFile(DefaultCascades(), "facefinder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unpackFaceCascade(data); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{0, 12, len(data) / 2, len(data) - 1} {
+		if _, err := unpackFaceCascade(data[:n]); !errors.Is(err, ErrInvalidCascade) {
+			t.Errorf("unpackFaceCascade() of %d bytes should return ErrInvalidCascade, got: %v", n, err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package pigofacedetect
+
+import (
+	"image"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/imagecodec"
+)
+
+// storedFace maps the face found on the displayed image of width w and height h to the
+// coordinates of the stored image.
+func storedFace(face *facedetect.Face, o imagecodec.Orientation, w, h int) {
+	if o == imagecodec.OrientationNormal {
+		return
+	}
+	r := o.StoredRect(image.Rect(face.Bounds.X, face.Bounds.Y, face.Bounds.X+face.Bounds.Width, face.Bounds.Y+face.Bounds.Height), w, h)
+	face.Bounds = &facedetect.Bounds{
+		X:      r.Min.X,
+		Y:      r.Min.Y,
+		Height: r.Dy(),
+		Width:  r.Dx(),
+	}
+	face.Angle = o.StoredAngle(face.Angle)
+
+	storedPoint := func(p *facedetect.Point) *facedetect.Point {
+		if p == nil {
+			return nil
+		}
+		x, y := o.StoredPoint(p.X, p.Y, w, h)
+		return &facedetect.Point{X: x, Y: y}
+	}
+	face.Mouth = storedPoint(face.Mouth)
+	face.LeftEye = storedPoint(face.LeftEye)
+	face.RightEye = storedPoint(face.RightEye)
+	for name, p := range face.Landmarks {
+		face.Landmarks[name] = storedPoint(p)
+	}
+}
@@ -2,36 +2,53 @@ package pigofacedetect
 
 import (
 	"context"
+	"fmt"
 	"image"
-	_ "image/jpeg" // Add JPEG support.
-	_ "image/png"  // Add PNG support.
 	"io"
-	"io/ioutil"
-	"path"
+	"io/fs"
+	"math"
+	"os"
+	"sort"
+	"time"
 
 	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/imagecodec"
 	pigo "github.com/esimov/pigo/core"
-	"github.com/fogleman/gg"
 )
 
-const (
-	minSize                  = 20
-	maxSize                  = 1000
-	shiftFactor              = 0.1
-	scaleFactor              = 1.1
-	angle                    = 0.0
-	ioUThreshold             = 0.2
-	perturbs                 = 63
-	featuresQualityThreshold = 5.0
-)
+// Version identifies the detection algorithm of PigoFaceDetector and the pigo release it's built on.
+const Version = "pigo-1.4.2"
 
 var (
+	// ErrInvalidCascade is returned by PigoFaceDetector.LoadCascadesFS when a cascade file is malformed.
+	ErrInvalidCascade = fmt.Errorf("invalid cascade")
+
 	mouthCascade = []string{"lp93", "lp84", "lp82", "lp81"}
+
+	// landmarkCascades names the points located by the landmark cascades, grouped as in pigo:
+	// lp46 to lp312 are eye cascades, lp93 to lp81 mouth ones. Paired cascades are run once more
+	// mirrored, to locate the same point on the right side of the face.
+	landmarkCascades = []struct {
+		cascade string
+		name    string
+		paired  bool
+	}{
+		{cascade: "lp46", name: "eyebrow_outer", paired: true},
+		{cascade: "lp44", name: "eyebrow_middle", paired: true},
+		{cascade: "lp42", name: "eyebrow_inner", paired: true},
+		{cascade: "lp312", name: "eye_outer_corner", paired: true},
+		{cascade: "lp38", name: "eye_inner_corner", paired: true},
+		{cascade: "lp93", name: "upper_lip", paired: false},
+		{cascade: "lp84", name: "mouth_corner", paired: true},
+		{cascade: "lp81", name: "mouth_center", paired: false},
+		{cascade: "lp82", name: "lower_lip", paired: false},
+	}
 )
 
 // PigoFaceDetector is face detector implementation based on pigo.
 type PigoFaceDetector struct {
 	classifier *pigo.Pigo
+	cascade    *faceCascade
 	plc        *pigo.PuplocCascade
 	flpcs      map[string][]*pigo.FlpCascade
 }
@@ -42,9 +59,25 @@ func NewPigoFaceDetector() *PigoFaceDetector {
 	return &PigoFaceDetector{}
 }
 
-// LoadCascades loads binary cascade files required by pigo.
+// LoadCascades loads binary cascade files required by pigo from cascadeDir.
 func (pfd *PigoFaceDetector) LoadCascades(cascadeDir string) error {
-	cascadeFile, err := ioutil.ReadFile(path.Join(cascadeDir, "facefinder"))
+	return pfd.LoadCascadesFS(os.DirFS(cascadeDir))
+}
+
+// LoadCascadesFS loads binary cascade files required by pigo from the root of fsys. Use
+// DefaultCascades to load the cascades embedded in the binary.
+//
+// Malformed cascades are reported with ErrInvalidCascade. The detector is left unchanged when
+// loading fails.
+func (pfd *PigoFaceDetector) LoadCascadesFS(fsys fs.FS) (err error) {
+	// pigo panics when unpacking malformed cascades.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrInvalidCascade, r)
+		}
+	}()
+
+	cascadeFile, err := fs.ReadFile(fsys, "facefinder")
 	if err != nil {
 		return err
 	}
@@ -52,89 +85,166 @@ func (pfd *PigoFaceDetector) LoadCascades(cascadeDir string) error {
 	p := pigo.NewPigo()
 	// Unpack the binary file. This will return the number of cascade trees,
 	// the tree depth, the threshold and the prediction from tree's leaf nodes.
-	pfd.classifier, err = p.Unpack(cascadeFile)
+	classifier, err := p.Unpack(cascadeFile)
+	if err != nil {
+		return err
+	}
+	fc, err := unpackFaceCascade(cascadeFile)
 	if err != nil {
 		return err
 	}
 
 	pl := pigo.NewPuplocCascade()
 
-	cascade, err := ioutil.ReadFile(path.Join(cascadeDir, "puploc"))
+	cascade, err := fs.ReadFile(fsys, "puploc")
 	if err != nil {
 		return err
 	}
-	pfd.plc, err = pl.UnpackCascade(cascade)
+	plc, err := pl.UnpackCascade(cascade)
 	if err != nil {
 		return err
 	}
 
-	pfd.flpcs, err = pl.ReadCascadeDir(path.Join(cascadeDir, "lps"))
+	flpcs, err := readCascadeDir(pl, fsys, "lps")
 	if err != nil {
 		return err
 	}
+
+	pfd.classifier, pfd.cascade, pfd.plc, pfd.flpcs = classifier, fc, plc, flpcs
 	return nil
 }
 
 // DetectFaces analyzes image provided by img parameter and
 // returns slice of detected faces with facial features.
-func (pfd PigoFaceDetector) DetectFaces(ctx context.Context, img io.Reader) ([]facedetect.Face, error) {
-	_ = ctx // Reserved for possible future use.
+//
+// With opts.AllFrames, every frame of an animated image is analyzed separately.
+//
+// The context is checked while decoding the image, between the rows of the cascade scans and
+// before locating the facial features of each face. Once it's done, DetectFaces returns ctx.Err().
+func (pfd PigoFaceDetector) DetectFaces(ctx context.Context, img io.Reader, opts facedetect.DetectOptions) (*facedetect.Result, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	r := &contextReader{ctx: ctx, r: img}
 
-	var dc *gg.Context
-	var imgParams *pigo.ImageParams
+	if !opts.AllFrames {
+		decoded, err := imagecodec.Decode(r)
+		if err != nil {
+			return nil, facedetect.DecodeError(ctx, err)
+		}
+		return pfd.detectDecoded(ctx, decoded, opts)
+	}
 
-	src, err := pigo.DecodeImage(img)
+	anim, err := imagecodec.DecodeAll(r, opts.MaxFrames, opts.MaxFramesPixels)
 	if err != nil {
-		if err == image.ErrFormat {
-			return nil, facedetect.ErrImageError
+		return nil, facedetect.DecodeError(ctx, err)
+	}
+	result := &facedetect.Result{Format: anim.Format, Orientation: int(anim.Orientation)}
+	for i, frame := range anim.Frames {
+		faces, err := pfd.detect(ctx, frame.Image, anim.Orientation, opts)
+		if err != nil {
+			return nil, err
 		}
-		return nil, err
+		result.Frames = append(result.Frames, facedetect.FrameFaces{
+			Index: i,
+			Delay: int(frame.Delay / time.Millisecond),
+			Faces: faces,
+		})
 	}
+	result.Faces = result.Frames[0].Faces
+	return result, nil
+}
 
-	pixels := pigo.RgbToGrayscale(src)
-	cols, rows := src.Bounds().Max.X, src.Bounds().Max.Y
+// DetectDecodedFaces analyzes the image decoded by the caller, the same way as DetectFaces does
+// with a single frame.
+func (pfd PigoFaceDetector) DetectDecodedFaces(ctx context.Context, img *imagecodec.Image, opts facedetect.DetectOptions) (*facedetect.Result, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return pfd.detectDecoded(ctx, img, opts)
+}
 
-	dc = gg.NewContext(cols, rows)
-	dc.DrawImage(src, 0, 0)
+func (pfd PigoFaceDetector) detectDecoded(ctx context.Context, img *imagecodec.Image, opts facedetect.DetectOptions) (*facedetect.Result, error) {
+	faces, err := pfd.detect(ctx, img.Image, img.Orientation, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &facedetect.Result{Faces: faces, Format: img.Format, Orientation: int(img.Orientation)}, nil
+}
 
-	imgParams = &pigo.ImageParams{
+// detect finds the faces on a decoded image, displayed with orientation o.
+func (pfd PigoFaceDetector) detect(ctx context.Context, img image.Image, o imagecodec.Orientation, opts facedetect.DetectOptions) ([]facedetect.Face, error) {
+	src := pigo.ImgToNRGBA(img)
+	pixels, err := grayscale(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	srcCols, srcRows := src.Bounds().Dx(), src.Bounds().Dy()
+	srcParams := &pigo.ImageParams{
 		Pixels: pixels,
-		Rows:   rows,
-		Cols:   cols,
-		Dim:    cols,
+		Rows:   srcRows,
+		Cols:   srcCols,
+		Dim:    srcCols,
 	}
 
+	// Large images are scanned at a lower working resolution, the detections are mapped back.
+	imgParams := srcParams
+	cols, rows := workingSize(srcCols, srcRows, opts.MaxPixels)
+	if cols != srcCols || rows != srcRows {
+		working, err := downscale(ctx, pixels, srcCols, srcRows, cols, rows)
+		if err != nil {
+			return nil, err
+		}
+		imgParams = &pigo.ImageParams{
+			Pixels: working,
+			Rows:   rows,
+			Cols:   cols,
+			Dim:    cols,
+		}
+	}
+	sx, sy := float64(srcCols)/float64(cols), float64(srcRows)/float64(rows)
+	minSize, maxSize := faceSizeRange(opts, cols, rows, sx)
+
 	cParams := pigo.CascadeParams{
 		MinSize:     minSize,
 		MaxSize:     maxSize,
-		ShiftFactor: shiftFactor,
-		ScaleFactor: scaleFactor,
+		ShiftFactor: opts.ShiftFactor,
+		ScaleFactor: opts.ScaleFactor,
 		ImageParams: *imgParams,
 	}
 
-	// Run the classifier over the obtained leaf nodes and return the detection results.
-	// The result contains quadruplets representing the row, column, scale and detection score.
-	faces := pfd.classifier.RunCascade(cParams, angle)
-
-	// Calculate the intersection over union (IoU) of two clusters.
-	faces = pfd.classifier.ClusterDetections(faces, ioUThreshold)
+	faces, err := pfd.runCascade(ctx, cParams, opts)
+	if err != nil {
+		return nil, err
+	}
 
 	var outFaces []facedetect.Face
 
 	for _, face := range faces {
-		if face.Q < 20 {
+		if face.Q < opts.QualityThreshold {
 			continue
 		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// Facial features are located on the original image, where faces found at a lower
+		// working resolution keep their details.
+		det := scaleDetection(face.Detection, sx, sy)
 		outFace := facedetect.Face{
 			Bounds: &facedetect.Bounds{
-				X:      face.Col - face.Scale/2,
-				Y:      face.Row - face.Scale/2,
-				Height: face.Scale,
-				Width:  face.Scale,
+				X:      det.Col - det.Scale/2,
+				Y:      det.Row - det.Scale/2,
+				Height: det.Scale,
+				Width:  det.Scale,
 			},
+			Score: face.Q,
+			Angle: face.angle,
 		}
-		if face.Q > featuresQualityThreshold && face.Scale > 50 {
-			leftEye, rightEye := detectEyes(pfd.plc, face, imgParams)
+		// Pupil and landmark localization in pigo does not produce a confidence score,
+		// so facial features are reported without one.
+		if face.Q > opts.FeaturesQualityThreshold && det.Scale > 50 {
+			a := cascadeAngle(face.angle)
+			leftEye, rightEye := detectEyes(pfd.plc, det, srcParams, opts.Perturbs, a)
 			if leftEye != nil {
 				outFace.LeftEye = &facedetect.Point{
 					X: leftEye.Col,
@@ -147,10 +257,16 @@ func (pfd PigoFaceDetector) DetectFaces(ctx context.Context, img io.Reader) ([]f
 					Y: rightEye.Row,
 				}
 			}
-			m := detectMouth(pfd.flpcs, leftEye, rightEye, imgParams)
+			m := detectMouth(pfd.flpcs, leftEye, rightEye, srcParams, opts.Perturbs, a)
 			if m != nil {
 				outFace.Mouth = m
 			}
+			if opts.FullLandmarks {
+				outFace.Landmarks = detectLandmarks(pfd.flpcs, leftEye, rightEye, srcParams, opts.Perturbs, a)
+			}
+		}
+		if opts.Frame == facedetect.FrameStored {
+			storedFace(&outFace, o, srcCols, srcRows)
 		}
 		outFaces = append(outFaces, outFace)
 	}
@@ -158,38 +274,226 @@ func (pfd PigoFaceDetector) DetectFaces(ctx context.Context, img io.Reader) ([]f
 	return outFaces, nil
 }
 
-func detectEyes(plc *pigo.PuplocCascade, face pigo.Detection, imgParams *pigo.ImageParams) (*pigo.Puploc, *pigo.Puploc) {
+// detection is a pigo detection found with the image rotated by angle degrees.
+type detection struct {
+	pigo.Detection
+	angle float64
+}
+
+// runCascade scans the image once for every angle in opts.Angles and merges the detections.
+// Detections overlapping across the angles are merged into the one with the highest score.
+func (pfd PigoFaceDetector) runCascade(ctx context.Context, cParams pigo.CascadeParams, opts facedetect.DetectOptions) ([]detection, error) {
+	angles := opts.Angles
+	if len(angles) == 0 {
+		angles = []float64{0}
+	}
+
+	var dets []detection
+	for _, deg := range angles {
+		// Run the classifier over the obtained leaf nodes and return the detection results.
+		// The result contains quadruplets representing the row, column, scale and detection score.
+		faces, err := pfd.runCascadeScales(ctx, cParams, cascadeAngle(deg))
+		if err != nil {
+			return nil, err
+		}
+
+		// Calculate the intersection over union (IoU) of two clusters.
+		faces = pfd.classifier.ClusterDetections(faces, opts.IoUThreshold)
+
+		for _, f := range faces {
+			dets = append(dets, detection{Detection: f, angle: deg})
+		}
+	}
+	if len(angles) == 1 {
+		return dets, nil
+	}
+	return suppressOverlapping(dets, opts.IoUThreshold), nil
+}
+
+// runCascadeScales is pigo's RunCascade, checking the context before every row of windows.
+func (pfd PigoFaceDetector) runCascadeScales(ctx context.Context, cParams pigo.CascadeParams, a float64) ([]pigo.Detection, error) {
+	var faces []pigo.Detection
+	if a > 1.0 {
+		a = 1.0
+	}
+	for scale := cParams.MinSize; scale <= cParams.MaxSize; scale = int(float64(scale) * cParams.ScaleFactor) {
+		step := int(math.Max(cParams.ShiftFactor*float64(scale), 1))
+		offset := scale/2 + 1
+
+		for row := offset; row <= cParams.Rows-offset; row += step {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			for col := offset; col <= cParams.Cols-offset; col += step {
+				var q float32
+				if a > 0.0 {
+					q = pfd.cascade.classifyRotatedRegion(row, col, scale, a, cParams.Rows, cParams.Pixels, cParams.Dim)
+				} else {
+					q = pfd.cascade.classifyRegion(row, col, scale, cParams.Pixels, cParams.Dim)
+				}
+				if q > 0.0 {
+					faces = append(faces, pigo.Detection{Row: row, Col: col, Scale: scale, Q: q})
+				}
+			}
+		}
+	}
+	return faces, nil
+}
+
+// grayscale is pigo's RgbToGrayscale that checks the context after every row.
+//
+// Any color model is accepted, including paletted, CMYK and 16-bit images, although callers convert
+// to NRGBA first so that all of them are reduced to 8 bits per channel the same way. Transparent
+// pixels are composited over a white background, so that transparent regions don't turn black.
+func grayscale(ctx context.Context, src image.Image) ([]uint8, error) {
+	b := src.Bounds()
+	cols, rows := b.Dx(), b.Dy()
+	gray := make([]uint8, rows*cols)
+
+	for y := 0; y < rows; y++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for x := 0; x < cols; x++ {
+			// RGBA returns alpha-premultiplied 16-bit values.
+			r, g, b, a := src.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			bg := 0xffff - a
+			r, g, b = r+bg, g+bg, b+bg
+			gray[y*cols+x] = uint8(
+				(0.299*float64(r) +
+					0.587*float64(g) +
+					0.114*float64(b)) / 256,
+			)
+		}
+	}
+	return gray, nil
+}
+
+// contextReader fails the reads once the context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// suppressOverlapping keeps the best scored detection out of every group of detections that
+// overlap by more than iouThreshold.
+func suppressOverlapping(dets []detection, iouThreshold float64) []detection {
+	sort.SliceStable(dets, func(i, j int) bool {
+		return dets[i].Q > dets[j].Q
+	})
+	var kept []detection
+	for _, d := range dets {
+		overlaps := false
+		for _, k := range kept {
+			if iou(d.Detection, k.Detection) > iouThreshold {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+// iou returns the intersection over union of two square detections.
+func iou(d1, d2 pigo.Detection) float64 {
+	r1, c1, s1 := float64(d1.Row), float64(d1.Col), float64(d1.Scale)
+	r2, c2, s2 := float64(d2.Row), float64(d2.Col), float64(d2.Scale)
+
+	overRow := math.Max(0, math.Min(r1+s1/2, r2+s2/2)-math.Max(r1-s1/2, r2-s2/2))
+	overCol := math.Max(0, math.Min(c1+s1/2, c2+s2/2)-math.Max(c1-s1/2, c2-s2/2))
+
+	return overRow * overCol / (s1*s1 + s2*s2 - overRow*overCol)
+}
+
+// cascadeAngle converts degrees to the angle representation used by pigo, a fraction of the
+// full turn in range [0, 1).
+func cascadeAngle(deg float64) float64 {
+	a := math.Mod(deg, 360) / 360
+	if a < 0 {
+		a++
+	}
+	return a
+}
+
+// rotate rotates the (row, col) offset, given relative to an upright face, the same way pigo
+// rotates its cascades for angle a.
+func rotate(dRow, dCol, a float64) (float64, float64) {
+	if a == 0 {
+		return dRow, dCol
+	}
+	sin, cos := math.Sincos(2 * math.Pi * a)
+	return dRow*cos - dCol*sin, dRow*sin + dCol*cos
+}
+
+func detectEyes(plc *pigo.PuplocCascade, face pigo.Detection, imgParams *pigo.ImageParams, perturbs int, a float64) (*pigo.Puploc, *pigo.Puploc) {
 	// Left eye
+	dRow, dCol := rotate(float64(-0.075*float32(face.Scale)), float64(-0.175*float32(face.Scale)), a)
 	puploc := &pigo.Puploc{
-		Row:      face.Row - int(0.075*float32(face.Scale)),
-		Col:      face.Col - int(0.175*float32(face.Scale)),
+		Row:      face.Row + int(dRow),
+		Col:      face.Col + int(dCol),
 		Scale:    float32(face.Scale) * 0.25,
 		Perturbs: perturbs,
 	}
-	leftEye := plc.RunDetector(*puploc, *imgParams, angle, false)
+	leftEye := plc.RunDetector(*puploc, *imgParams, a, false)
 
 	// Right eye
+	dRow, dCol = rotate(float64(-0.075*float32(face.Scale)), float64(0.185*float32(face.Scale)), a)
 	puploc = &pigo.Puploc{
-		Row:      face.Row - int(0.075*float32(face.Scale)),
-		Col:      face.Col + int(0.185*float32(face.Scale)),
+		Row:      face.Row + int(dRow),
+		Col:      face.Col + int(dCol),
 		Scale:    float32(face.Scale) * 0.25,
 		Perturbs: perturbs,
 	}
-	rightEye := plc.RunDetector(*puploc, *imgParams, angle, false)
+	rightEye := plc.RunDetector(*puploc, *imgParams, a, false)
 
 	return leftEye, rightEye
 }
 
-func detectMouth(flpcs map[string][]*pigo.FlpCascade, leftEye *pigo.Puploc, rightEye *pigo.Puploc, imgParams *pigo.ImageParams) *facedetect.Point {
-	mouthMinX := maxSize
+// findLandmarkPoint is pigo's FlpCascade.FindLandmarkPoints with support for rotated faces.
+func findLandmarkPoint(flpc *pigo.FlpCascade, leftEye, rightEye *pigo.Puploc, imgParams *pigo.ImageParams, perturbs int, a float64, flipV bool) *pigo.Puploc {
+	dist1 := (leftEye.Row - rightEye.Row) * (leftEye.Row - rightEye.Row)
+	dist2 := (leftEye.Col - rightEye.Col) * (leftEye.Col - rightEye.Col)
+	dist := math.Sqrt(float64(dist1 + dist2))
+
+	dRow, dCol := rotate(0.25*dist, 0.15*dist, a)
+	row := float64(leftEye.Row+rightEye.Row)/2.0 + dRow
+	col := float64(leftEye.Col+rightEye.Col)/2.0 + dCol
+
+	flploc := pigo.Puploc{
+		Row:      int(row),
+		Col:      int(col),
+		Scale:    float32(3.0 * dist),
+		Perturbs: perturbs,
+	}
+	return flpc.RunDetector(flploc, *imgParams, a, flipV)
+}
+
+func detectMouth(flpcs map[string][]*pigo.FlpCascade, leftEye *pigo.Puploc, rightEye *pigo.Puploc, imgParams *pigo.ImageParams, perturbs int, a float64) *facedetect.Point {
+	found := false
+	mouthMinX := 0
 	mouthMaxX := 0
-	mouthMinY := maxSize
+	mouthMinY := 0
 	mouthMaxY := 0
 
 	for _, mouth := range mouthCascade {
 		for _, flpc := range flpcs[mouth] {
-			flp := flpc.FindLandmarkPoints(leftEye, rightEye, *imgParams, perturbs, false)
+			flp := findLandmarkPoint(flpc, leftEye, rightEye, imgParams, perturbs, a, false)
 			if flp.Row > 0 && flp.Col > 0 {
+				if !found {
+					found = true
+					mouthMinX, mouthMaxX = flp.Col, flp.Col
+					mouthMinY, mouthMaxY = flp.Row, flp.Row
+				}
 				if flp.Col > mouthMaxX {
 					mouthMaxX = flp.Col
 				}
@@ -206,7 +510,7 @@ func detectMouth(flpcs map[string][]*pigo.FlpCascade, leftEye *pigo.Puploc, righ
 		}
 	}
 
-	if mouthMinX == maxSize {
+	if !found {
 		return nil
 	}
 	mx := mouthMinX + (mouthMaxX-mouthMinX)/2
@@ -216,3 +520,31 @@ func detectMouth(flpcs map[string][]*pigo.FlpCascade, leftEye *pigo.Puploc, righ
 		Y: my,
 	}
 }
+
+func detectLandmarks(flpcs map[string][]*pigo.FlpCascade, leftEye *pigo.Puploc, rightEye *pigo.Puploc, imgParams *pigo.ImageParams, perturbs int, a float64) map[string]*facedetect.Point {
+	landmarks := make(map[string]*facedetect.Point)
+	add := func(name string, flp *pigo.Puploc) {
+		if flp.Row > 0 && flp.Col > 0 {
+			landmarks[name] = &facedetect.Point{
+				X: flp.Col,
+				Y: flp.Row,
+			}
+		}
+	}
+
+	for _, lc := range landmarkCascades {
+		for _, flpc := range flpcs[lc.cascade] {
+			if !lc.paired {
+				add(lc.name, findLandmarkPoint(flpc, leftEye, rightEye, imgParams, perturbs, a, false))
+				continue
+			}
+			add("left_"+lc.name, findLandmarkPoint(flpc, leftEye, rightEye, imgParams, perturbs, a, false))
+			add("right_"+lc.name, findLandmarkPoint(flpc, rightEye, leftEye, imgParams, perturbs, a, true))
+		}
+	}
+
+	if len(landmarks) == 0 {
+		return nil
+	}
+	return landmarks
+}
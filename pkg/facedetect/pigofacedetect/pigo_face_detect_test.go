@@ -1,17 +1,31 @@
 package pigofacedetect
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/imagecodec"
+	pigo "github.com/esimov/pigo/core"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
 )
 
 var (
@@ -51,11 +65,15 @@ func TestPigoFaceDetect_DetectFaces(t *testing.T) {
 				t.Error(err)
 			}
 
-			faces, err := pfd.DetectFaces(context.Background(), f)
+			result, err := pfd.DetectFaces(context.Background(), f, facedetect.DefaultDetectOptions())
 			if (err != nil) != tt.wantErr {
 				t.Errorf("DetectFaces() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			var faces []facedetect.Face
+			if result != nil {
+				faces = result.Faces
+			}
 			got, err := json.Marshal(&faces)
 			if err != nil {
 				t.Fatalf("unable to marshal response: %v", err)
@@ -90,9 +108,400 @@ func TestPigoFaceDetect_DetectFacesWithInvalidFormat(t *testing.T) {
 	if err := pfd.LoadCascades("cascades"); err != nil {
 		t.Fatal(err)
 	}
-	_, err := pfd.DetectFaces(context.Background(), strings.NewReader("foo"))
+	_, err := pfd.DetectFaces(context.Background(), strings.NewReader("foo"), facedetect.DefaultDetectOptions())
 	if err != facedetect.ErrImageError {
 		t.Error("detect faces should return facedetect.ErrImageError when unsupported image format is supplied")
 	}
 
 }
+
+func TestPigoFaceDetect_DetectFacesWithInvalidOptions(t *testing.T) {
+	pfd := NewPigoFaceDetector()
+	if err := pfd.LoadCascades("cascades"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path.Join("testdata", "people002.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	opts := facedetect.DefaultDetectOptions()
+	opts.ScaleFactor = 1
+	_, err = pfd.DetectFaces(context.Background(), f, opts)
+	if !errors.Is(err, facedetect.ErrInvalidDetectOptions) {
+		t.Errorf("detect faces should return facedetect.ErrInvalidDetectOptions for invalid options, got: %v", err)
+	}
+}
+
+func TestPigoFaceDetect_DetectFacesRotated(t *testing.T) {
+	pfd := NewPigoFaceDetector()
+	if err := pfd.LoadCascades("cascades"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path.Join("testdata", "people002.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate the image 90 degrees clockwise.
+	b := src.Bounds()
+	rotated := image.NewRGBA(image.Rect(0, 0, b.Dy(), b.Dx()))
+	for y := 0; y < b.Dx(); y++ {
+		for x := 0; x < b.Dy(); x++ {
+			rotated.Set(x, y, src.At(b.Min.X+y, b.Max.Y-1-x))
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, rotated); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := facedetect.DefaultDetectOptions()
+	result, err := pfd.DetectFaces(context.Background(), bytes.NewReader(buf.Bytes()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	faces := result.Faces
+	if len(faces) != 0 {
+		t.Errorf("upright scan should not find rotated faces, got %d", len(faces))
+	}
+
+	opts.Angles = []float64{-90, 0, 90}
+	result, err = pfd.DetectFaces(context.Background(), bytes.NewReader(buf.Bytes()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	faces = result.Faces
+	if len(faces) != 3 {
+		t.Errorf("multi-angle scan should find 3 faces, got %d", len(faces))
+	}
+	for _, face := range faces {
+		if face.Angle != -90 {
+			t.Errorf("faces should be reported at -90 degrees, got %v", face.Angle)
+		}
+		if face.LeftEye == nil || face.RightEye == nil {
+			t.Error("eyes should be located on rotated faces")
+			continue
+		}
+		dx, dy := face.LeftEye.X-face.RightEye.X, face.LeftEye.Y-face.RightEye.Y
+		if dx*dx >= dy*dy {
+			t.Errorf("eyes of a face rotated by 90 degrees should be above each other, got %v and %v", face.LeftEye, face.RightEye)
+		}
+	}
+}
+
+func TestPigoFaceDetect_DetectFacesOriented(t *testing.T) {
+	pfd := NewPigoFaceDetector()
+	if err := pfd.LoadCascades("cascades"); err != nil {
+		t.Fatal(err)
+	}
+	// people002exif6.jpg is people002.jpg stored rotated 90 degrees counter-clockwise
+	// with an EXIF orientation of 6, so it displays upright.
+	img, err := ioutil.ReadFile(path.Join("testdata", "people002exif6.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := facedetect.DefaultDetectOptions()
+	// Filter out a weak false positive introduced by re-encoding.
+	opts.QualityThreshold = 30
+	displayed, err := pfd.DetectFaces(context.Background(), bytes.NewReader(img), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if displayed.Orientation != 6 {
+		t.Errorf("DetectFaces() orientation = %d, want 6", displayed.Orientation)
+	}
+	if len(displayed.Faces) != 3 {
+		t.Fatalf("DetectFaces() should find 3 upright faces, got %d", len(displayed.Faces))
+	}
+
+	opts.Frame = facedetect.FrameStored
+	stored, err := pfd.DetectFaces(context.Background(), bytes.NewReader(img), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Faces) != len(displayed.Faces) {
+		t.Fatalf("frame should not change the number of faces, got %d and %d", len(stored.Faces), len(displayed.Faces))
+	}
+	for i, face := range stored.Faces {
+		d := displayed.Faces[i]
+		// Displayed (x, y) maps to stored (y, W-1-x) for orientation 6.
+		if face.Bounds.X != d.Bounds.Y || face.Bounds.Width != d.Bounds.Height || face.Bounds.Height != d.Bounds.Width {
+			t.Errorf("stored bounds %+v do not match displayed bounds %+v", face.Bounds, d.Bounds)
+		}
+		if face.Angle != 90 {
+			t.Errorf("upright faces should be reported at 90 degrees in the stored frame, got %v", face.Angle)
+		}
+	}
+}
+
+func TestPigoFaceDetect_DetectFacesFormats(t *testing.T) {
+	pfd := NewPigoFaceDetector()
+	if err := pfd.LoadCascades("cascades"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path.Join("testdata", "people002.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Gray palette keeps the GIF close to the original luminance.
+	grays := make(color.Palette, 256)
+	for i := range grays {
+		grays[i] = color.Gray{Y: uint8(i)}
+	}
+	tests := []struct {
+		format string
+		encode func(io.Writer, image.Image) error
+	}{
+		{format: "gif", encode: func(w io.Writer, m image.Image) error {
+			b := m.Bounds()
+			p := image.NewPaletted(b, grays)
+			draw.Draw(p, b, m, b.Min, draw.Src)
+			return gif.Encode(w, p, nil)
+		}},
+		{format: "bmp", encode: bmp.Encode},
+		{format: "tiff", encode: func(w io.Writer, m image.Image) error { return tiff.Encode(w, m, nil) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := tt.encode(buf, src); err != nil {
+				t.Fatal(err)
+			}
+			opts := facedetect.DefaultDetectOptions()
+			// Filter out a weak false positive introduced by re-encoding.
+			opts.QualityThreshold = 30
+			result, err := pfd.DetectFaces(context.Background(), buf, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Format != tt.format {
+				t.Errorf("DetectFaces() format = %q, want %q", result.Format, tt.format)
+			}
+			if len(result.Faces) != 3 {
+				t.Errorf("DetectFaces() should find 3 faces, got %d", len(result.Faces))
+			}
+		})
+	}
+}
+
+func TestPigoFaceDetect_DetectFacesAllFrames(t *testing.T) {
+	pfd := NewPigoFaceDetector()
+	if err := pfd.LoadCascades("cascades"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path.Join("testdata", "people002.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An animation of the photo followed by a blank frame.
+	grays := make(color.Palette, 256)
+	for i := range grays {
+		grays[i] = color.Gray{Y: uint8(i)}
+	}
+	b := src.Bounds()
+	photo := image.NewPaletted(b, grays)
+	draw.Draw(photo, b, src, b.Min, draw.Src)
+	blank := image.NewPaletted(b, grays)
+	g := &gif.GIF{Image: []*image.Paletted{photo, blank}, Delay: []int{50, 100}}
+	buf := &bytes.Buffer{}
+	if err := gif.EncodeAll(buf, g); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := facedetect.DefaultDetectOptions()
+	opts.QualityThreshold = 30
+	result, err := pfd.DetectFaces(context.Background(), bytes.NewReader(buf.Bytes()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Frames) != 0 || len(result.Faces) != 3 {
+		t.Errorf("DetectFaces() should analyze only the first frame by default, got %d frames and %d faces", len(result.Frames), len(result.Faces))
+	}
+
+	opts.AllFrames = true
+	result, err = pfd.DetectFaces(context.Background(), bytes.NewReader(buf.Bytes()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Frames) != 2 {
+		t.Fatalf("DetectFaces() should return results for 2 frames, got %d", len(result.Frames))
+	}
+	for i, want := range []facedetect.FrameFaces{{Index: 0, Delay: 500}, {Index: 1, Delay: 1000}} {
+		got := result.Frames[i]
+		if got.Index != want.Index || got.Delay != want.Delay {
+			t.Errorf("frame %d index = %d and delay = %d, want %d and %d", i, got.Index, got.Delay, want.Index, want.Delay)
+		}
+	}
+	if len(result.Frames[0].Faces) != 3 || len(result.Frames[1].Faces) != 0 {
+		t.Errorf("DetectFaces() should find 3 faces on the first frame and none on the second, got %d and %d", len(result.Frames[0].Faces), len(result.Frames[1].Faces))
+	}
+	if !reflect.DeepEqual(result.Faces, result.Frames[0].Faces) {
+		t.Error("DetectFaces() should report the faces of the first frame in Faces")
+	}
+
+	opts.MaxFrames = 1
+	_, err = pfd.DetectFaces(context.Background(), bytes.NewReader(buf.Bytes()), opts)
+	if err != facedetect.ErrAnimationTooLarge {
+		t.Errorf("DetectFaces() should return facedetect.ErrAnimationTooLarge, got: %v", err)
+	}
+}
+
+func Test_grayscale(t *testing.T) {
+	// Every image holds one pixel of each: white, 50% gray, black and fully transparent.
+	pal := color.Palette{color.White, color.Gray{Y: 0x80}, color.Black, color.Transparent}
+	paletted := image.NewPaletted(image.Rect(0, 0, 4, 1), pal)
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+	gray16 := image.NewRGBA64(image.Rect(0, 0, 4, 1))
+	cmyk := image.NewCMYK(image.Rect(0, 0, 4, 1))
+	for x, c := range pal {
+		paletted.SetColorIndex(x, 0, uint8(x))
+		nrgba.Set(x, 0, c)
+		gray16.Set(x, 0, c)
+		cmyk.Set(x, 0, c)
+	}
+	// CMYK has no alpha, its transparent pixel is white.
+	cmyk.Set(3, 0, color.White)
+
+	want := []uint8{0xff, 0x80, 0x00, 0xff}
+	for _, img := range []image.Image{paletted, nrgba, gray16, cmyk} {
+		got, err := grayscale(context.Background(), pigo.ImgToNRGBA(img))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range want {
+			// Allow rounding differences between the color models.
+			if d := int(got[i]) - int(want[i]); d < -1 || d > 1 {
+				t.Errorf("grayscale(%T) = %v, want %v", img, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestPigoFaceDetect_DetectFacesFullLandmarks(t *testing.T) {
+	pfd := NewPigoFaceDetector()
+	if err := pfd.LoadCascades("cascades"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path.Join("testdata", "people002.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	opts := facedetect.DefaultDetectOptions()
+	opts.FullLandmarks = true
+	result, err := pfd.DetectFaces(context.Background(), f, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	faces := result.Faces
+	if len(faces) == 0 {
+		t.Fatal("expected faces to be detected")
+	}
+
+	want := []string{"left_eyebrow_outer", "right_eyebrow_outer", "left_eye_inner_corner", "mouth_center", "left_mouth_corner", "right_mouth_corner", "upper_lip", "lower_lip"}
+	for _, face := range faces {
+		for _, name := range want {
+			if _, ok := face.Landmarks[name]; !ok {
+				t.Errorf("landmark %s missing, got %v", name, face.Landmarks)
+			}
+		}
+		if face.Landmarks["left_mouth_corner"].X >= face.Landmarks["right_mouth_corner"].X {
+			t.Error("left mouth corner should be left of the right one")
+		}
+		if face.Landmarks["upper_lip"].Y >= face.Landmarks["mouth_center"].Y || face.Landmarks["mouth_center"].Y >= face.Landmarks["lower_lip"].Y {
+			t.Error("upper lip, mouth center and lower lip should go downwards")
+		}
+	}
+}
+
+func TestPigoFaceDetect_DetectFacesCancellation(t *testing.T) {
+	pfd := NewPigoFaceDetector()
+	if err := pfd.LoadCascades("cascades"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A large noise image takes seconds to scan at all scales.
+	src := image.NewGray(image.Rect(0, 0, 2000, 2000))
+	rnd := rand.New(rand.NewSource(1))
+	_, _ = rnd.Read(src.Pix)
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, src); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, timeout := range []time.Duration{time.Millisecond, 100 * time.Millisecond, 300 * time.Millisecond} {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		start := time.Now()
+		_, err := pfd.DetectFaces(ctx, bytes.NewReader(buf.Bytes()), facedetect.DefaultDetectOptions())
+		took := time.Since(start)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Errorf("DetectFaces() should return context.DeadlineExceeded, got: %v", err)
+		}
+		// The bound is loose for slow runs, e.g. with the race detector, a full scan takes far longer.
+		if took > timeout+5*time.Second {
+			t.Errorf("DetectFaces() should return shortly after context ends, took %v with timeout %v", took, timeout)
+		}
+	}
+}
+
+func TestPigoFaceDetect_DetectDecodedFaces(t *testing.T) {
+	pfd := NewPigoFaceDetector()
+	if err := pfd.LoadCascades("cascades"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path.Join("testdata", "people002.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := imagecodec.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := facedetect.DefaultDetectOptions()
+	want, err := pfd.DetectFaces(context.Background(), bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := pfd.DetectDecodedFaces(context.Background(), decoded, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Format != "jpeg" || len(got.Faces) != len(want.Faces) {
+		t.Fatalf("DetectDecodedFaces() found %d faces on %q image, want %d on jpeg", len(got.Faces), got.Format, len(want.Faces))
+	}
+	// Pupil localization is randomized, only the detections are compared.
+	for i := range want.Faces {
+		if *got.Faces[i].Bounds != *want.Faces[i].Bounds || got.Faces[i].Score != want.Faces[i].Score {
+			t.Errorf("face %d = %+v with score %v, want %+v with score %v", i, *got.Faces[i].Bounds, got.Faces[i].Score, *want.Faces[i].Bounds, want.Faces[i].Score)
+		}
+	}
+
+	opts.MinSize = 0
+	if _, err := pfd.DetectDecodedFaces(context.Background(), decoded, opts); !errors.Is(err, facedetect.ErrInvalidDetectOptions) {
+		t.Errorf("DetectDecodedFaces() should validate the options, got: %v", err)
+	}
+}
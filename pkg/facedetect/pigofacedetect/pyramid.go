@@ -0,0 +1,80 @@
+package pigofacedetect
+
+import (
+	"context"
+	"math"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+	pigo "github.com/esimov/pigo/core"
+)
+
+// workingSize returns the dimensions of the image the cascades are run on. Images with more
+// pixels than maxPixels are scaled down, keeping the aspect ratio.
+func workingSize(cols, rows, maxPixels int) (int, int) {
+	if maxPixels <= 0 || cols*rows <= maxPixels {
+		return cols, rows
+	}
+	f := math.Sqrt(float64(maxPixels) / float64(cols*rows))
+	wCols := int(math.Max(1, math.Floor(float64(cols)*f)))
+	wRows := int(math.Max(1, math.Floor(float64(rows)*f)))
+	return wCols, wRows
+}
+
+// downscale resizes the grayscale pixels to dstCols x dstRows by averaging the source pixels
+// covered by each destination pixel.
+func downscale(ctx context.Context, pixels []uint8, cols, rows, dstCols, dstRows int) ([]uint8, error) {
+	dst := make([]uint8, dstCols*dstRows)
+	for y := 0; y < dstRows; y++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		y0, y1 := y*rows/dstRows, (y+1)*rows/dstRows
+		for x := 0; x < dstCols; x++ {
+			x0, x1 := x*cols/dstCols, (x+1)*cols/dstCols
+			sum := 0
+			for sy := y0; sy < y1; sy++ {
+				for _, p := range pixels[sy*cols+x0 : sy*cols+x1] {
+					sum += int(p)
+				}
+			}
+			dst[y*dstCols+x] = uint8(sum / ((y1 - y0) * (x1 - x0)))
+		}
+	}
+	return dst, nil
+}
+
+// faceSizeRange returns the range of face sizes, in working image pixels, to scan for. scale is
+// the ratio between the original and the working image size.
+func faceSizeRange(opts facedetect.DetectOptions, cols, rows int, scale float64) (int, int) {
+	minSize := float64(opts.MinSize) / scale
+	maxSize := float64(opts.MaxSize) / scale
+
+	shorter := float64(cols)
+	if rows < cols {
+		shorter = float64(rows)
+	}
+	if opts.MinSizeRatio > 0 {
+		minSize = opts.MinSizeRatio * shorter
+	}
+	if opts.MaxSizeRatio > 0 {
+		maxSize = opts.MaxSizeRatio * shorter
+	}
+
+	// Window sizes are integers, smaller windows would never grow by the scale factor.
+	minSize = math.Max(minSize, math.Ceil(1/(opts.ScaleFactor-1)))
+	maxSize = math.Max(maxSize, minSize)
+	return int(minSize), int(maxSize)
+}
+
+// scaleDetection maps the detection found on the working image to the original image coordinates.
+func scaleDetection(d pigo.Detection, sx, sy float64) pigo.Detection {
+	if sx == 1 && sy == 1 {
+		return d
+	}
+	return pigo.Detection{
+		Row:   int(float64(d.Row) * sy),
+		Col:   int(float64(d.Col) * sx),
+		Scale: int(float64(d.Scale) * sx),
+		Q:     d.Q,
+	}
+}
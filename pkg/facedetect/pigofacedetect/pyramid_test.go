@@ -0,0 +1,116 @@
+package pigofacedetect
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+)
+
+func Test_workingSize(t *testing.T) {
+	tests := []struct {
+		name                  string
+		cols, rows, maxPixels int
+		wantCols, wantRows    int
+	}{
+		{name: "disabled", cols: 6000, rows: 4000, maxPixels: 0, wantCols: 6000, wantRows: 4000},
+		{name: "within budget", cols: 600, rows: 400, maxPixels: 240000, wantCols: 600, wantRows: 400},
+		{name: "over budget", cols: 6000, rows: 4000, maxPixels: 240000, wantCols: 600, wantRows: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotCols, gotRows := workingSize(tt.cols, tt.rows, tt.maxPixels)
+			if gotCols != tt.wantCols || gotRows != tt.wantRows {
+				t.Errorf("workingSize() = %d x %d, want %d x %d", gotCols, gotRows, tt.wantCols, tt.wantRows)
+			}
+		})
+	}
+}
+
+func Test_downscale(t *testing.T) {
+	pixels := []uint8{
+		0, 10, 100, 100,
+		20, 30, 100, 100,
+	}
+	got, err := downscale(context.Background(), pixels, 4, 2, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != 15 || got[1] != 100 {
+		t.Errorf("downscale() should average the covered pixels, got %v", got)
+	}
+}
+
+func Test_faceSizeRange(t *testing.T) {
+	opts := facedetect.DefaultDetectOptions()
+	if min, max := faceSizeRange(opts, 800, 600, 2); min != opts.MinSize/2 || max != 600 {
+		t.Errorf("faceSizeRange() should look for faces up to the shorter side by default, got %d, %d", min, max)
+	}
+	opts.MaxSizeRatio = 0
+	if min, max := faceSizeRange(opts, 800, 600, 1); min != opts.MinSize || max != opts.MaxSize {
+		t.Errorf("faceSizeRange() should keep the configured sizes, got %d, %d", min, max)
+	}
+	if min, max := faceSizeRange(opts, 800, 600, 2); min != opts.MinSize/2 || max != opts.MaxSize/2 {
+		t.Errorf("faceSizeRange() should scale the sizes to the working image, got %d, %d", min, max)
+	}
+	opts.MinSizeRatio, opts.MaxSizeRatio = 0.1, 0.5
+	if min, max := faceSizeRange(opts, 800, 600, 2); min != 60 || max != 300 {
+		t.Errorf("faceSizeRange() should derive sizes from the shorter side, got %d, %d", min, max)
+	}
+}
+
+func TestPigoFaceDetect_DetectFacesDownscaled(t *testing.T) {
+	pfd := NewPigoFaceDetector()
+	if err := pfd.LoadCascades("cascades"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path.Join("testdata", "people002.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Upscale the image 4 times and scan it at 3/5 of the original size: faces get smaller than the
+	// features need at the working resolution, but not on the original image.
+	const factor = 4
+	b := src.Bounds()
+	large := image.NewRGBA(image.Rect(0, 0, b.Dx()*factor, b.Dy()*factor))
+	for y := 0; y < b.Dy()*factor; y++ {
+		for x := 0; x < b.Dx()*factor; x++ {
+			large.Set(x, y, src.At(b.Min.X+x/factor, b.Min.Y+y/factor))
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, large); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := facedetect.DefaultDetectOptions()
+	opts.MaxPixels = b.Dx() * b.Dy() * 9 / 25
+	result, err := pfd.DetectFaces(context.Background(), bytes.NewReader(buf.Bytes()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := 0
+	for _, face := range result.Faces {
+		if face.Bounds.Width < 50*factor {
+			continue
+		}
+		found++
+		if face.LeftEye == nil || !image.Pt(face.LeftEye.X, face.LeftEye.Y).In(image.Rect(face.Bounds.X, face.Bounds.Y, face.Bounds.X+face.Bounds.Width, face.Bounds.Y+face.Bounds.Height)) {
+			t.Errorf("eyes should be located on the original image, got %v for %+v", face.LeftEye, *face.Bounds)
+		}
+	}
+	if found < 3 {
+		t.Errorf("expected 3 faces mapped to the original image, got %d", found)
+	}
+}
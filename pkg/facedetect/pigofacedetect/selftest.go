@@ -0,0 +1,31 @@
+package pigofacedetect
+
+import (
+	"bytes"
+	"context"
+	_ "embed" // Embed the self-test image.
+	"fmt"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+)
+
+// ErrSelfTestFailed is returned by SelfTest when the detector finds no faces on the self-test image.
+var ErrSelfTestFailed = fmt.Errorf("self-test failed")
+
+// selfTestImage is a photo of three people facing the camera.
+//
+//go:embed selftest.jpg
+var selfTestImage []byte
+
+// SelfTest checks that fd finds faces on a built-in photo, using the default options. It's meant to
+// catch broken or mismatched cascades before a detector is put to use.
+func SelfTest(ctx context.Context, fd facedetect.FaceDetector) error {
+	result, err := fd.DetectFaces(ctx, bytes.NewReader(selfTestImage), facedetect.DefaultDetectOptions())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSelfTestFailed, err)
+	}
+	if len(result.Faces) == 0 {
+		return fmt.Errorf("%w: no faces found", ErrSelfTestFailed)
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+// Package pluginfacedetect runs the face detection in external executables, the plugins, which
+// keeps their models and libraries out of the server process.
+//
+// Plugins read the detection requests on their standard input and write the answers on their
+// standard output, one request at a time, as described by Serve. Their standard error is kept
+// for logs. A plugin written in Go only has to call Serve with its FaceDetector.
+package pluginfacedetect
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/imagecodec"
+)
+
+// Version identifies the plugin detectors in the responses and the cache keys.
+const Version = "plugin-1"
+
+var (
+	// ErrPluginFailed is returned by DetectFaces calls when the plugin reports an internal error
+	// or a malformed result.
+	ErrPluginFailed = fmt.Errorf("plugin failed")
+
+	// ErrPluginCrashed is returned by DetectFaces calls when the plugin process exits or breaks the
+	// protocol. The process is started again for the next detection.
+	ErrPluginCrashed = fmt.Errorf("plugin crashed")
+
+	// ErrPluginTimeout is returned by DetectFaces calls when the plugin doesn't answer within
+	// Config.CallTimeout. The process is killed and started again for the next detection.
+	ErrPluginTimeout = fmt.Errorf("plugin timed out")
+
+	// ErrClosed is returned by DetectFaces calls once the PluginFaceDetector is closed.
+	ErrClosed = fmt.Errorf("plugin detector closed")
+)
+
+// Config tells how to run a plugin.
+type Config struct {
+	// Path is the plugin executable, looked up in PATH when it contains no slash.
+	Path string
+	// Args are the arguments the plugin is started with.
+	Args []string
+	// Env is the environment of the plugin, the one of the server when nil.
+	Env []string
+	// Stderr receives the standard error of the plugin, discarded when nil.
+	Stderr io.Writer
+	// Processes is the maximum number of plugin processes, each running one detection at a time.
+	// Processes are started on demand.
+	Processes int
+	// CallTimeout bounds every detection on top of the context deadline, zero meaning no bound.
+	CallTimeout time.Duration
+}
+
+// PluginFaceDetector is a FaceDetector running the detections in a pool of plugin processes.
+// Processes that crash, break the protocol or exceed their time are killed and replaced on the
+// next detection.
+type PluginFaceDetector struct {
+	cfg Config
+
+	// procs holds a slot for every process, nil until the process is started.
+	procs chan *process
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewPluginFaceDetector returns a PluginFaceDetector running the plugin described by cfg.
+func NewPluginFaceDetector(cfg Config) (*PluginFaceDetector, error) {
+	if cfg.Processes < 1 {
+		return nil, fmt.Errorf("plugin processes must be positive")
+	}
+	path, err := exec.LookPath(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Path = path
+
+	p := &PluginFaceDetector{cfg: cfg, procs: make(chan *process, cfg.Processes)}
+	for i := 0; i < cfg.Processes; i++ {
+		p.procs <- nil
+	}
+	return p, nil
+}
+
+// DetectFaces sends the image to a plugin process once one is free, starting it if needed.
+// The process is killed when ctx ends before it answers.
+func (p *PluginFaceDetector) DetectFaces(ctx context.Context, img io.Reader, opts facedetect.DetectOptions) (*facedetect.Result, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(img)
+	if err != nil {
+		return nil, err
+	}
+
+	var proc *process
+	select {
+	case proc = <-p.procs:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	// The slot is given back with the process, or nil once the process is killed.
+	defer func() { p.procs <- proc }()
+
+	if p.isClosed() {
+		if proc != nil {
+			proc.kill()
+			proc = nil
+		}
+		return nil, ErrClosed
+	}
+	if proc != nil && proc.exited() {
+		proc = nil
+	}
+	if proc == nil {
+		if proc, err = p.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	callCtx := ctx
+	if p.cfg.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, p.cfg.CallTimeout)
+		defer cancel()
+	}
+	req := Request{Version: ProtocolVersion, Options: optionsOf(opts)}
+	if deadline, ok := callCtx.Deadline(); ok {
+		req.TimeoutMS = int64(time.Until(deadline) / time.Millisecond)
+		if req.TimeoutMS < 1 {
+			req.TimeoutMS = 1
+		}
+	}
+
+	done := make(chan struct{})
+	var resp *Response
+	go func() {
+		defer close(done)
+		resp, err = proc.roundTrip(req, data)
+	}()
+	select {
+	case <-done:
+	case <-callCtx.Done():
+		// The process may answer late, which would desynchronize the protocol.
+		proc.kill()
+		<-done
+		proc = nil
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, ErrPluginTimeout
+	}
+
+	if err != nil {
+		proc.kill()
+		proc = nil
+		return nil, fmt.Errorf("%w: %v", ErrPluginCrashed, err)
+	}
+	if resp.Error != nil {
+		return nil, resp.Error.err()
+	}
+	if resp.Result == nil {
+		proc.kill()
+		proc = nil
+		return nil, fmt.Errorf("%w: response without result", ErrPluginCrashed)
+	}
+	width, height := imageSize(data, opts.Frame, resp.Result.Orientation)
+	if err := checkResult(resp.Result, width, height); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPluginFailed, err)
+	}
+	return resp.Result, nil
+}
+
+// imageSize returns the size of the image in data, in the coordinate frame the faces are reported
+// in, or zeros when it can't be decoded.
+func imageSize(data []byte, frame facedetect.Frame, orientation int) (int, int) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0
+	}
+	if frame == facedetect.FrameDisplayed && imagecodec.Orientation(orientation).SwapsAxes() {
+		return cfg.Height, cfg.Width
+	}
+	return cfg.Width, cfg.Height
+}
+
+// Close kills the plugin processes, waiting for the running detections to end. Later detections
+// fail with ErrClosed.
+func (p *PluginFaceDetector) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	for i := 0; i < cap(p.procs); i++ {
+		if proc := <-p.procs; proc != nil {
+			proc.kill()
+		}
+	}
+	for i := 0; i < cap(p.procs); i++ {
+		p.procs <- nil
+	}
+	return nil
+}
+
+func (p *PluginFaceDetector) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// process is a running plugin.
+type process struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	done   chan struct{} // Closed once the process exited.
+}
+
+// start starts a plugin process.
+func (p *PluginFaceDetector) start() (*process, error) {
+	// The pipes are created here rather than by exec.Cmd, whose Wait closes them, so that a
+	// process exiting shows as the end of its output.
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		inR.Close()
+		inW.Close()
+		return nil, err
+	}
+
+	cmd := exec.Command(p.cfg.Path, p.cfg.Args...)
+	cmd.Env = p.cfg.Env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = inR, outW, p.cfg.Stderr
+	err = cmd.Start()
+	inR.Close()
+	outW.Close()
+	if err != nil {
+		inW.Close()
+		outR.Close()
+		return nil, err
+	}
+
+	proc := &process{cmd: cmd, stdin: inW, stdout: bufio.NewReader(outR), done: make(chan struct{})}
+	go func() {
+		_ = cmd.Wait()
+		inW.Close()
+		outR.Close()
+		close(proc.done)
+	}()
+	return proc, nil
+}
+
+// roundTrip sends a request and reads its response.
+func (proc *process) roundTrip(req Request, img []byte) (*Response, error) {
+	if err := writeJSON(proc.stdin, req); err != nil {
+		return nil, err
+	}
+	if err := writeFrame(proc.stdin, img); err != nil {
+		return nil, err
+	}
+	b, err := readFrame(proc.stdout)
+	if err != nil {
+		return nil, err
+	}
+	var resp Response
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// exited tells whether the process exited.
+func (proc *process) exited() bool {
+	select {
+	case <-proc.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// kill kills the process and waits for it to exit.
+func (proc *process) kill() {
+	_ = proc.cmd.Process.Kill()
+	<-proc.done
+}
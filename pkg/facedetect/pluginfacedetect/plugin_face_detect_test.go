@@ -0,0 +1,305 @@
+package pluginfacedetect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+)
+
+// helperEnv makes the test binary run as a plugin.
+const helperEnv = "PLUGINFACEDETECT_HELPER"
+
+var helperFace = facedetect.Face{Bounds: &facedetect.Bounds{X: 1, Y: 2, Width: 3, Height: 4}, Score: 5}
+
+// helperDetector behaves according to the image it is given.
+type helperDetector struct{}
+
+func (helperDetector) DetectFaces(ctx context.Context, img io.Reader, opts facedetect.DetectOptions) (*facedetect.Result, error) {
+	b, _ := ioutil.ReadAll(img)
+	switch string(b) {
+	case "crash":
+		os.Exit(2)
+	case "garbage":
+		os.Stdout.Write([]byte{0, 0, 0, 1, '{'})
+		select {}
+	case "sleep":
+		time.Sleep(time.Minute)
+	case "deadline":
+		if _, ok := ctx.Deadline(); !ok {
+			return nil, errors.New("no deadline")
+		}
+	case "bad image":
+		return nil, facedetect.ErrImageError
+	case "fail":
+		return nil, errors.New("failure")
+	case "no bounds":
+		return &facedetect.Result{Faces: []facedetect.Face{{Score: 1}}}, nil
+	case "outside bounds":
+		return &facedetect.Result{Faces: []facedetect.Face{{Bounds: &facedetect.Bounds{X: -1, Width: 1, Height: 1}}}}, nil
+	case "empty bounds":
+		return &facedetect.Result{Faces: []facedetect.Face{{Bounds: &facedetect.Bounds{X: 1, Width: 0, Height: 1}}}}, nil
+	case "edge bounds":
+		return &facedetect.Result{Faces: []facedetect.Face{{Bounds: &facedetect.Bounds{X: -2, Y: -1, Width: 5, Height: 5}}}}, nil
+	case "bad frames":
+		return &facedetect.Result{Faces: []facedetect.Face{helperFace}, Frames: []facedetect.FrameFaces{{Index: 3, Faces: []facedetect.Face{helperFace}}}}, nil
+	case "options":
+		if !reflect.DeepEqual(opts, echoOptions()) {
+			return nil, fmt.Errorf("options = %+v", opts)
+		}
+	}
+	return &facedetect.Result{Faces: []facedetect.Face{helperFace}, Format: "jpeg", Orientation: 1}, nil
+}
+
+// echoOptions are the options the "options" image expects.
+func echoOptions() facedetect.DetectOptions {
+	opts := facedetect.DefaultDetectOptions()
+	opts.Angles = []float64{-30, 30}
+	opts.FullLandmarks = true
+	opts.Frame = facedetect.FrameStored
+	return opts
+}
+
+func TestMain(m *testing.M) {
+	if os.Getenv(helperEnv) != "" {
+		if err := Serve(os.Stdin, os.Stdout, helperDetector{}); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func newHelper(t *testing.T, processes int, callTimeout time.Duration) *PluginFaceDetector {
+	p, err := NewPluginFaceDetector(Config{
+		Path:        os.Args[0],
+		Env:         append(os.Environ(), helperEnv+"=1"),
+		Processes:   processes,
+		CallTimeout: callTimeout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+func detect(p *PluginFaceDetector, ctx context.Context, img string) (*facedetect.Result, error) {
+	return p.DetectFaces(ctx, strings.NewReader(img), facedetect.DefaultDetectOptions())
+}
+
+func TestNewPluginFaceDetector(t *testing.T) {
+	if _, err := NewPluginFaceDetector(Config{Path: os.Args[0]}); err == nil {
+		t.Error("NewPluginFaceDetector() should fail without processes")
+	}
+	if _, err := NewPluginFaceDetector(Config{Path: "/wrong/plugin", Processes: 1}); err == nil {
+		t.Error("NewPluginFaceDetector() should fail for a missing executable")
+	}
+}
+
+func TestPluginFaceDetector_DetectFaces(t *testing.T) {
+	p := newHelper(t, 2, 0)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		img     string
+		wantErr error
+	}{
+		{name: "faces", img: "image"},
+		{name: "deadline passed to the plugin", img: "deadline", wantErr: ErrPluginFailed},
+		{name: "image error", img: "bad image", wantErr: facedetect.ErrImageError},
+		{name: "plugin error", img: "fail", wantErr: ErrPluginFailed},
+		{name: "face without bounds", img: "no bounds", wantErr: ErrPluginFailed},
+		{name: "bounds out of the image", img: "outside bounds", wantErr: ErrPluginFailed},
+		{name: "bounds without area", img: "empty bounds", wantErr: ErrPluginFailed},
+		{name: "frames out of order", img: "bad frames", wantErr: ErrPluginFailed},
+		{name: "crash", img: "crash", wantErr: ErrPluginCrashed},
+		{name: "restarted after crash", img: "image"},
+		{name: "protocol broken", img: "garbage", wantErr: ErrPluginCrashed},
+		{name: "restarted after protocol break", img: "image"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := detect(p, ctx, tt.img)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DetectFaces() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			want := &facedetect.Result{Faces: []facedetect.Face{helperFace}, Format: "jpeg", Orientation: 1}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("DetectFaces() = %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if _, err := detect(p, ctx, "deadline"); err != nil {
+		t.Errorf("DetectFaces() should pass the context deadline to the plugin, got: %v", err)
+	}
+
+	got, err := detect(p, ctx, "edge bounds")
+	if err != nil {
+		t.Fatalf("DetectFaces() should clip the bounds at the edge of the image, got: %v", err)
+	}
+	if b := *got.Faces[0].Bounds; b != (facedetect.Bounds{X: 0, Y: 0, Width: 3, Height: 4}) {
+		t.Errorf("DetectFaces() bounds = %+v, want clipped", b)
+	}
+
+	if _, err := p.DetectFaces(ctx, strings.NewReader("options"), echoOptions()); err != nil {
+		t.Errorf("DetectFaces() should pass the options to the plugin, got: %v", err)
+	}
+
+	opts := facedetect.DefaultDetectOptions()
+	opts.MinSize = 0
+	if _, err := p.DetectFaces(ctx, strings.NewReader("image"), opts); !errors.Is(err, facedetect.ErrInvalidDetectOptions) {
+		t.Errorf("DetectFaces() error = %v, want invalid options", err)
+	}
+}
+
+func TestPluginFaceDetector_DetectFaces_Timeout(t *testing.T) {
+	p := newHelper(t, 1, 100*time.Millisecond)
+
+	if _, err := detect(p, context.Background(), "sleep"); err != ErrPluginTimeout {
+		t.Errorf("DetectFaces() error = %v, want %v", err, ErrPluginTimeout)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := detect(p, ctx, "sleep"); err != context.DeadlineExceeded {
+		t.Errorf("DetectFaces() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := detect(p, context.Background(), "image"); err != nil {
+		t.Errorf("DetectFaces() should restart the killed plugin, got: %v", err)
+	}
+}
+
+func TestPluginFaceDetector_Close(t *testing.T) {
+	p := newHelper(t, 1, 0)
+	if _, err := detect(p, context.Background(), "image"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := detect(p, context.Background(), "image"); err != ErrClosed {
+		t.Errorf("DetectFaces() error = %v, want %v", err, ErrClosed)
+	}
+}
+
+func Test_checkResult(t *testing.T) {
+	face := func(x, y, w, h int) facedetect.Face {
+		return facedetect.Face{Bounds: &facedetect.Bounds{X: x, Y: y, Width: w, Height: h}}
+	}
+	r := &facedetect.Result{Faces: []facedetect.Face{face(-5, 10, 20, 20), face(90, 90, 20, 20)}}
+	if err := checkResult(r, 100, 100); err != nil {
+		t.Fatalf("checkResult() error = %v", err)
+	}
+	want := []facedetect.Bounds{{X: 0, Y: 10, Width: 15, Height: 20}, {X: 90, Y: 90, Width: 10, Height: 10}}
+	for i, f := range r.Faces {
+		if *f.Bounds != want[i] {
+			t.Errorf("checkResult() clipped face %d to %+v, want %+v", i, *f.Bounds, want[i])
+		}
+	}
+
+	r = &facedetect.Result{Faces: []facedetect.Face{face(100, 10, 20, 20)}}
+	if err := checkResult(r, 100, 100); err == nil {
+		t.Error("checkResult() should reject a face out of the image")
+	}
+	r = &facedetect.Result{Faces: []facedetect.Face{face(100, 10, 20, 20)}}
+	if err := checkResult(r, 0, 0); err != nil {
+		t.Errorf("checkResult() should not clip to an unknown size, got: %v", err)
+	}
+	nan := face(0, 0, 10, 10)
+	nan.Angle = math.NaN()
+	if err := checkResult(&facedetect.Result{Faces: []facedetect.Face{nan}}, 0, 0); err == nil {
+		t.Error("checkResult() should reject an angle that isn't a number")
+	}
+}
+
+func Test_imageSize(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		frame       facedetect.Frame
+		orientation int
+		w, h        int
+	}{
+		{frame: facedetect.FrameDisplayed, orientation: 1, w: 30, h: 20},
+		{frame: facedetect.FrameDisplayed, orientation: 6, w: 20, h: 30},
+		{frame: facedetect.FrameStored, orientation: 6, w: 30, h: 20},
+	}
+	for _, tt := range tests {
+		if w, h := imageSize(buf.Bytes(), tt.frame, tt.orientation); w != tt.w || h != tt.h {
+			t.Errorf("imageSize(%v, %d) = %d, %d, want %d, %d", tt.frame, tt.orientation, w, h, tt.w, tt.h)
+		}
+	}
+	if w, h := imageSize([]byte("image"), facedetect.FrameDisplayed, 1); w != 0 || h != 0 {
+		t.Errorf("imageSize() = %d, %d for an unknown format, want zeros", w, h)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	b, err := json.Marshal(optionsOf(echoOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"min_size":20`, `"frame":"stored"`, `"angles":[-30,30]`} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("options encode to %s, want %s", b, want)
+		}
+	}
+	var o Options
+	if err := json.Unmarshal(b, &o); err != nil {
+		t.Fatal(err)
+	}
+	if got := o.DetectOptions(); !reflect.DeepEqual(got, echoOptions()) {
+		t.Errorf("DetectOptions() = %+v, want %+v", got, echoOptions())
+	}
+	if err := (Options{Frame: "sideways"}).DetectOptions().Validate(); !errors.Is(err, facedetect.ErrInvalidDetectOptions) {
+		t.Errorf("unknown frame should make the options invalid, got: %v", err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	in := &bytes.Buffer{}
+	_ = writeJSON(in, Request{Version: ProtocolVersion + 1})
+	_ = writeFrame(in, []byte("image"))
+	_ = writeJSON(in, Request{Version: ProtocolVersion})
+	_ = writeFrame(in, []byte("bad image"))
+	out := &bytes.Buffer{}
+
+	if err := Serve(in, out, helperDetector{}); err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+	for _, want := range []string{codeUnsupportedProtocol, CodeImageError} {
+		b, err := readFrame(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), want) {
+			t.Errorf("Serve() response = %s, want %s error", b, want)
+		}
+	}
+
+	if err := Serve(strings.NewReader("\x00\x00\x00\x05ab"), out, helperDetector{}); err == nil {
+		t.Error("Serve() should fail on a truncated frame")
+	}
+}
@@ -0,0 +1,335 @@
+package pluginfacedetect
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+)
+
+// ProtocolVersion is the version of the protocol spoken with the plugins, sent in every request.
+const ProtocolVersion = 2
+
+// maxFrameSize bounds the frames read from either side, guarding against corrupted length prefixes.
+const maxFrameSize = 64 << 20
+
+// Error codes reported by the plugins in responses.
+const (
+	CodeImageError          = "image_error"
+	CodeUnsupportedFormat   = "unsupported_format"
+	CodeAnimationTooLarge   = "animation_too_large"
+	CodeInvalidOptions      = "invalid_options"
+	CodeInternal            = "internal"
+	codeUnsupportedProtocol = "unsupported_protocol"
+)
+
+// errFrameTooLarge is returned when a frame exceeds maxFrameSize.
+var errFrameTooLarge = fmt.Errorf("frame too large")
+
+// Coordinate frames of Options.Frame.
+const (
+	FrameDisplayed = "displayed"
+	FrameStored    = "stored"
+)
+
+// Request is the header of a detection request, followed by the image frame.
+type Request struct {
+	Version int     `json:"version"`
+	Options Options `json:"options"`
+	// TimeoutMS is the time left to answer, in milliseconds, zero meaning no limit.
+	TimeoutMS int64 `json:"timeout_ms,omitempty"`
+}
+
+// Options are the facedetect.DetectOptions of a request, see there for their meaning. Frame is
+// FrameDisplayed or FrameStored.
+type Options struct {
+	MinSize                  int       `json:"min_size"`
+	MaxSize                  int       `json:"max_size"`
+	MinSizeRatio             float64   `json:"min_size_ratio"`
+	MaxSizeRatio             float64   `json:"max_size_ratio"`
+	MaxPixels                int       `json:"max_pixels"`
+	ShiftFactor              float64   `json:"shift_factor"`
+	ScaleFactor              float64   `json:"scale_factor"`
+	IoUThreshold             float64   `json:"iou_threshold"`
+	QualityThreshold         float32   `json:"quality_threshold"`
+	FeaturesQualityThreshold float32   `json:"features_quality_threshold"`
+	Perturbs                 int       `json:"perturbs"`
+	Angles                   []float64 `json:"angles,omitempty"`
+	FullLandmarks            bool      `json:"full_landmarks"`
+	Frame                    string    `json:"frame"`
+	AllFrames                bool      `json:"all_frames"`
+	MaxFrames                int       `json:"max_frames"`
+	MaxFramesPixels          int       `json:"max_frames_pixels"`
+}
+
+// optionsOf returns the protocol form of opts.
+func optionsOf(opts facedetect.DetectOptions) Options {
+	frame := FrameDisplayed
+	if opts.Frame == facedetect.FrameStored {
+		frame = FrameStored
+	}
+	return Options{
+		MinSize:                  opts.MinSize,
+		MaxSize:                  opts.MaxSize,
+		MinSizeRatio:             opts.MinSizeRatio,
+		MaxSizeRatio:             opts.MaxSizeRatio,
+		MaxPixels:                opts.MaxPixels,
+		ShiftFactor:              opts.ShiftFactor,
+		ScaleFactor:              opts.ScaleFactor,
+		IoUThreshold:             opts.IoUThreshold,
+		QualityThreshold:         opts.QualityThreshold,
+		FeaturesQualityThreshold: opts.FeaturesQualityThreshold,
+		Perturbs:                 opts.Perturbs,
+		Angles:                   opts.Angles,
+		FullLandmarks:            opts.FullLandmarks,
+		Frame:                    frame,
+		AllFrames:                opts.AllFrames,
+		MaxFrames:                opts.MaxFrames,
+		MaxFramesPixels:          opts.MaxFramesPixels,
+	}
+}
+
+// DetectOptions returns the options as given to facedetect.FaceDetector. An unknown Frame is left
+// for DetectOptions.Validate to reject.
+func (o Options) DetectOptions() facedetect.DetectOptions {
+	frame := facedetect.Frame(-1)
+	switch o.Frame {
+	case FrameDisplayed:
+		frame = facedetect.FrameDisplayed
+	case FrameStored:
+		frame = facedetect.FrameStored
+	}
+	return facedetect.DetectOptions{
+		MinSize:                  o.MinSize,
+		MaxSize:                  o.MaxSize,
+		MinSizeRatio:             o.MinSizeRatio,
+		MaxSizeRatio:             o.MaxSizeRatio,
+		MaxPixels:                o.MaxPixels,
+		ShiftFactor:              o.ShiftFactor,
+		ScaleFactor:              o.ScaleFactor,
+		IoUThreshold:             o.IoUThreshold,
+		QualityThreshold:         o.QualityThreshold,
+		FeaturesQualityThreshold: o.FeaturesQualityThreshold,
+		Perturbs:                 o.Perturbs,
+		Angles:                   o.Angles,
+		FullLandmarks:            o.FullLandmarks,
+		Frame:                    frame,
+		AllFrames:                o.AllFrames,
+		MaxFrames:                o.MaxFrames,
+		MaxFramesPixels:          o.MaxFramesPixels,
+	}
+}
+
+// Response is the answer to a detection request, holding either the result or the error.
+type Response struct {
+	Result *facedetect.Result `json:"result,omitempty"`
+	Error  *Error             `json:"error,omitempty"`
+}
+
+// Error is a detection failure reported by a plugin. Code is one of the Code constants.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeFrame writes b prefixed with its length as a big-endian uint32.
+func writeFrame(w io.Writer, b []byte) error {
+	if len(b) > maxFrameSize {
+		return errFrameTooLarge
+	}
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(b)))
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// readFrame reads a frame written by writeFrame. It returns io.EOF only when r ends before the frame.
+func readFrame(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxFrameSize {
+		return nil, errFrameTooLarge
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// writeJSON writes v as a JSON frame.
+func writeJSON(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFrame(w, b)
+}
+
+// Serve speaks the plugin side of the protocol, answering the requests read from r with the
+// detections of fd written to w, usually os.Stdin and os.Stdout. It returns nil once r ends
+// between two requests.
+//
+// A request is a frame holding the JSON encoded Request followed by a frame holding the image.
+// The answer is a frame holding the JSON encoded Response. Frames are prefixed with their length
+// as a big-endian uint32.
+func Serve(r io.Reader, w io.Writer, fd facedetect.FaceDetector) error {
+	for {
+		header, err := readFrame(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		img, err := readFrame(r)
+		if err != nil {
+			return err
+		}
+
+		var req Request
+		if err := json.Unmarshal(header, &req); err != nil {
+			return err
+		}
+		if err := writeJSON(w, serveRequest(req, img, fd)); err != nil {
+			return err
+		}
+	}
+}
+
+// serveRequest runs the detection of a single request.
+func serveRequest(req Request, img []byte, fd facedetect.FaceDetector) Response {
+	if req.Version != ProtocolVersion {
+		return Response{Error: &Error{Code: codeUnsupportedProtocol, Message: fmt.Sprintf("unsupported protocol version %d", req.Version)}}
+	}
+	ctx := context.Background()
+	if req.TimeoutMS > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.TimeoutMS)*time.Millisecond)
+		defer cancel()
+	}
+	result, err := fd.DetectFaces(ctx, bytes.NewReader(img), req.Options.DetectOptions())
+	if err != nil {
+		return Response{Error: errorOf(err)}
+	}
+	return Response{Result: result}
+}
+
+// errorOf returns the protocol error reporting err.
+func errorOf(err error) *Error {
+	code := CodeInternal
+	switch {
+	case err == facedetect.ErrImageError:
+		code = CodeImageError
+	case err == facedetect.ErrUnsupportedImageFormat:
+		code = CodeUnsupportedFormat
+	case err == facedetect.ErrAnimationTooLarge:
+		code = CodeAnimationTooLarge
+	case errors.Is(err, facedetect.ErrInvalidDetectOptions):
+		code = CodeInvalidOptions
+	}
+	return &Error{Code: code, Message: err.Error()}
+}
+
+// err returns the facedetect error matching e.
+func (e *Error) err() error {
+	switch e.Code {
+	case CodeImageError:
+		return facedetect.ErrImageError
+	case CodeUnsupportedFormat:
+		return facedetect.ErrUnsupportedImageFormat
+	case CodeAnimationTooLarge:
+		return facedetect.ErrAnimationTooLarge
+	case CodeInvalidOptions:
+		return fmt.Errorf("%w: %s", facedetect.ErrInvalidDetectOptions, e.Message)
+	}
+	return fmt.Errorf("%w: %s", ErrPluginFailed, e.Message)
+}
+
+// checkResult checks that a result reported by a plugin is well formed: every face has bounds of
+// positive size, numbers and no missing landmark, and the frames are numbered in order. Bounds
+// overflowing the image, as usual for faces at its edges, are clipped to it, the right and bottom
+// edges only when the size of the image, width by height, is known.
+func checkResult(r *facedetect.Result, width, height int) error {
+	checkFaces := func(faces []facedetect.Face) error {
+		for _, f := range faces {
+			b := f.Bounds
+			if b == nil {
+				return fmt.Errorf("face without bounds")
+			}
+			if b.Width <= 0 || b.Height <= 0 {
+				return fmt.Errorf("face bounds without area %+v", *b)
+			}
+			if math.IsNaN(float64(f.Score)) || math.IsNaN(f.Angle) {
+				return fmt.Errorf("face score or angle not a number")
+			}
+			if !clipBounds(b, width, height) {
+				return fmt.Errorf("face bounds out of the image %+v", *b)
+			}
+			for name, p := range f.Landmarks {
+				if p == nil {
+					return fmt.Errorf("landmark %s without point", name)
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := checkFaces(r.Faces); err != nil {
+		return err
+	}
+	for i, ff := range r.Frames {
+		if ff.Index != i {
+			return fmt.Errorf("frame %d reported at index %d", ff.Index, i)
+		}
+		if ff.Delay < 0 {
+			return fmt.Errorf("negative delay of frame %d", i)
+		}
+		if err := checkFaces(ff.Faces); err != nil {
+			return fmt.Errorf("frame %d: %w", i, err)
+		}
+	}
+	if len(r.Frames) > 0 && len(r.Frames[0].Faces) != len(r.Faces) {
+		return fmt.Errorf("faces differ from the ones of the first frame")
+	}
+	return nil
+}
+
+// clipBounds clips b to the image of the given size, zero when unknown, returning false when
+// nothing is left.
+func clipBounds(b *facedetect.Bounds, width, height int) bool {
+	x0, y0, x1, y1 := b.X, b.Y, b.X+b.Width, b.Y+b.Height
+	if x0 < 0 {
+		x0 = 0
+	}
+	if y0 < 0 {
+		y0 = 0
+	}
+	if width > 0 && x1 > width {
+		x1 = width
+	}
+	if height > 0 && y1 > height {
+		y1 = height
+	}
+	if x1 <= x0 || y1 <= y0 {
+		return false
+	}
+	b.X, b.Y, b.Width, b.Height = x0, y0, x1-x0, y1-y0
+	return true
+}
@@ -0,0 +1,153 @@
+package poolfacedetect
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+)
+
+// Stats are the counters of a PoolFaceDetector.
+type Stats struct {
+	// Workers is the maximum number of concurrent detections.
+	Workers int `json:"workers"`
+	// QueueDepth is the maximum number of detections waiting for a worker.
+	QueueDepth int `json:"queue_depth"`
+	// Busy is the number of running detections.
+	Busy int `json:"busy"`
+	// Queued is the number of detections waiting for a worker.
+	Queued int `json:"queued"`
+	// Rejected counts the detections rejected with facedetect.ErrBusy.
+	Rejected int64 `json:"rejected"`
+	// Started counts the detections that got a worker.
+	Started int64 `json:"started"`
+	// QueueWaitTotal is the time the started detections spent waiting for a worker.
+	QueueWaitTotal time.Duration `json:"queue_wait_total_ns"`
+	// QueueWaitMax is the longest time a started detection spent waiting for a worker.
+	QueueWaitMax time.Duration `json:"queue_wait_max_ns"`
+}
+
+// PoolFaceDetector limits the number of concurrent detections of the wrapped FaceDetector.
+// Detections beyond the limit wait in a bounded queue, and are rejected with facedetect.ErrBusy
+// when the queue is full or they waited for longer than the configured maximum.
+type PoolFaceDetector struct {
+	fd      facedetect.FaceDetector
+	maxWait time.Duration
+
+	workers chan struct{} // Holds a token for every running detection.
+	slots   chan struct{} // Holds a token for every running or queued detection.
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// NewPoolFaceDetector wraps fd with a pool of workers detections run on, and a queue of
+// queueDepth detections waiting for them. Detections wait for a worker for at most maxWait,
+// or until their context ends if maxWait is zero.
+func NewPoolFaceDetector(fd facedetect.FaceDetector, workers, queueDepth int, maxWait time.Duration) *PoolFaceDetector {
+	return &PoolFaceDetector{
+		fd:      fd,
+		maxWait: maxWait,
+		workers: make(chan struct{}, workers),
+		slots:   make(chan struct{}, workers+queueDepth),
+		stats:   Stats{Workers: workers, QueueDepth: queueDepth},
+	}
+}
+
+// DetectFaces runs the detection on the wrapped FaceDetector once a worker is free.
+func (p *PoolFaceDetector) DetectFaces(ctx context.Context, img io.Reader, opts facedetect.DetectOptions) (*facedetect.Result, error) {
+	return p.run(ctx, p.fd, img, opts)
+}
+
+// Wrap returns a FaceDetector running the detections of fd on the workers of the pool, sharing
+// them with the pool's own FaceDetector and every other wrapped one.
+func (p *PoolFaceDetector) Wrap(fd facedetect.FaceDetector) facedetect.FaceDetector {
+	return &wrapped{p: p, fd: fd}
+}
+
+type wrapped struct {
+	p  *PoolFaceDetector
+	fd facedetect.FaceDetector
+}
+
+func (w *wrapped) DetectFaces(ctx context.Context, img io.Reader, opts facedetect.DetectOptions) (*facedetect.Result, error) {
+	return w.p.run(ctx, w.fd, img, opts)
+}
+
+// run runs the detection on fd once a worker is free.
+func (p *PoolFaceDetector) run(ctx context.Context, fd facedetect.FaceDetector, img io.Reader, opts facedetect.DetectOptions) (*facedetect.Result, error) {
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		p.reject()
+		return nil, facedetect.ErrBusy
+	}
+	defer func() { <-p.slots }()
+
+	if err := p.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer p.release()
+
+	return fd.DetectFaces(ctx, img, opts)
+}
+
+// acquire waits for a free worker.
+func (p *PoolFaceDetector) acquire(ctx context.Context) error {
+	start := time.Now()
+	p.update(func(s *Stats) { s.Queued++ })
+
+	var timeout <-chan time.Time
+	if p.maxWait > 0 {
+		t := time.NewTimer(p.maxWait)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case p.workers <- struct{}{}:
+		wait := time.Since(start)
+		p.update(func(s *Stats) {
+			s.Queued--
+			s.Busy++
+			s.Started++
+			s.QueueWaitTotal += wait
+			if wait > s.QueueWaitMax {
+				s.QueueWaitMax = wait
+			}
+		})
+		return nil
+	case <-timeout:
+		p.update(func(s *Stats) { s.Queued-- })
+		p.reject()
+		return facedetect.ErrBusy
+	case <-ctx.Done():
+		p.update(func(s *Stats) { s.Queued-- })
+		return ctx.Err()
+	}
+}
+
+// release frees the worker taken by acquire.
+func (p *PoolFaceDetector) release() {
+	<-p.workers
+	p.update(func(s *Stats) { s.Busy-- })
+}
+
+func (p *PoolFaceDetector) reject() {
+	p.update(func(s *Stats) { s.Rejected++ })
+}
+
+func (p *PoolFaceDetector) update(f func(s *Stats)) {
+	p.mu.Lock()
+	f(&p.stats)
+	p.mu.Unlock()
+}
+
+// Stats returns a snapshot of the pool counters.
+func (p *PoolFaceDetector) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
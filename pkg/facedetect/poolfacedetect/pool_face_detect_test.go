@@ -0,0 +1,157 @@
+package poolfacedetect
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+)
+
+// blockingFaceDetect blocks every detection until release is closed, tracking the concurrency.
+type blockingFaceDetect struct {
+	started chan struct{}
+	release chan struct{}
+
+	mu            sync.Mutex
+	running       int
+	maxConcurrent int
+}
+
+func newBlockingFaceDetect() *blockingFaceDetect {
+	return &blockingFaceDetect{started: make(chan struct{}, 100), release: make(chan struct{})}
+}
+
+func (b *blockingFaceDetect) DetectFaces(ctx context.Context, img io.Reader, opts facedetect.DetectOptions) (*facedetect.Result, error) {
+	b.mu.Lock()
+	b.running++
+	if b.running > b.maxConcurrent {
+		b.maxConcurrent = b.running
+	}
+	b.mu.Unlock()
+	b.started <- struct{}{}
+
+	<-b.release
+
+	b.mu.Lock()
+	b.running--
+	b.mu.Unlock()
+	return &facedetect.Result{}, nil
+}
+
+func detect(p *PoolFaceDetector, ctx context.Context) error {
+	_, err := p.DetectFaces(ctx, strings.NewReader(""), facedetect.DefaultDetectOptions())
+	return err
+}
+
+// waitQueued waits until n detections are waiting for a worker.
+func waitQueued(t *testing.T, p *PoolFaceDetector, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for p.Stats().Queued != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d queued detections, got %d", n, p.Stats().Queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPoolFaceDetector_QueueFull(t *testing.T) {
+	fd := newBlockingFaceDetect()
+	p := NewPoolFaceDetector(fd, 2, 1, 0)
+
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() { errs <- detect(p, context.Background()) }()
+	}
+	<-fd.started
+	<-fd.started
+	waitQueued(t, p, 1)
+
+	if err := detect(p, context.Background()); err != facedetect.ErrBusy {
+		t.Errorf("DetectFaces() should return facedetect.ErrBusy when the queue is full, got: %v", err)
+	}
+
+	close(fd.release)
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("DetectFaces() error = %v", err)
+		}
+	}
+	if fd.maxConcurrent != 2 {
+		t.Errorf("at most 2 detections should run concurrently, got %d", fd.maxConcurrent)
+	}
+
+	want := Stats{Workers: 2, QueueDepth: 1, Rejected: 1, Started: 3}
+	got := p.Stats()
+	got.QueueWaitTotal, got.QueueWaitMax = 0, 0
+	if got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPoolFaceDetector_MaxWait(t *testing.T) {
+	fd := newBlockingFaceDetect()
+	defer close(fd.release)
+	p := NewPoolFaceDetector(fd, 1, 1, 20*time.Millisecond)
+
+	go func() { _ = detect(p, context.Background()) }()
+	<-fd.started
+
+	start := time.Now()
+	if err := detect(p, context.Background()); err != facedetect.ErrBusy {
+		t.Errorf("DetectFaces() should return facedetect.ErrBusy after waiting too long, got: %v", err)
+	}
+	if took := time.Since(start); took < 20*time.Millisecond || took > time.Second {
+		t.Errorf("DetectFaces() should wait for the max wait, took %v", took)
+	}
+	if s := p.Stats(); s.Queued != 0 || s.Rejected != 1 {
+		t.Errorf("Stats() = %+v, want no queued and 1 rejected detection", s)
+	}
+}
+
+func TestPoolFaceDetector_ContextEnded(t *testing.T) {
+	fd := newBlockingFaceDetect()
+	defer close(fd.release)
+	p := NewPoolFaceDetector(fd, 1, 1, 0)
+
+	go func() { _ = detect(p, context.Background()) }()
+	<-fd.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := detect(p, ctx); err != context.DeadlineExceeded {
+		t.Errorf("DetectFaces() should return context.DeadlineExceeded, got: %v", err)
+	}
+	if s := p.Stats(); s.Queued != 0 || s.Rejected != 0 {
+		t.Errorf("Stats() = %+v, want no queued and no rejected detections", s)
+	}
+}
+
+func TestPoolFaceDetector_Wrap(t *testing.T) {
+	fd := newBlockingFaceDetect()
+	other := newBlockingFaceDetect()
+	p := NewPoolFaceDetector(fd, 1, 0, 0)
+	w := p.Wrap(other)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := w.DetectFaces(context.Background(), strings.NewReader(""), facedetect.DefaultDetectOptions())
+		errs <- err
+	}()
+	<-other.started
+
+	if err := detect(p, context.Background()); err != facedetect.ErrBusy {
+		t.Errorf("wrapped detectors should share the workers of the pool, got: %v", err)
+	}
+	close(other.release)
+	if err := <-errs; err != nil {
+		t.Errorf("DetectFaces() error = %v", err)
+	}
+	if s := p.Stats(); s.Started != 1 || s.Rejected != 1 {
+		t.Errorf("Stats() = %+v, want 1 started and 1 rejected detection", s)
+	}
+}
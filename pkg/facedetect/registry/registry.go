@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+)
+
+var (
+	// ErrUnknownDetector is returned by Registry.Get when no detector is registered with the name.
+	ErrUnknownDetector = fmt.Errorf("unknown face detector")
+
+	// ErrInvalidName is returned by Registry.Register when the name isn't made of lower case
+	// letters, digits, dashes and underscores.
+	ErrInvalidName = fmt.Errorf("invalid face detector name")
+
+	// ErrDuplicateName is returned by Registry.Register when the name is taken.
+	ErrDuplicateName = fmt.Errorf("face detector name already registered")
+)
+
+var validName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// Entry is a FaceDetector registered with its name and version. Version identifies the
+// detection algorithm and configuration, results of different versions may differ.
+type Entry struct {
+	facedetect.FaceDetector
+
+	Name    string
+	Version string
+}
+
+// Registry holds named face detectors. The first registered detector is the default one.
+// It's safe for concurrent use.
+type Registry struct {
+	mu         sync.RWMutex
+	entries    map[string]*Entry
+	fallback   string
+	generation uint64
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{entries: map[string]*Entry{}}
+}
+
+// Register adds fd to the registry under name.
+func (r *Registry) Register(name, version string, fd facedetect.FaceDetector) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries[name]; ok {
+		return fmt.Errorf("%w: %q", ErrDuplicateName, name)
+	}
+	r.entries[name] = &Entry{FaceDetector: fd, Name: name, Version: version}
+	if r.fallback == "" {
+		r.fallback = name
+	}
+	return nil
+}
+
+// SetDefault makes the detector registered under name the default one.
+func (r *Registry) SetDefault(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries[name]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownDetector, name)
+	}
+	r.fallback = name
+	return nil
+}
+
+// Get returns the detector registered under name, or the default one when name is empty.
+func (r *Registry) Get(name string) (*Entry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if name == "" {
+		name = r.fallback
+	}
+	e, ok := r.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownDetector, name)
+	}
+	return e, nil
+}
+
+// Reloaded records that the registered detectors changed without changing version, e.g. when
+// their models were reloaded.
+func (r *Registry) Reloaded() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generation++
+}
+
+// Generation counts the calls to Reloaded. Detectors of the same version may give different
+// results in different generations.
+func (r *Registry) Generation() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.generation
+}
+
+// Names returns the names of the registered detectors, sorted.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.entries))
+	for name := range r.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,81 @@
+package registry
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/bokan/facedetection/pkg/facedetect/fakefacedetect"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	if _, err := r.Get(""); !errors.Is(err, ErrUnknownDetector) {
+		t.Errorf("Get() on an empty registry should return ErrUnknownDetector, got %v", err)
+	}
+
+	pigo := fakefacedetect.NewFakeFaceDetect(nil, nil)
+	other := fakefacedetect.NewFakeFaceDetect(nil, nil)
+	if err := r.Register("pigo", "1", pigo); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register("pigo-fast", "2", other); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := r.Get("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Name != "pigo" || e.Version != "1" || e.FaceDetector != pigo {
+		t.Errorf("Get() should return the first registered detector by default, got %+v", e)
+	}
+	if e, err := r.Get("pigo-fast"); err != nil || e.Name != "pigo-fast" || e.FaceDetector != other {
+		t.Errorf("Get() should return the named detector, got %+v, %v", e, err)
+	}
+	if _, err := r.Get("opencv"); !errors.Is(err, ErrUnknownDetector) {
+		t.Errorf("Get() should return ErrUnknownDetector, got %v", err)
+	}
+
+	if err := r.SetDefault("pigo-fast"); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := r.Get(""); e.Name != "pigo-fast" {
+		t.Errorf("Get() should return the detector set as default, got %q", e.Name)
+	}
+	if err := r.SetDefault("opencv"); !errors.Is(err, ErrUnknownDetector) {
+		t.Errorf("SetDefault() should return ErrUnknownDetector, got %v", err)
+	}
+
+	if got, want := r.Names(), []string{"pigo", "pigo-fast"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %v, want %v", got, want)
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := NewRegistry()
+	fd := fakefacedetect.NewFakeFaceDetect(nil, nil)
+	for _, name := range []string{"", "Pigo", "pigo detector", "pigo/1"} {
+		if err := r.Register(name, "1", fd); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Register(%q) should return ErrInvalidName, got %v", name, err)
+		}
+	}
+	if err := r.Register("pigo", "1", fd); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register("pigo", "2", fd); !errors.Is(err, ErrDuplicateName) {
+		t.Errorf("Register() should return ErrDuplicateName, got %v", err)
+	}
+}
+
+func TestRegistry_Reloaded(t *testing.T) {
+	r := NewRegistry()
+	if g := r.Generation(); g != 0 {
+		t.Errorf("Generation() = %d, want 0", g)
+	}
+	r.Reloaded()
+	r.Reloaded()
+	if g := r.Generation(); g != 2 {
+		t.Errorf("Generation() = %d, want 2", g)
+	}
+}
@@ -0,0 +1,77 @@
+package reloadfacedetect
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/imagecodec"
+)
+
+// Loader creates a new, ready to use FaceDetector.
+type Loader func() (facedetect.FaceDetector, error)
+
+// Validator checks a freshly loaded FaceDetector before it's put to use.
+type Validator func(ctx context.Context, fd facedetect.FaceDetector) error
+
+// detector wraps the current FaceDetector, atomic.Value requires a consistent concrete type.
+type detector struct {
+	fd facedetect.FaceDetector
+}
+
+// ReloadFaceDetector is a FaceDetector that can be replaced while serving requests. Detections
+// that are already running finish on the detector they started with.
+type ReloadFaceDetector struct {
+	load     Loader
+	validate Validator
+
+	mu      sync.Mutex // Serializes reloads.
+	current atomic.Value
+}
+
+// NewReloadFaceDetector loads and validates the initial FaceDetector. A nil validate accepts every
+// loaded detector.
+func NewReloadFaceDetector(ctx context.Context, load Loader, validate Validator) (*ReloadFaceDetector, error) {
+	r := &ReloadFaceDetector{load: load, validate: validate}
+	if err := r.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads and validates a new FaceDetector, then swaps it in for the following detections.
+// On failure, the current detector is kept.
+func (r *ReloadFaceDetector) Reload(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fd, err := r.load()
+	if err != nil {
+		return fmt.Errorf("loading face detector: %w", err)
+	}
+	if r.validate != nil {
+		if err := r.validate(ctx, fd); err != nil {
+			return fmt.Errorf("validating face detector: %w", err)
+		}
+	}
+	r.current.Store(detector{fd: fd})
+	return nil
+}
+
+// DetectFaces runs the detection on the current FaceDetector.
+func (r *ReloadFaceDetector) DetectFaces(ctx context.Context, img io.Reader, opts facedetect.DetectOptions) (*facedetect.Result, error) {
+	return r.current.Load().(detector).fd.DetectFaces(ctx, img, opts)
+}
+
+// DetectDecodedFaces runs the detection of a decoded image on the current FaceDetector. It
+// returns facedetect.ErrDecodedUnsupported when the current FaceDetector can't analyze it.
+func (r *ReloadFaceDetector) DetectDecodedFaces(ctx context.Context, img *imagecodec.Image, opts facedetect.DetectOptions) (*facedetect.Result, error) {
+	fd, ok := r.current.Load().(detector).fd.(facedetect.DecodedFaceDetector)
+	if !ok {
+		return nil, facedetect.ErrDecodedUnsupported
+	}
+	return fd.DetectDecodedFaces(ctx, img, opts)
+}
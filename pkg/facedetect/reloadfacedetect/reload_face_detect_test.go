@@ -0,0 +1,103 @@
+package reloadfacedetect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+	"github.com/bokan/facedetection/pkg/facedetect/fakefacedetect"
+	"github.com/bokan/facedetection/pkg/imagecodec"
+)
+
+// loader returns the given detectors one by one, failing once they run out.
+func loader(detectors ...facedetect.FaceDetector) Loader {
+	return func() (facedetect.FaceDetector, error) {
+		if len(detectors) == 0 {
+			return nil, fmt.Errorf("no more detectors")
+		}
+		fd := detectors[0]
+		detectors = detectors[1:]
+		return fd, nil
+	}
+}
+
+// faceCount returns the number of faces detected by fd.
+func faceCount(t *testing.T, fd facedetect.FaceDetector) int {
+	t.Helper()
+	result, err := fd.DetectFaces(context.Background(), strings.NewReader(""), facedetect.DefaultDetectOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(result.Faces)
+}
+
+func TestNewReloadFaceDetector(t *testing.T) {
+	if _, err := NewReloadFaceDetector(context.Background(), loader(), nil); err == nil {
+		t.Error("NewReloadFaceDetector() should fail when the initial detector can't be loaded")
+	}
+
+	fake := fakefacedetect.NewFakeFaceDetect(nil, nil)
+	failing := func(ctx context.Context, fd facedetect.FaceDetector) error {
+		return fmt.Errorf("fake error")
+	}
+	if _, err := NewReloadFaceDetector(context.Background(), loader(fake), failing); err == nil {
+		t.Error("NewReloadFaceDetector() should fail when the initial detector is invalid")
+	}
+}
+
+func TestReloadFaceDetector_Reload(t *testing.T) {
+	one := fakefacedetect.NewFakeFaceDetect([]facedetect.Face{{}}, nil)
+	two := fakefacedetect.NewFakeFaceDetect([]facedetect.Face{{}, {}}, nil)
+	three := fakefacedetect.NewFakeFaceDetect([]facedetect.Face{{}, {}, {}}, nil)
+
+	errInvalid := fmt.Errorf("invalid")
+	validate := func(ctx context.Context, fd facedetect.FaceDetector) error {
+		if fd == two {
+			return errInvalid
+		}
+		return nil
+	}
+	r, err := NewReloadFaceDetector(context.Background(), loader(one, two, three), validate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := faceCount(t, r); got != 1 {
+		t.Fatalf("initial detector should be used, got %d faces", got)
+	}
+
+	if err := r.Reload(context.Background()); !errors.Is(err, errInvalid) {
+		t.Errorf("Reload() should return the validation error, got: %v", err)
+	}
+	if got := faceCount(t, r); got != 1 {
+		t.Errorf("invalid detector should not be swapped in, got %d faces", got)
+	}
+
+	if err := r.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := faceCount(t, r); got != 3 {
+		t.Errorf("reloaded detector should be used, got %d faces", got)
+	}
+
+	if err := r.Reload(context.Background()); err == nil {
+		t.Error("Reload() should return the load error")
+	}
+	if got := faceCount(t, r); got != 3 {
+		t.Errorf("detector should be kept when loading fails, got %d faces", got)
+	}
+}
+
+func TestReloadFaceDetector_DetectDecodedFaces(t *testing.T) {
+	r, err := NewReloadFaceDetector(context.Background(), loader(fakefacedetect.NewFakeFaceDetect(nil, nil)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := &imagecodec.Image{Image: image.NewGray(image.Rect(0, 0, 1, 1))}
+	if _, err := r.DetectDecodedFaces(context.Background(), img, facedetect.DefaultDetectOptions()); err != facedetect.ErrDecodedUnsupported {
+		t.Errorf("DetectDecodedFaces() should return facedetect.ErrDecodedUnsupported, got: %v", err)
+	}
+}
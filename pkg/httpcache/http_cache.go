@@ -8,21 +8,46 @@ import (
 	"github.com/bokan/facedetection/pkg/responserecorder"
 )
 
+// KeyFunc returns the key a request's response is cached under.
+type KeyFunc func(r *http.Request) string
+
+// AliasFunc returns one more key a request's response is cached under, given the response header,
+// or an empty string.
+type AliasFunc func(r *http.Request, header http.Header) string
+
+// DefaultKeyFunc keys the responses by request method and URL.
+func DefaultKeyFunc(r *http.Request) string {
+	return fmt.Sprintf("%s-%s", r.Method, r.URL.String())
+}
+
 // HTTPCache caches the successful HTTP responses.
 type HTTPCache struct {
 	store cachestore.CacheStore
+	key   KeyFunc
+	alias AliasFunc
 }
 
 // NewHTTPCache instantiates a new HTTPCache with provided cache store.
-func NewHTTPCache(store cachestore.CacheStore) *HTTPCache {
-	return &HTTPCache{store: store}
+//
+// Responses are cached under the key returned by key. If key is nil, DefaultKeyFunc is used.
+func NewHTTPCache(store cachestore.CacheStore, key KeyFunc) *HTTPCache {
+	if key == nil {
+		key = DefaultKeyFunc
+	}
+	return &HTTPCache{store: store, key: key}
+}
+
+// SetAlias makes the responses also cached under the key returned by alias, when it differs from
+// the request's key. It must be called before the middleware serves requests.
+func (c *HTTPCache) SetAlias(alias AliasFunc) {
+	c.alias = alias
 }
 
 // Middleware returns a HTTP middleware that performs caching.
 func (c *HTTPCache) Middleware() func(handler http.Handler) http.Handler {
 	return func(handler http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			key := fmt.Sprintf("%s-%s", r.Method, r.URL.String())
+			key := c.key(r)
 
 			if resp, err := c.store.Load(key); err == nil {
 				dst := w.Header()
@@ -41,11 +66,17 @@ func (c *HTTPCache) Middleware() func(handler http.Handler) http.Handler {
 			rr := responserecorder.NewResponseRecorder(w, r)
 			handler.ServeHTTP(rr, r)
 			if rr.StatusCode() == 200 {
-				_ = c.store.Save(key, &cachestore.Response{
+				resp := &cachestore.Response{
 					StatusCode: rr.StatusCode(),
 					Header:     rr.Header(),
 					Body:       rr.Body(),
-				})
+				}
+				_ = c.store.Save(key, resp)
+				if c.alias != nil {
+					if alias := c.alias(r, rr.Header()); alias != "" && alias != key {
+						_ = c.store.Save(alias, resp)
+					}
+				}
 			}
 		})
 	}
@@ -9,7 +9,7 @@ import (
 )
 
 func TestHTTPCache_Middleware(t *testing.T) {
-	hc := NewHTTPCache(memorycachestore.NewMemoryCacheStore())
+	hc := NewHTTPCache(memorycachestore.NewMemoryCacheStore(), nil)
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(200)
@@ -34,3 +34,50 @@ func TestHTTPCache_Middleware(t *testing.T) {
 	}
 
 }
+
+func TestHTTPCache_Middleware_KeyFunc(t *testing.T) {
+	hc := NewHTTPCache(memorycachestore.NewMemoryCacheStore(), func(r *http.Request) string {
+		return r.URL.Query().Get("k")
+	})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte("foobar"))
+	})
+	m := hc.Middleware()(handler)
+
+	recm := httptest.NewRecorder()
+	m.ServeHTTP(recm, httptest.NewRequest(http.MethodGet, "/foo?k=1&x=1", nil))
+	if recm.Header().Get("X-Cache") != "MISS" {
+		t.Error("expected cache miss")
+		return
+	}
+
+	rech := httptest.NewRecorder()
+	m.ServeHTTP(rech, httptest.NewRequest(http.MethodGet, "/bar?x=2&k=1", nil))
+	if rech.Header().Get("X-Cache") != "HIT" {
+		t.Error("requests with the same key should hit the cache")
+		return
+	}
+}
+
+func TestHTTPCache_Middleware_Alias(t *testing.T) {
+	hc := NewHTTPCache(memorycachestore.NewMemoryCacheStore(), func(r *http.Request) string {
+		return r.URL.Query().Get("k")
+	})
+	hc.SetAlias(func(r *http.Request, header http.Header) string {
+		return header.Get("X-Alias")
+	})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Alias", "2")
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte("foobar"))
+	})
+	m := hc.Middleware()(handler)
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/foo?k=1", nil))
+	rech := httptest.NewRecorder()
+	m.ServeHTTP(rech, httptest.NewRequest(http.MethodGet, "/foo?k=2", nil))
+	if rech.Header().Get("X-Cache") != "HIT" {
+		t.Error("requests with the alias key should hit the cache")
+	}
+}
@@ -0,0 +1,203 @@
+package imagecodec
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// ErrAnimationTooLarge is returned by DecodeAll when an animation exceeds the frame limits.
+var ErrAnimationTooLarge = fmt.Errorf("animation too large")
+
+// gifHeader is the prefix shared by all GIF versions.
+var gifHeader = []byte("GIF8")
+
+// Frame is a single frame of an animation, composited the way it's displayed.
+type Frame struct {
+	image.Image
+
+	// Delay is the time the frame is displayed for.
+	Delay time.Duration
+}
+
+// Animation is a decoded image with all of its frames. Still images have a single frame.
+type Animation struct {
+	Frames []Frame
+
+	// Format is the name of the image format, as registered with the image package, e.g. "gif".
+	Format string
+
+	// Orientation is the EXIF orientation that was applied to the stored pixels.
+	Orientation Orientation
+}
+
+// DecodeAll decodes every frame of an animated GIF, applying the disposal method of each frame
+// to the canvas the next one is drawn on. Other images are decoded by Decode into a single frame.
+//
+// Animations with more than maxFrames frames, or more than maxPixels pixels in all of their
+// frames, are rejected with ErrAnimationTooLarge before any frame is decoded.
+func DecodeAll(r io.Reader, maxFrames, maxPixels int) (*Animation, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, gifHeader) {
+		img, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return &Animation{Frames: []Frame{{Image: img.Image}}, Format: img.Format, Orientation: img.Orientation}, nil
+	}
+
+	// Frames are composited on the whole logical screen, which bounds the frames the pixel budget
+	// allows for.
+	cfg, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	limit := maxFrames
+	if screen := cfg.Width * cfg.Height; screen > 0 && maxPixels/screen < limit {
+		limit = maxPixels / screen
+	}
+	if gifFrames(data, limit) > limit {
+		return nil, ErrAnimationTooLarge
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return &Animation{Frames: compose(g), Format: "gif", Orientation: OrientationNormal}, nil
+}
+
+// GIF block introducers and flags, see the GIF89a specification.
+const (
+	gifExtension       = 0x21
+	gifImageDescriptor = 0x2C
+	gifColorTable      = 0x80
+)
+
+// gifFrames counts the image descriptors of the GIF in data without decoding them, stopping once
+// there are more than max of them. Malformed data ends the count, its errors are left to the decoder.
+func gifFrames(data []byte, max int) int {
+	// The header and the logical screen descriptor, followed by the global color table.
+	pos := 13
+	if len(data) < pos {
+		return 0
+	}
+	if flags := data[10]; flags&gifColorTable != 0 {
+		pos += 3 << (flags&7 + 1)
+	}
+	skipSubBlocks := func() bool {
+		for pos < len(data) {
+			size := int(data[pos])
+			pos += 1 + size
+			if size == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	n := 0
+	for pos < len(data) && n <= max {
+		switch data[pos] {
+		case gifExtension:
+			// The introducer and the label.
+			pos += 2
+			if !skipSubBlocks() {
+				return n
+			}
+		case gifImageDescriptor:
+			n++
+			if pos+10 > len(data) {
+				return n
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&gifColorTable != 0 {
+				pos += 3 << (flags&7 + 1)
+			}
+			// The LZW minimum code size.
+			pos++
+			if !skipSubBlocks() {
+				return n
+			}
+		default:
+			// The trailer, or garbage.
+			return n
+		}
+	}
+	return n
+}
+
+// gifPalette is the palette EncodeAll quantizes frames to, web-safe colors and transparency.
+var gifPalette = append(color.Palette{color.Transparent}, palette.WebSafe...)
+
+// EncodeAll writes the frames of a in the given format. Only GIF keeps the animation, other
+// formats get the first frame written by Encode.
+func EncodeAll(w io.Writer, a *Animation, format string) error {
+	if len(a.Frames) == 0 {
+		return fmt.Errorf("animation has no frames")
+	}
+	if format != "gif" {
+		return Encode(w, a.Frames[0], format)
+	}
+
+	b := a.Frames[0].Bounds()
+	g := &gif.GIF{Config: image.Config{ColorModel: gifPalette, Width: b.Dx(), Height: b.Dy()}}
+	for _, f := range a.Frames {
+		p := image.NewPaletted(f.Bounds(), gifPalette)
+		draw.FloydSteinberg.Draw(p, p.Rect, f, f.Bounds().Min)
+		g.Image = append(g.Image, p)
+		g.Delay = append(g.Delay, int(f.Delay/(10*time.Millisecond)))
+		// Frames are whole canvases, transparent areas must not show the previous frame.
+		g.Disposal = append(g.Disposal, gif.DisposalBackground)
+	}
+	return gif.EncodeAll(w, g)
+}
+
+// compose draws the GIF frames one over another, as a viewer would display them. The canvas starts
+// transparent and areas disposed to the background are cleared to transparent, like browsers do.
+func compose(g *gif.GIF) []Frame {
+	canvas := image.NewNRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	frames := make([]Frame, len(g.Image))
+	for i, p := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneNRGBA(canvas)
+		}
+
+		draw.Draw(canvas, p.Bounds(), p, p.Bounds().Min, draw.Over)
+		frames[i].Image = cloneNRGBA(canvas)
+		if i < len(g.Delay) {
+			// GIF delays are in hundredths of a second.
+			frames[i].Delay = time.Duration(g.Delay[i]) * 10 * time.Millisecond
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, p.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames
+}
+
+func cloneNRGBA(src *image.NRGBA) *image.NRGBA {
+	dst := image.NewNRGBA(src.Rect)
+	copy(dst.Pix, src.Pix)
+	return dst
+}
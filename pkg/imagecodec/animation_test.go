@@ -0,0 +1,197 @@
+package imagecodec
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+	"time"
+)
+
+// testGIF encodes a 4x4 animation of three 2x2 frames, drawn with the given disposal methods
+// at the top left, top right and bottom left corners.
+func testGIF(t *testing.T, disposal []byte) []byte {
+	t.Helper()
+	pal := color.Palette{color.Transparent, color.Black}
+	g := &gif.GIF{
+		Config:   image.Config{ColorModel: pal, Width: 4, Height: 4},
+		Disposal: disposal,
+		Delay:    []int{10, 20, 30},
+	}
+	for _, p := range []image.Point{{0, 0}, {2, 0}, {0, 2}} {
+		frame := image.NewPaletted(image.Rect(p.X, p.Y, p.X+2, p.Y+2), pal)
+		for i := range frame.Pix {
+			frame.Pix[i] = 1
+		}
+		g.Image = append(g.Image, frame)
+	}
+	buf := &bytes.Buffer{}
+	if err := gif.EncodeAll(buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// opaque reports which of the corners (top left, top right, bottom left) are drawn on the frame.
+func opaque(img image.Image) [3]bool {
+	var corners [3]bool
+	for i, p := range []image.Point{{0, 0}, {3, 0}, {0, 3}} {
+		_, _, _, a := img.At(p.X, p.Y).RGBA()
+		corners[i] = a != 0
+	}
+	return corners
+}
+
+func TestDecodeAll_Disposal(t *testing.T) {
+	tests := []struct {
+		name     string
+		disposal byte
+		want     [3][3]bool
+	}{
+		{
+			name:     "none",
+			disposal: gif.DisposalNone,
+			want:     [3][3]bool{{true, false, false}, {true, true, false}, {true, true, true}},
+		},
+		{
+			name:     "background",
+			disposal: gif.DisposalBackground,
+			want:     [3][3]bool{{true, false, false}, {false, true, false}, {false, false, true}},
+		},
+		{
+			name:     "previous",
+			disposal: gif.DisposalPrevious,
+			want:     [3][3]bool{{true, false, false}, {false, true, false}, {false, false, true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disposal := []byte{tt.disposal, tt.disposal, tt.disposal}
+			anim, err := DecodeAll(bytes.NewReader(testGIF(t, disposal)), 10, 1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if anim.Format != "gif" {
+				t.Errorf("DecodeAll() format = %q, want gif", anim.Format)
+			}
+			if len(anim.Frames) != 3 {
+				t.Fatalf("DecodeAll() should return 3 frames, got %d", len(anim.Frames))
+			}
+			for i, f := range anim.Frames {
+				if got := opaque(f); got != tt.want[i] {
+					t.Errorf("frame %d drawn corners = %v, want %v", i, got, tt.want[i])
+				}
+				if want := time.Duration(i+1) * 100 * time.Millisecond; f.Delay != want {
+					t.Errorf("frame %d delay = %v, want %v", i, f.Delay, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeAll_DisposalPreviousKeepsEarlierFrames(t *testing.T) {
+	// The first frame stays, the second one is undone before the third is drawn.
+	disposal := []byte{gif.DisposalNone, gif.DisposalPrevious, gif.DisposalNone}
+	anim, err := DecodeAll(bytes.NewReader(testGIF(t, disposal)), 10, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := opaque(anim.Frames[2]), [3]bool{true, false, true}; got != want {
+		t.Errorf("frame 2 drawn corners = %v, want %v", got, want)
+	}
+}
+
+func TestDecodeAll_Limits(t *testing.T) {
+	data := testGIF(t, nil)
+	if _, err := DecodeAll(bytes.NewReader(data), 2, 1000); err != ErrAnimationTooLarge {
+		t.Errorf("DecodeAll() should return ErrAnimationTooLarge when there are too many frames, got: %v", err)
+	}
+	// Three 4x4 frames.
+	if _, err := DecodeAll(bytes.NewReader(data), 10, 47); err != ErrAnimationTooLarge {
+		t.Errorf("DecodeAll() should return ErrAnimationTooLarge when there are too many pixels, got: %v", err)
+	}
+	if _, err := DecodeAll(bytes.NewReader(data), 3, 48); err != nil {
+		t.Errorf("DecodeAll() should accept animations within the limits, got: %v", err)
+	}
+	if _, err := DecodeAll(bytes.NewReader(data), 10, 15); err != ErrAnimationTooLarge {
+		t.Errorf("DecodeAll() should return ErrAnimationTooLarge when the screen exceeds the pixels, got: %v", err)
+	}
+
+	// The frames are counted before they're decoded: the broken last frame isn't reached.
+	truncated := data[:len(data)-4]
+	if _, err := DecodeAll(bytes.NewReader(truncated), 2, 1000); err != ErrAnimationTooLarge {
+		t.Errorf("DecodeAll() should reject too many frames before decoding them, got: %v", err)
+	}
+	if _, err := DecodeAll(bytes.NewReader(truncated), 3, 1000); err == nil {
+		t.Error("DecodeAll() should fail on a truncated frame")
+	}
+}
+
+func Test_gifFrames(t *testing.T) {
+	data := testGIF(t, nil)
+	for _, tt := range []struct{ max, want int }{{10, 3}, {3, 3}, {1, 2}} {
+		if got := gifFrames(data, tt.max); got != tt.want {
+			t.Errorf("gifFrames(%d) = %d, want %d", tt.max, got, tt.want)
+		}
+	}
+	if got := gifFrames(data[:8], 10); got != 0 {
+		t.Errorf("gifFrames() of a truncated header = %d, want 0", got)
+	}
+}
+
+func TestDecodeAll_StillImage(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 100, 100))); err != nil {
+		t.Fatal(err)
+	}
+	// Limits apply to animations only.
+	anim, err := DecodeAll(buf, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anim.Format != "png" || len(anim.Frames) != 1 {
+		t.Errorf("DecodeAll() should return the single frame of a PNG image, got %d frames of %q", len(anim.Frames), anim.Format)
+	}
+}
+
+func TestEncodeAll(t *testing.T) {
+	disposal := []byte{gif.DisposalBackground, gif.DisposalNone, gif.DisposalNone}
+	anim, err := DecodeAll(bytes.NewReader(testGIF(t, disposal)), 10, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := EncodeAll(buf, anim, "gif"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeAll(buf, 10, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Frames) != len(anim.Frames) {
+		t.Fatalf("EncodeAll() should keep the %d frames, got %d", len(anim.Frames), len(got.Frames))
+	}
+	for i, f := range got.Frames {
+		if opaque(f) != opaque(anim.Frames[i]) {
+			t.Errorf("frame %d drawn corners = %v, want %v", i, opaque(f), opaque(anim.Frames[i]))
+		}
+		if f.Delay != anim.Frames[i].Delay {
+			t.Errorf("frame %d delay = %v, want %v", i, f.Delay, anim.Frames[i].Delay)
+		}
+	}
+
+	buf.Reset()
+	if err := EncodeAll(buf, anim, "png"); err != nil {
+		t.Fatal(err)
+	}
+	still, err := png.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opaque(still) != opaque(anim.Frames[0]) {
+		t.Errorf("EncodeAll() should write the first frame of still formats, got corners %v", opaque(still))
+	}
+}
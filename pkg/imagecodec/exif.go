@@ -0,0 +1,91 @@
+package imagecodec
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	jpegSOI  = 0xd8
+	jpegSOS  = 0xda
+	jpegAPP1 = 0xe1
+
+	tiffTagOrientation = 0x0112
+	tiffTypeShort      = 3
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// exifOrientation returns the orientation stored in the EXIF metadata of a JPEG image. Images
+// without valid orientation are reported as OrientationNormal.
+func exifOrientation(data []byte) Orientation {
+	if len(data) < 4 || data[0] != 0xff || data[1] != jpegSOI {
+		return OrientationNormal
+	}
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xff {
+			return OrientationNormal
+		}
+		marker := data[pos+1]
+		if marker == 0xff {
+			// Fill byte.
+			pos++
+			continue
+		}
+		if marker == jpegSOS {
+			// Metadata segments precede the image data.
+			return OrientationNormal
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return OrientationNormal
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == jpegAPP1 && bytes.HasPrefix(segment, exifHeader) {
+			return tiffOrientation(segment[len(exifHeader):])
+		}
+		pos += 2 + length
+	}
+	return OrientationNormal
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of TIFF structured data.
+func tiffOrientation(tiff []byte) Orientation {
+	if len(tiff) < 8 {
+		return OrientationNormal
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return OrientationNormal
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return OrientationNormal
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return OrientationNormal
+		}
+		if order.Uint16(tiff[entry:]) != tiffTagOrientation {
+			continue
+		}
+		if order.Uint16(tiff[entry+2:]) != tiffTypeShort {
+			return OrientationNormal
+		}
+		o := Orientation(order.Uint16(tiff[entry+8:]))
+		if !o.Valid() {
+			return OrientationNormal
+		}
+		return o
+	}
+	return OrientationNormal
+}
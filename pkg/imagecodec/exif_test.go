@@ -0,0 +1,95 @@
+package imagecodec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// exifJPEG encodes img as JPEG with an EXIF segment carrying orientation o.
+func exifJPEG(t *testing.T, img image.Image, o Orientation, order binary.ByteOrder) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+
+	tiff := &bytes.Buffer{}
+	if order == binary.LittleEndian {
+		tiff.WriteString("II*\x00")
+	} else {
+		tiff.WriteString("MM\x00*")
+	}
+	_ = binary.Write(tiff, order, uint32(8))              // IFD0 offset
+	_ = binary.Write(tiff, order, uint16(1))              // Number of entries
+	_ = binary.Write(tiff, order, uint16(0x0112))         // Orientation tag
+	_ = binary.Write(tiff, order, uint16(3))              // SHORT
+	_ = binary.Write(tiff, order, uint32(1))              // Count
+	_ = binary.Write(tiff, order, []uint16{uint16(o), 0}) // Value
+	_ = binary.Write(tiff, order, uint32(0))              // Next IFD
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	app1 := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+
+	jpg := buf.Bytes()
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, app1...)
+	out = append(out, segment...)
+	return append(out, jpg[2:]...)
+}
+
+func Test_exifOrientation(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 4))
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for o := OrientationNormal; o <= OrientationRotate270; o++ {
+			if got := exifOrientation(exifJPEG(t, img, o, order)); got != o {
+				t.Errorf("exifOrientation() = %d, want %d (%v)", got, o, order)
+			}
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	if got := exifOrientation(buf.Bytes()); got != OrientationNormal {
+		t.Errorf("exifOrientation() of image without EXIF = %d, want %d", got, OrientationNormal)
+	}
+	if got := exifOrientation(exifJPEG(t, img, 9, binary.BigEndian)); got != OrientationNormal {
+		t.Errorf("exifOrientation() of invalid orientation = %d, want %d", got, OrientationNormal)
+	}
+	if got := exifOrientation([]byte("foo")); got != OrientationNormal {
+		t.Errorf("exifOrientation() of garbage = %d, want %d", got, OrientationNormal)
+	}
+}
+
+func TestDecode_AppliesOrientation(t *testing.T) {
+	// 2x1 image stored with a white pixel on the left.
+	img := image.NewGray(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Pix[y*16+x] = 0xff
+		}
+	}
+
+	decoded, err := Decode(bytes.NewReader(exifJPEG(t, img, OrientationRotate90, binary.BigEndian)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Orientation != OrientationRotate90 {
+		t.Errorf("Decode() orientation = %d, want %d", decoded.Orientation, OrientationRotate90)
+	}
+	if b := decoded.Bounds(); b.Dx() != 8 || b.Dy() != 16 {
+		t.Fatalf("Decode() should swap the dimensions, got %v", b)
+	}
+	// Rotated clockwise, the left half becomes the top half.
+	if r, _, _, _ := decoded.At(4, 4).RGBA(); r < 0xf000 {
+		t.Error("Decode() should rotate the image clockwise")
+	}
+	if r, _, _, _ := decoded.At(4, 12).RGBA(); r > 0x1000 {
+		t.Error("Decode() should rotate the image clockwise")
+	}
+}
@@ -0,0 +1,81 @@
+package imagecodec
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp" // Add WebP support.
+)
+
+// ErrUnsupportedEncoding is returned by Encode for formats that can be decoded only.
+var ErrUnsupportedEncoding = fmt.Errorf("unsupported encoding format")
+
+// jpegQuality is the quality of the encoded JPEG images, high enough for the compression
+// artifacts not to get in the way of further processing.
+const jpegQuality = 90
+
+// Image is a decoded image, oriented the way it's meant to be displayed.
+type Image struct {
+	image.Image
+
+	// Format is the name of the image format, as registered with the image package, e.g. "jpeg".
+	Format string
+
+	// Orientation is the EXIF orientation that was applied to the stored pixels.
+	Orientation Orientation
+}
+
+// Decode decodes a JPEG, PNG, GIF, BMP, TIFF or WebP image and applies its EXIF orientation,
+// if any. Only the first frame of animated images is decoded.
+//
+// Errors returned by image.Decode are passed through unchanged.
+func Decode(r io.Reader) (*Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	o := OrientationNormal
+	switch format {
+	case "jpeg":
+		o = exifOrientation(data)
+	case "tiff":
+		o = tiffOrientation(data)
+	}
+	return &Image{Image: o.Apply(src), Format: format, Orientation: o}, nil
+}
+
+// Encode writes img to w in the given format. JPEG, PNG, GIF, BMP and TIFF are supported, other
+// formats fail with ErrUnsupportedEncoding.
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	case "bmp":
+		return bmp.Encode(w, img)
+	case "tiff":
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+	}
+	return ErrUnsupportedEncoding
+}
+
+// ContentType returns the MIME type of an image format, as named by the image package.
+func ContentType(format string) string {
+	return "image/" + format
+}
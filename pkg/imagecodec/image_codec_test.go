@@ -0,0 +1,82 @@
+package imagecodec
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+func TestDecode_Formats(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	encode := func(enc func(io.Writer, image.Image) error) []byte {
+		buf := &bytes.Buffer{}
+		if err := enc(buf, img); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	webp, err := ioutil.ReadFile(path.Join("testdata", "gopher.webp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format string
+		data   []byte
+	}{
+		{format: "jpeg", data: encode(func(w io.Writer, m image.Image) error { return jpeg.Encode(w, m, nil) })},
+		{format: "png", data: encode(png.Encode)},
+		{format: "gif", data: encode(func(w io.Writer, m image.Image) error { return gif.Encode(w, m, nil) })},
+		{format: "bmp", data: encode(bmp.Encode)},
+		{format: "tiff", data: encode(func(w io.Writer, m image.Image) error { return tiff.Encode(w, m, nil) })},
+		{format: "webp", data: webp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			decoded, err := Decode(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Format != tt.format {
+				t.Errorf("Decode() format = %q, want %q", decoded.Format, tt.format)
+			}
+			if decoded.Orientation != OrientationNormal {
+				t.Errorf("Decode() orientation = %d, want %d", decoded.Orientation, OrientationNormal)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for _, format := range []string{"jpeg", "png", "gif", "bmp", "tiff"} {
+		buf := &bytes.Buffer{}
+		if err := Encode(buf, img, format); err != nil {
+			t.Errorf("Encode(%s) error = %v", format, err)
+			continue
+		}
+		decoded, err := Decode(buf)
+		if err != nil {
+			t.Errorf("Encode(%s) output can't be decoded: %v", format, err)
+			continue
+		}
+		if decoded.Format != format || decoded.Bounds() != img.Bounds() {
+			t.Errorf("Encode(%s) decoded to %s image with bounds %v", format, decoded.Format, decoded.Bounds())
+		}
+	}
+	if err := Encode(ioutil.Discard, img, "webp"); err != ErrUnsupportedEncoding {
+		t.Errorf("Encode(webp) should return ErrUnsupportedEncoding, got: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package imagecodec
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// Orientation is the value of the EXIF Orientation tag. It describes the transformation from the
+// stored pixels to the image as it's meant to be displayed.
+type Orientation int
+
+// EXIF orientations, named after the transformation needed to display the stored image.
+const (
+	OrientationNormal Orientation = iota + 1
+	OrientationMirrorHorizontal
+	OrientationRotate180
+	OrientationMirrorVertical
+	OrientationTranspose
+	OrientationRotate90
+	OrientationTransverse
+	OrientationRotate270
+)
+
+// Valid reports whether o is one of the eight EXIF orientations.
+func (o Orientation) Valid() bool {
+	return o >= OrientationNormal && o <= OrientationRotate270
+}
+
+// SwapsAxes reports whether the displayed image has width and height of the stored one swapped.
+func (o Orientation) SwapsAxes() bool {
+	return o >= OrientationTranspose && o <= OrientationRotate270
+}
+
+// Apply returns the stored image src transformed for display.
+func (o Orientation) Apply(src image.Image) image.Image {
+	if !o.Valid() || o == OrientationNormal {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o.SwapsAxes() {
+		dw, dh = h, w
+	}
+
+	// Work on a copy with the origin at (0, 0), so that coordinates can be mapped directly.
+	s := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(s, s.Bounds(), src, b.Min, draw.Src)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch o {
+			case OrientationMirrorHorizontal:
+				sx, sy = w-1-x, y
+			case OrientationRotate180:
+				sx, sy = w-1-x, h-1-y
+			case OrientationMirrorVertical:
+				sx, sy = x, h-1-y
+			case OrientationTranspose:
+				sx, sy = y, x
+			case OrientationRotate90:
+				sx, sy = y, h-1-x
+			case OrientationTransverse:
+				sx, sy = w-1-y, h-1-x
+			case OrientationRotate270:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], s.Pix[s.PixOffset(sx, sy):s.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+// StoredPoint maps the point (x, y) on the displayed image of width w and height h to the
+// stored image.
+func (o Orientation) StoredPoint(x, y, w, h int) (int, int) {
+	switch o {
+	case OrientationMirrorHorizontal:
+		return w - x, y
+	case OrientationRotate180:
+		return w - x, h - y
+	case OrientationMirrorVertical:
+		return x, h - y
+	case OrientationTranspose:
+		return y, x
+	case OrientationRotate90:
+		return y, w - x
+	case OrientationTransverse:
+		return h - y, w - x
+	case OrientationRotate270:
+		return h - y, x
+	}
+	return x, y
+}
+
+// StoredRect maps the rectangle r on the displayed image of width w and height h to the
+// stored image.
+func (o Orientation) StoredRect(r image.Rectangle, w, h int) image.Rectangle {
+	x0, y0 := o.StoredPoint(r.Min.X, r.Min.Y, w, h)
+	x1, y1 := o.StoredPoint(r.Max.X, r.Max.Y, w, h)
+	return image.Rect(x0, y0, x1, y1)
+}
+
+// StoredAngle maps the in-plane rotation, in degrees counter-clockwise, on the displayed image to
+// the stored image. Result is in range (-180, 180].
+func (o Orientation) StoredAngle(deg float64) float64 {
+	if !o.Valid() || o == OrientationNormal {
+		return deg
+	}
+	// Map the direction vector, the y axis pointing down as in image coordinates.
+	sin, cos := math.Sincos(deg * math.Pi / 180)
+	dx, dy := cos, -sin
+	switch o {
+	case OrientationMirrorHorizontal:
+		dx = -dx
+	case OrientationRotate180:
+		dx, dy = -dx, -dy
+	case OrientationMirrorVertical:
+		dy = -dy
+	case OrientationTranspose:
+		dx, dy = dy, dx
+	case OrientationRotate90:
+		dx, dy = dy, -dx
+	case OrientationTransverse:
+		dx, dy = -dy, -dx
+	case OrientationRotate270:
+		dx, dy = -dy, dx
+	}
+	a := math.Atan2(-dy, dx) * 180 / math.Pi
+	// Round off the floating point noise, so that right angles stay exact.
+	a = math.Round(a*1e6) / 1e6
+	if a == -180 {
+		a = 180
+	}
+	return a
+}
@@ -0,0 +1,51 @@
+package imagecodec
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestOrientation_StoredPoint(t *testing.T) {
+	// 3x2 stored image with distinct pixel values.
+	stored := image.NewGray(image.Rect(0, 0, 3, 2))
+	for i := range stored.Pix {
+		stored.Pix[i] = uint8(i + 1)
+	}
+
+	for o := OrientationNormal; o <= OrientationRotate270; o++ {
+		displayed := o.Apply(stored)
+		b := displayed.Bounds()
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				// Map the centre of the displayed pixel, using doubled coordinates to stay in integers.
+				sx, sy := o.StoredPoint(2*x+1, 2*y+1, 2*b.Dx(), 2*b.Dy())
+				want := stored.GrayAt(sx/2, sy/2)
+				got := color.GrayModel.Convert(displayed.At(x, y)).(color.Gray)
+				if got != want {
+					t.Errorf("orientation %d: displayed (%d, %d) = %v, stored (%d, %d) = %v", o, x, y, got, sx/2, sy/2, want)
+				}
+			}
+		}
+	}
+}
+
+func TestOrientation_StoredAngle(t *testing.T) {
+	tests := []struct {
+		o    Orientation
+		deg  float64
+		want float64
+	}{
+		{o: OrientationNormal, deg: 30, want: 30},
+		{o: OrientationRotate180, deg: 0, want: 180},
+		{o: OrientationRotate90, deg: 0, want: 90},
+		{o: OrientationRotate270, deg: 0, want: -90},
+		{o: OrientationMirrorHorizontal, deg: 30, want: 150},
+		{o: OrientationMirrorVertical, deg: 30, want: -30},
+	}
+	for _, tt := range tests {
+		if got := tt.o.StoredAngle(tt.deg); got != tt.want {
+			t.Errorf("orientation %d: StoredAngle(%v) = %v, want %v", tt.o, tt.deg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package redact
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+)
+
+// Method selects how the face regions are redacted.
+type Method int
+
+const (
+	// MethodBlur applies a gaussian blur to the face regions.
+	MethodBlur Method = iota
+	// MethodPixelate replaces the face regions with large blocks of their average color.
+	MethodPixelate
+	// MethodFill paints the face regions with a solid color.
+	MethodFill
+)
+
+// Options control the redaction.
+type Options struct {
+	// Method is the way the face regions are redacted.
+	Method Method
+	// Padding extends the face bounds on every side by the given fraction of the face size.
+	Padding float64
+	// Strength is the standard deviation of the blur, or the size of the pixelation blocks, in
+	// pixels. It's raised for the faces it's too weak to make unrecognizable, zero always deriving
+	// it from the size of each face.
+	Strength int
+	// FillColor is the color MethodFill paints with. It replaces the face regions, its alpha
+	// included.
+	FillColor color.Color
+}
+
+// DefaultOptions returns the options used unless told otherwise.
+func DefaultOptions() Options {
+	return Options{
+		Method:    MethodBlur,
+		Padding:   0.1,
+		FillColor: color.Black,
+	}
+}
+
+// autoStrength is the fraction of the face size Options.Strength is raised to. It's large enough
+// for a face to be unrecognizable.
+const autoStrength = 0.1
+
+// Redact returns a copy of img with the regions of faces redacted. Face coordinates are relative
+// to the top left corner of img.
+func Redact(img image.Image, faces []facedetect.Face, opts Options) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+
+	for _, f := range faces {
+		if f.Bounds == nil {
+			continue
+		}
+		r := region(f.Bounds, opts.Padding).Intersect(dst.Rect)
+		if r.Empty() {
+			continue
+		}
+		strength := int(math.Ceil(autoStrength * float64(f.Bounds.Width)))
+		if opts.Strength > strength {
+			strength = opts.Strength
+		}
+
+		switch opts.Method {
+		case MethodBlur:
+			blur(dst, r, float64(strength))
+		case MethodPixelate:
+			pixelate(dst, r, strength)
+		case MethodFill:
+			// Drawn over, a translucent color would let the face show through.
+			draw.Draw(dst, r, image.NewUniform(opts.FillColor), image.Point{}, draw.Src)
+		}
+	}
+	return dst
+}
+
+// region returns the face bounds extended by padding times the face size on every side.
+func region(b *facedetect.Bounds, padding float64) image.Rectangle {
+	px := int(math.Round(padding * float64(b.Width)))
+	py := int(math.Round(padding * float64(b.Height)))
+	return image.Rect(b.X-px, b.Y-py, b.X+b.Width+px, b.Y+b.Height+py)
+}
+
+// pixelate replaces every size by size block of r with its average color.
+func pixelate(img *image.NRGBA, r image.Rectangle, size int) {
+	if size < 2 {
+		size = 2
+	}
+	for by := r.Min.Y; by < r.Max.Y; by += size {
+		for bx := r.Min.X; bx < r.Max.X; bx += size {
+			block := image.Rect(bx, by, bx+size, by+size).Intersect(r)
+			var sum [4]int
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					i := img.PixOffset(x, y)
+					for c := 0; c < 4; c++ {
+						sum[c] += int(img.Pix[i+c])
+					}
+				}
+			}
+			n := block.Dx() * block.Dy()
+			avg := color.NRGBA{R: uint8(sum[0] / n), G: uint8(sum[1] / n), B: uint8(sum[2] / n), A: uint8(sum[3] / n)}
+			draw.Draw(img, block, image.NewUniform(avg), image.Point{}, draw.Src)
+		}
+	}
+}
+
+// blurPasses is the number of box blurs approximating the gaussian blur.
+const blurPasses = 3
+
+// blur approximates a gaussian blur with standard deviation sigma of the pixels in r, using
+// repeated box blurs. Pixels outside r are left untouched and don't bleed in.
+func blur(img *image.NRGBA, r image.Rectangle, sigma float64) {
+	if sigma < 1 {
+		return
+	}
+	// Width of the box giving the requested deviation after blurPasses passes.
+	radius := int(math.Round((math.Sqrt(12*sigma*sigma/blurPasses+1) - 1) / 2))
+	if radius < 1 {
+		return
+	}
+
+	w, h := r.Dx(), r.Dy()
+	var ch [4][]float64
+	for c := range ch {
+		ch[c] = make([]float64, w*h)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := img.PixOffset(r.Min.X+x, r.Min.Y+y)
+			for c := 0; c < 4; c++ {
+				ch[c][y*w+x] = float64(img.Pix[i+c])
+			}
+		}
+	}
+
+	tmp := make([]float64, w*h)
+	for c := range ch {
+		for p := 0; p < blurPasses; p++ {
+			boxBlur(ch[c], tmp, w, h, 1, w, radius)
+			boxBlur(tmp, ch[c], h, w, w, 1, radius)
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := img.PixOffset(r.Min.X+x, r.Min.Y+y)
+			for c := 0; c < 4; c++ {
+				img.Pix[i+c] = uint8(math.Round(ch[c][y*w+x]))
+			}
+		}
+	}
+}
+
+// boxBlur averages every value of src over a window of radius values along lines of length n,
+// writing the result to dst. Values at step apart form a line, lines start stride apart. The
+// window is clamped to the line, so edge values are repeated.
+func boxBlur(src, dst []float64, n, lines, step, stride, radius int) {
+	at := func(line, i int) int {
+		if i < 0 {
+			i = 0
+		} else if i >= n {
+			i = n - 1
+		}
+		return line*stride + i*step
+	}
+	size := float64(2*radius + 1)
+	for l := 0; l < lines; l++ {
+		sum := 0.0
+		for i := -radius; i <= radius; i++ {
+			sum += src[at(l, i)]
+		}
+		for i := 0; i < n; i++ {
+			dst[at(l, i)] = sum / size
+			sum += src[at(l, i+radius+1)] - src[at(l, i-radius)]
+		}
+	}
+}
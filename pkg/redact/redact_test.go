@@ -0,0 +1,132 @@
+package redact
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/bokan/facedetection/pkg/facedetect"
+)
+
+// checkerboard returns a 100x100 image of alternating black and white pixels.
+func checkerboard() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			if (x+y)%2 == 0 {
+				img.Pix[img.PixOffset(x, y)] = 0xff
+			}
+		}
+	}
+	return img
+}
+
+func gray(img image.Image, x, y int) uint8 {
+	return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+}
+
+func TestRedact(t *testing.T) {
+	src := checkerboard()
+	faces := []facedetect.Face{{Bounds: &facedetect.Bounds{X: 20, Y: 20, Width: 40, Height: 40}}}
+
+	tests := []struct {
+		name string
+		opts Options
+		// check reports whether the pixel at x, y inside the padded face region is redacted.
+		check func(img image.Image, x, y int) bool
+	}{
+		{
+			name: "blur",
+			opts: Options{Method: MethodBlur, Padding: 0.25},
+			check: func(img image.Image, x, y int) bool {
+				g := gray(img, x, y)
+				return g > 0x60 && g < 0xa0
+			},
+		},
+		{
+			name: "pixelate",
+			opts: Options{Method: MethodPixelate, Padding: 0.25, Strength: 10},
+			check: func(img image.Image, x, y int) bool {
+				// Blocks are aligned to the top left corner of the region, at 10, 10.
+				bx, by := x-(x-10)%10, y-(y-10)%10
+				return gray(img, x, y) == gray(img, bx, by) && gray(img, x, y) == gray(img, bx+1, by)
+			},
+		},
+		{
+			name: "fill",
+			opts: Options{Method: MethodFill, Padding: 0.25, FillColor: color.Gray{Y: 0x40}},
+			check: func(img image.Image, x, y int) bool {
+				return gray(img, x, y) == 0x40
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := Redact(src, faces, tt.opts)
+			if out.Bounds() != src.Bounds() {
+				t.Fatalf("Redact() bounds = %v, want %v", out.Bounds(), src.Bounds())
+			}
+			// The padded region is 10, 10 - 70, 70.
+			for _, p := range []image.Point{{10, 10}, {40, 40}, {69, 69}, {15, 60}} {
+				if !tt.check(out, p.X, p.Y) {
+					t.Errorf("pixel at %v should be redacted, got %#x", p, gray(out, p.X, p.Y))
+				}
+			}
+			for _, p := range []image.Point{{9, 9}, {70, 70}, {5, 40}, {99, 99}} {
+				if got, want := gray(out, p.X, p.Y), gray(src, p.X, p.Y); got != want {
+					t.Errorf("pixel at %v outside of the region should be untouched, got %#x, want %#x", p, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedact_Translucent(t *testing.T) {
+	src := checkerboard()
+	faces := []facedetect.Face{{Bounds: &facedetect.Bounds{X: 20, Y: 20, Width: 40, Height: 40}}}
+	out := Redact(src, faces, Options{Method: MethodFill, FillColor: color.Transparent})
+	if got := out.NRGBAAt(30, 30); got != (color.NRGBA{}) {
+		t.Errorf("a transparent fill should replace the face pixels, got %v", got)
+	}
+}
+
+func TestRedact_MinStrength(t *testing.T) {
+	// A horizontal gradient, every column differs from its neighbors.
+	src := image.NewGray(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			src.Pix[src.PixOffset(x, y)] = uint8(2 * x)
+		}
+	}
+	faces := []facedetect.Face{{Bounds: &facedetect.Bounds{X: 20, Y: 20, Width: 40, Height: 40}}}
+	out := Redact(src, faces, Options{Method: MethodPixelate, Strength: 1})
+	// Blocks are raised to a tenth of the face size, 4 pixels from 20, 20.
+	if gray(out, 20, 20) != gray(out, 23, 20) || gray(out, 23, 20) == gray(out, 24, 20) {
+		t.Errorf("a weak strength should be raised to the face size, got %#x %#x %#x", gray(out, 20, 20), gray(out, 23, 20), gray(out, 24, 20))
+	}
+}
+
+func TestRedact_Clipped(t *testing.T) {
+	src := checkerboard()
+	// Bounds partially outside the image, and a face without bounds.
+	faces := []facedetect.Face{{Bounds: &facedetect.Bounds{X: 80, Y: -10, Width: 40, Height: 40}}, {}}
+	out := Redact(src, faces, Options{Method: MethodFill, FillColor: color.White})
+	if gray(out, 99, 0) != 0xff || gray(out, 80, 29) != 0xff {
+		t.Error("the part of the face inside the image should be redacted")
+	}
+	if gray(out, 79, 0) != gray(src, 79, 0) {
+		t.Error("pixels outside of the face should be untouched")
+	}
+}
+
+func TestRedact_Offset(t *testing.T) {
+	src := checkerboard().SubImage(image.Rect(50, 50, 100, 100))
+	faces := []facedetect.Face{{Bounds: &facedetect.Bounds{X: 0, Y: 0, Width: 10, Height: 10}}}
+	out := Redact(src, faces, Options{Method: MethodFill, FillColor: color.White})
+	if out.Bounds() != image.Rect(0, 0, 50, 50) {
+		t.Fatalf("Redact() bounds = %v, want the size of the image at the origin", out.Bounds())
+	}
+	if gray(out, 1, 0) != 0xff || gray(out, 10, 1) != gray(src, 60, 51) {
+		t.Error("face coordinates should be relative to the top left corner of the image")
+	}
+}